@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"log"
+
+	"spm/pkg/supervisor"
+
+	"github.com/spf13/cobra"
+)
+
+// activateCmd 由 supervisor 内部使用，为套接字激活的子进程设置 LISTEN_PID
+var activateCmd = &cobra.Command{
+	Use:    supervisor.ActivateCommand,
+	Hidden: true,
+	Run:    execActivateCmd,
+
+	DisableFlagParsing: true,
+}
+
+func init() {
+	// 不需要加载配置和初始化日志，避免在子进程里创建多余的文件
+	activateCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {}
+	rootCmd.AddCommand(activateCmd)
+}
+
+func execActivateCmd(cmd *cobra.Command, args []string) {
+	if err := supervisor.ExecActivated(args); err != nil {
+		log.Fatal(err)
+	}
+}
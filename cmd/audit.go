@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"spm/pkg/client"
+	"spm/pkg/codec"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Query the audit log of control requests",
+	Long: `Query the audit log of the daemon. Every request handled by the daemon is
+recorded with its time, peer, action, targets, result code and duration.`,
+	Example: `  spm audit --action restart --target 'prod::worker*' --since 12h
+  spm audit --peer alice --failed -n 20
+  spm audit --json`,
+	Args: cobra.NoArgs,
+	Run:  execAuditCmd,
+}
+
+var (
+	auditSince   string
+	auditUntil   string
+	auditPeer    string
+	auditActions []string
+	auditTarget  string
+	auditFailed  bool
+	auditLines   int
+	auditJSON    bool
+)
+
+func init() {
+	auditCmd.Flags().StringVar(&auditSince, "since", "", "Only show requests newer than a duration like 2h or a time like 2006-01-02T15:04:05Z")
+	auditCmd.Flags().StringVar(&auditUntil, "until", "", "Only show requests older than a duration like 30m or a time like 2006-01-02T15:04:05Z")
+	auditCmd.Flags().StringVar(&auditPeer, "peer", "", "Only show requests from a user name, uid or client certificate CN")
+	auditCmd.Flags().StringSliceVar(&auditActions, "action", nil, "Only show requests of these actions, like restart,stop")
+	auditCmd.Flags().StringVar(&auditTarget, "target", "", "Only show requests whose targets match a glob like prod::worker*")
+	auditCmd.Flags().BoolVar(&auditFailed, "failed", false, "Only show failed requests")
+	auditCmd.Flags().IntVarP(&auditLines, "lines", "n", 0, "Only show the last N matching requests")
+	auditCmd.Flags().BoolVar(&auditJSON, "json", false, "Print one JSON object per request")
+	setupCommandPreRun(auditCmd, requireDaemonRunning)
+	rootCmd.AddCommand(auditCmd)
+}
+
+// auditPrinter 输出审计记录，--json 时每行一个 JSON 对象
+type auditPrinter struct {
+	json bool
+	enc  *json.Encoder
+}
+
+func (p *auditPrinter) Print(e *codec.AuditEntry) {
+	if p.json {
+		_ = p.enc.Encode(e)
+		return
+	}
+
+	peer := e.Peer()
+	if e.Cert == "" && e.Uid >= 0 {
+		peer = fmt.Sprintf("%s(uid=%d,pid=%d)", peer, e.Uid, e.Pid)
+	}
+
+	targets := strings.Join(e.Targets, ",")
+	if len(e.Command) > 0 {
+		targets = strings.Join(e.Command, " ")
+	}
+	if e.Signal != "" {
+		targets = e.Signal + " " + targets
+	}
+	if targets == "" {
+		targets = "-"
+	}
+
+	line := fmt.Sprintf("%s %-6s %s %s %s %d %.1fms",
+		e.Time.Format(time.RFC3339), e.Via, peer, e.Action, targets, e.Code, e.Duration)
+	if e.Message != "" {
+		line += " " + e.Message
+	}
+
+	fmt.Println(line)
+}
+
+func execAuditCmd(cmd *cobra.Command, args []string) {
+	opts := client.AuditOptions{
+		Peer:    auditPeer,
+		Actions: auditActions,
+		Target:  auditTarget,
+		Failed:  auditFailed,
+		Lines:   auditLines,
+	}
+
+	var err error
+	if opts.Since, err = parseTimeFlag(auditSince); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: --since: %v\n", err)
+		os.Exit(1)
+	}
+	if opts.Until, err = parseTimeFlag(auditUntil); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: --until: %v\n", err)
+		os.Exit(1)
+	}
+
+	printer := &auditPrinter{
+		json: auditJSON,
+		enc:  json.NewEncoder(os.Stdout),
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	err = client.Audit(ctx, opts, printer.Print)
+	if err != nil && !isInterrupted(err) {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"log"
+	"os"
 
 	"spm/pkg/config"
 	"spm/pkg/supervisor"
@@ -46,4 +47,6 @@ func execDaemonCmd(cmd *cobra.Command, args []string) {
 
 	sv := supervisor.NewSupervisor()
 	sv.Daemon()
+
+	os.Exit(sv.ExitCode)
 }
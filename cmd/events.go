@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"spm/pkg/client"
+	"spm/pkg/codec"
+	"spm/pkg/utils"
+)
+
+var eventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "Watch process lifecycle events",
+	Long: `Subscribe to process lifecycle events and print them as they happen.
+
+Event types: starting, running, ready, failed, stopping, exited, crashed,
+crashloop, restarting, health_failed, reloaded and log_matched.`,
+	Example: `  spm events
+  spm events --project myapp --json`,
+	Args: cobra.NoArgs,
+	Run:  execEventsCmd,
+}
+
+var (
+	eventsProject string
+	eventsJSON    bool
+)
+
+func init() {
+	eventsCmd.Flags().StringVar(&eventsProject, "project", "", "Only show events of this project")
+	eventsCmd.Flags().BoolVar(&eventsJSON, "json", false, "Print one JSON object per event")
+	setupCommandPreRun(eventsCmd, requireDaemonRunning)
+	rootCmd.AddCommand(eventsCmd)
+}
+
+// eventPrinter 输出事件，--json 时每行一个 JSON 对象
+type eventPrinter struct {
+	json  bool
+	color bool
+	enc   *json.Encoder
+}
+
+func (p *eventPrinter) Print(ev *codec.Event) {
+	if p.json {
+		_ = p.enc.Encode(ev)
+		return
+	}
+
+	name := fmt.Sprintf("%s::%s.%d", ev.Project, ev.Process, ev.Instance)
+	if p.color {
+		name = utils.Colorize(utils.ColorFor(ev.Project+"::"+ev.Process), name)
+	}
+
+	fmt.Printf("%s %s %s\n", ev.Time.Format(time.RFC3339), name, ev.Describe())
+}
+
+func execEventsCmd(cmd *cobra.Command, args []string) {
+	printer := &eventPrinter{
+		json:  eventsJSON,
+		color: utils.ColorEnabled(os.Stdout),
+		enc:   json.NewEncoder(os.Stdout),
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	err := client.Events(ctx, eventsProject, printer.Print)
+	if err != nil && !isInterrupted(err) {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+}
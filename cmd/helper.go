@@ -1,10 +1,13 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strings"
 	"syscall"
 
@@ -15,6 +18,16 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// interruptContext 返回收到 Ctrl-C 或者 SIGTERM 时取消的 ctx，用于取消流式请求
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// isInterrupted 判断流式请求是否因为 interruptContext 被取消而结束
+func isInterrupted(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
+
 func killDaemon(sig syscall.Signal) {
 	spid, err := utils.ReadPid(config.GetConfig().PidFile)
 	if err != nil {
@@ -106,7 +119,7 @@ func parseProcessArgs(args []string, separator string) string {
 // 使用场景：
 //
 //	在需要守护进程运行的命令中（stop/restart/status/shutdown/reload）
-//	调用此函数确保守护进程已启动
+//	调用此函数确保守护进程已启动，连接远程 daemon 时不检查
 //
 // 使用示例：
 //
@@ -114,6 +127,10 @@ func parseProcessArgs(args []string, separator string) string {
 //	    requireDaemonRunning()
 //	}
 func requireDaemonRunning() {
+	if config.RemoteHost() != "" {
+		return
+	}
+
 	if !isDaemonRunning() {
 		log.Fatalln("ERROR: Supervisor has not started. Please check supervisor daemon.")
 	}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"spm/pkg/client"
+	"spm/pkg/codec"
+	"spm/pkg/config"
+	"spm/pkg/utils"
+)
+
+var (
+	logsFollow bool
+	logsLines  int
+	logsStdout bool
+	logsStderr bool
+	logsSince  time.Duration
+)
+
+var logsCmd = &cobra.Command{
+	Use:     "logs [processes...]",
+	Short:   "Show processes output",
+	Aliases: []string{"log"},
+	Run:     execLogsCmd,
+}
+
+func init() {
+	logsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "Follow the output of processes")
+	logsCmd.Flags().IntVarP(&logsLines, "lines", "n", 100, "Number of lines to show from the end of each log")
+	logsCmd.Flags().BoolVar(&logsStdout, "stdout", false, "Only show the standard output")
+	logsCmd.Flags().BoolVar(&logsStderr, "stderr", false, "Only show the standard error")
+	logsCmd.Flags().DurationVar(&logsSince, "since", 0, "Only show logs newer than a relative duration like 10m")
+
+	setupCommandPreRun(logsCmd, requireDaemonRunning)
+	rootCmd.AddCommand(logsCmd)
+}
+
+// logPrinter 按 foreman 的风格给每一行加上带颜色的进程名前缀
+type logPrinter struct {
+	color bool
+	width int
+}
+
+func (lp *logPrinter) Print(line *codec.LogLine) {
+	name := fmt.Sprintf("%s::%s", line.Project, line.Process)
+	lp.width = max(lp.width, len(name))
+
+	prefix := fmt.Sprintf("%-*s |", lp.width, name)
+	if lp.color {
+		prefix = utils.Colorize(utils.ColorFor(name), prefix)
+	}
+
+	text := strings.TrimRight(line.Line, "\r")
+
+	// 搜索结果带有文件和行号，和 grep 一样用 ':' 标记匹配行，'-' 标记上下文
+	if line.File != "" {
+		sep := ":"
+		if line.Context {
+			sep = "-"
+		}
+		text = fmt.Sprintf("%s%s%d%s %s", line.File, sep, line.LineNo, sep, text)
+	}
+
+	fmt.Printf("%s %s\n", prefix, text)
+}
+
+func execLogsCmd(cmd *cobra.Command, args []string) {
+	opts := client.LogOptions{
+		Follow: logsFollow,
+		Lines:  logsLines,
+	}
+
+	if logsStdout && !logsStderr {
+		opts.Stream = "stdout"
+	} else if logsStderr && !logsStdout {
+		opts.Stream = "stderr"
+	}
+
+	if logsSince > 0 {
+		opts.Since = time.Now().Add(-logsSince)
+	}
+
+	printer := &logPrinter{color: utils.ColorEnabled(os.Stdout)}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	err := client.Logs(ctx, config.WorkDirFlag, config.ProcfileFlag, opts, printer.Print, args...)
+	if err != nil && !isInterrupted(err) {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"spm/pkg/client"
+	"spm/pkg/config"
+	"spm/pkg/utils"
+)
+
+var (
+	grepFixed      bool
+	grepIgnoreCase bool
+	grepFields     []string
+	grepContext    int
+	grepStdout     bool
+	grepStderr     bool
+	grepSince      string
+	grepUntil      string
+)
+
+var logsGrepCmd = &cobra.Command{
+	Use:   "grep <pattern> [processes...]",
+	Short: "Search current and rotated process logs",
+	Long: `Search current and rotated process logs.
+
+--since and --until use the timestamps written by the timestamped and json
+log formats. Raw logs have no timestamps, so a time range is rejected for
+processes using the raw log format.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  execLogsGrepCmd,
+}
+
+func init() {
+	logsGrepCmd.Flags().BoolVarP(&grepFixed, "fixed-strings", "F", false, "Interpret the pattern as a fixed string")
+	logsGrepCmd.Flags().BoolVarP(&grepIgnoreCase, "ignore-case", "i", false, "Ignore case distinctions")
+	logsGrepCmd.Flags().StringArrayVar(&grepFields, "field", nil, "Only match JSON log lines whose field equals a value, like level=error")
+	logsGrepCmd.Flags().IntVarP(&grepContext, "context", "C", 0, "Number of context lines around each match")
+	logsGrepCmd.Flags().BoolVar(&grepStdout, "stdout", false, "Only search the standard output")
+	logsGrepCmd.Flags().BoolVar(&grepStderr, "stderr", false, "Only search the standard error")
+	logsGrepCmd.Flags().StringVar(&grepSince, "since", "", "Only search logs newer than a duration like 2h or a time like 2006-01-02T15:04:05Z")
+	logsGrepCmd.Flags().StringVar(&grepUntil, "until", "", "Only search logs older than a duration like 30m or a time like 2006-01-02T15:04:05Z")
+
+	logsCmd.AddCommand(logsGrepCmd)
+}
+
+// parseTimeFlag 把相对时长或者时间字符串转换为时间点，空字符串返回零值
+func parseTimeFlag(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, time.DateTime, time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+func execLogsGrepCmd(cmd *cobra.Command, args []string) {
+	opts := client.GrepOptions{
+		Pattern:    args[0],
+		Fixed:      grepFixed,
+		IgnoreCase: grepIgnoreCase,
+		Fields:     grepFields,
+		Context:    grepContext,
+	}
+
+	if grepStdout && !grepStderr {
+		opts.Stream = "stdout"
+	} else if grepStderr && !grepStdout {
+		opts.Stream = "stderr"
+	}
+
+	var err error
+	if opts.Since, err = parseTimeFlag(grepSince); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: --since: %v\n", err)
+		os.Exit(1)
+	}
+	if opts.Until, err = parseTimeFlag(grepUntil); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: --until: %v\n", err)
+		os.Exit(1)
+	}
+
+	printer := &logPrinter{color: utils.ColorEnabled(os.Stdout)}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	err = client.LogGrep(ctx, config.WorkDirFlag, config.ProcfileFlag, opts, printer.Print, args[1:]...)
+	if err != nil && !isInterrupted(err) {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+}
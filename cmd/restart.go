@@ -7,6 +7,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"spm/pkg/client"
+	"spm/pkg/codec"
 	"spm/pkg/config"
 )
 
@@ -23,13 +24,11 @@ func init() {
 }
 
 func execRestartCmd(cmd *cobra.Command, args []string) {
-	res := client.Restart(config.WorkDirFlag, config.ProcfileFlag, args...)
+	// 每个进程重启完成后立即输出，不等待所有进程完成
+	res := client.RestartProgress(config.WorkDirFlag, config.ProcfileFlag, func(proc *codec.ProcInfo) {
+		fmt.Printf("[%s] Restarted %s\t[PID %d]\n", proc.StartAt.Format(time.RFC3339), proc.Name, proc.Pid)
+	}, args...)
 	if res == nil {
 		fmt.Println("No processes to restart.")
-		return
-	}
-
-	for _, proc := range res {
-		fmt.Printf("[%s] Restarted %s\t[PID %d]\n", proc.StartAt.Format(time.RFC3339), proc.Name, proc.Pid)
 	}
 }
@@ -48,6 +48,7 @@ func init() {
 	rootCmd.PersistentFlags().StringVarP(&config.LogLevelFlag, "loglevel", "l", "", "Set log level")
 	rootCmd.PersistentFlags().StringVarP(&config.WorkDirFlag, "workdir", "w", "", "The path to the work directory")
 	rootCmd.PersistentFlags().StringVarP(&config.ProcfileFlag, "procfile", "p", "", "The path to the Procfile")
+	rootCmd.PersistentFlags().StringVar(&config.HostFlag, "host", "", "Address of a remote daemon, overrides SPM_HOST")
 
 	// Register persistent function for all commands
 	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
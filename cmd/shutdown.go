@@ -26,19 +26,30 @@ func init() {
 
 func execShutdownCmd(cmd *cobra.Command, args []string) {
 	// 使用 channel 异步执行 RPC 调用
-	done := make(chan struct{})
+	done := make(chan error, 1)
 	go func() {
-		_ = client.Shutdown(config.WorkDirFlag, config.ProcfileFlag)
-		done <- struct{}{}
+		done <- client.Shutdown(config.WorkDirFlag, config.ProcfileFlag)
 		close(done)
 	}()
 
 	// 等待 RPC 响应或超时
 	select {
-	case <-done:
+	case err := <-done:
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot shutdown supervisor: %v\n", err)
+			os.Exit(1)
+		}
 		fmt.Println("All processes has been stopped.")
+		// 远程 daemon 处理完 shutdown 请求后自己退出
+		if config.RemoteHost() != "" {
+			break
+		}
 		killDaemon(syscall.SIGQUIT)
 	case <-time.After(5 * time.Second):
+		if config.RemoteHost() != "" {
+			fmt.Fprintln(os.Stderr, "Shutdown remote supervisor timeout.")
+			os.Exit(1)
+		}
 		fmt.Println("Shutdown processes timeout. Force kill supervisor.")
 		killDaemon(syscall.SIGKILL)
 		_ = os.Remove(config.GetConfig().PidFile)
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"spm/pkg/client"
+	"spm/pkg/config"
+)
+
+var signalCmd = &cobra.Command{
+	Use:   "signal SIGNAL [process...]",
+	Short: "Send a signal to processes",
+	Long: `Send a signal to the process group of each running process.
+
+The signal can be a name with or without the SIG prefix, like HUP or SIGUSR1,
+or a signal number.`,
+	Example: `  spm signal HUP web
+  spm signal USR1`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  execSignalCmd,
+}
+
+func init() {
+	setupCommandPreRun(signalCmd, requireDaemonRunning)
+	rootCmd.AddCommand(signalCmd)
+}
+
+func execSignalCmd(cmd *cobra.Command, args []string) {
+	res := client.Signal(config.WorkDirFlag, config.ProcfileFlag, args[0], args[1:]...)
+	if res == nil {
+		fmt.Println("No processes to signal.")
+		return
+	}
+
+	for _, proc := range res {
+		fmt.Printf("%s::%s\t[PID %d] %s\n", proc.Project, proc.Name, proc.Pid, proc.Status)
+	}
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"log"
+	"os"
 	"spm/pkg/supervisor"
 	"time"
 
@@ -20,9 +21,17 @@ var startCmd = &cobra.Command{
 
 func init() {
 	startCmd.PersistentFlags().BoolVarP(&config.ForegroundFlag, "foreground", "f", false, "Run the supervisor in the foreground")
+	startCmd.PersistentFlags().BoolVar(&config.ExitOnFailureFlag, "exit-on-failure", false, "Stop all processes and exit when any process exits (foreground only)")
 
 	// start命令特殊处理：尝试启动daemon而不是要求daemon已运行
 	setupCommandPreRun(startCmd, func() {
+		if config.RemoteHost() != "" {
+			if config.ForegroundFlag {
+				log.Fatalln("ERROR: Cannot run a remote supervisor in the foreground.")
+			}
+			return
+		}
+
 		if !config.ForegroundFlag {
 			if isDaemonRunning() {
 				return
@@ -71,6 +80,9 @@ func execStartCmd(cmd *cobra.Command, args []string) {
 			sendStartCmd(args)
 		}
 		sv.Daemon()
+
+		// 因进程失败退出时使用失败进程的退出码
+		os.Exit(sv.ExitCode)
 	} else {
 		sendStartCmd(args)
 	}
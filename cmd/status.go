@@ -2,11 +2,13 @@ package cmd
 
 import (
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/spf13/cobra"
 
 	"spm/pkg/client"
+	"spm/pkg/codec"
 	"spm/pkg/config"
 )
 
@@ -17,7 +19,10 @@ var statusCmd = &cobra.Command{
 	Run:     execStatusCmd,
 }
 
+var statusHistory bool
+
 func init() {
+	statusCmd.Flags().BoolVarP(&statusHistory, "history", "H", false, "Show the exit history of processes")
 	setupCommandPreRun(statusCmd, requireDaemonRunning)
 	rootCmd.AddCommand(statusCmd)
 }
@@ -51,6 +56,57 @@ func execStatusCmd(cmd *cobra.Command, args []string) {
 			uptime = "0s"
 		}
 
-		fmt.Printf("ID: %d\tProject: %s\tProcess: %s\tState: %s\tPID: %d\tUptime: %s\n", proc.ID, proc.Project, proc.Name, proc.Status, proc.Pid, uptime)
+		port := "-"
+		if proc.Port > 0 {
+			port = strconv.Itoa(proc.Port)
+		}
+
+		state := string(proc.Status)
+		if proc.Status == codec.ProcessRunning && !proc.Ready {
+			state += " (not ready)"
+		}
+
+		fmt.Printf("ID: %d\tProject: %s\tProcess: %s\tState: %s\tPID: %d\tPort: %s\tUptime: %s", proc.ID, proc.Project, proc.Name, state, proc.Pid, port, uptime)
+		if proc.StatusText != "" {
+			fmt.Printf("\tStatus: %s", proc.StatusText)
+		}
+		if proc.LogDropped > 0 {
+			fmt.Printf("\tDropped: %d lines", proc.LogDropped)
+		}
+		fmt.Println()
+
+		printTail(proc.Tail)
+		printHistory(proc)
+	}
+}
+
+// printHistory 输出进程的退出记录，默认只在进程没有运行时输出最近一次退出
+func printHistory(proc *codec.ProcInfo) {
+	if len(proc.History) == 0 {
+		return
+	}
+
+	history := proc.History
+	if !statusHistory {
+		if proc.Status == codec.ProcessRunning {
+			return
+		}
+		history = history[len(history)-1:]
+	}
+
+	for _, h := range history {
+		reason := fmt.Sprintf("code %d", h.ExitCode)
+		if h.Signal != "" {
+			reason = "signal " + h.Signal
+		}
+
+		fmt.Printf("  Exited: %s\tPID: %d\tAt: %s\tRan: %s\n", reason, h.Pid, h.StopAt.Format(time.DateTime), h.StopAt.Sub(h.StartAt).Round(time.Millisecond))
+		printTail(h.Tail)
+	}
+}
+
+func printTail(lines []string) {
+	for _, line := range lines {
+		fmt.Printf("    | %s\n", line)
 	}
 }
@@ -7,11 +7,14 @@
 //  1. 隔离 cmd 层与 supervisor 内部实现细节
 //  2. 消除重复的消息构造逻辑
 //  3. 提供易于测试和维护的接口
-//  4. 为未来扩展（如 HTTP API）预留空间
+//  4. 与 supervisor 的 HTTP API 共用同一套请求消息
 package client
 
 import (
+	"context"
+	"fmt"
 	"strings"
+	"time"
 
 	"spm/pkg/codec"
 	"spm/pkg/supervisor"
@@ -77,6 +80,33 @@ func Stop(workDir, procfile string, processes ...string) []*codec.ProcInfo {
 	return supervisor.ClientRun(msg)
 }
 
+// Signal 向一个或多个进程发送信号
+//
+// 参数：
+//
+//	workDir: 工作目录路径
+//	procfile: Procfile 配置文件路径
+//	signal: 信号名或者信号编号，例如 HUP、SIGUSR1 或 10
+//	processes: 进程名列表，如果为空则发送给所有进程
+//
+// 返回：
+//
+//	[]*supervisor.ProcInfo: 收到信号的进程信息列表
+//	  - 如果没有进程收到信号，返回 nil
+//
+// 使用示例：
+//
+//	// 通知所有进程重新打开日志
+//	infos := client.Signal("/path/to/workdir", "Procfile", "USR1")
+//
+// 注意事项：
+//   - 信号发送给进程组，没有运行的进程会被跳过
+func Signal(workDir, procfile, signal string, processes ...string) []*codec.ProcInfo {
+	msg := buildActionMsg(codec.ActionSignal, workDir, procfile, processes)
+	msg.Signal = signal
+	return supervisor.ClientRun(msg)
+}
+
 // Restart 重启一个或多个进程
 //
 // 参数：
@@ -107,6 +137,26 @@ func Restart(workDir, procfile string, processes ...string) []*codec.ProcInfo {
 	return supervisor.ClientRun(msg)
 }
 
+// RestartProgress 重启一个或多个进程，每个进程重启完成后立即调用 progress
+//
+// 参数：
+//
+//	workDir: 工作目录路径
+//	procfile: Procfile 配置文件路径
+//	progress: 处理单个进程重启结果的回调函数
+//	processes: 进程名列表，如果为空则重启所有进程
+//
+// 返回：
+//
+//	[]*supervisor.ProcInfo: 重启的进程信息列表，与 Restart 相同
+//
+// 注意事项：
+//   - 重启所有进程时会先停止所有进程，再逐个启动并返回进度
+func RestartProgress(workDir, procfile string, progress func(*codec.ProcInfo), processes ...string) []*codec.ProcInfo {
+	msg := buildActionMsg(codec.ActionRestart, workDir, procfile, processes)
+	return supervisor.ClientProgress(msg, progress)
+}
+
 // Status 查询一个或多个进程的状态
 //
 // 参数：
@@ -174,7 +224,7 @@ func Reload(workDir, procfile string) []*codec.ProcInfo {
 //
 // 返回：
 //
-//	[]*supervisor.ProcInfo: 始终返回 nil（关闭操作不返回进程信息）
+//	error: 无法连接 daemon 或者 daemon 拒绝请求时返回错误
 //
 // 使用示例：
 //
@@ -184,13 +234,33 @@ func Reload(workDir, procfile string) []*codec.ProcInfo {
 //   - 此操作会停止所有管理的进程并关闭 supervisor daemon
 //   - 操作是异步的，调用后 daemon 会在短时间内关闭
 //   - 客户端可能会因为 daemon 关闭而收不到响应（这是正常的）
-func Shutdown(workDir, procfile string) []*codec.ProcInfo {
+//   - daemon 拒绝请求时（例如没有权限）返回错误
+func Shutdown(workDir, procfile string) error {
 	msg := &codec.ActionMsg{
 		Action:   codec.ActionShutdown,
 		WorkDir:  workDir,
 		Procfile: procfile,
 	}
-	return supervisor.ClientRun(msg)
+
+	c, err := supervisor.Dial()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = c.Close()
+	}()
+
+	// daemon 关闭时连接可能先断开，只把 daemon 拒绝请求作为错误
+	var resErr error
+	_ = c.Do(context.Background(), msg, func(res *codec.ResponseMsg) bool {
+		if res.Code != 200 {
+			resErr = fmt.Errorf("%d %s", res.Code, res.Message)
+		}
+		return true
+	})
+
+	return resErr
 }
 
 // Run 将一个命令作为进程运行
@@ -282,3 +352,191 @@ func buildActionMsg(action codec.ActionCtl, workDir, procfile string, processes
 		Processes: procs,
 	}
 }
+
+// LogOptions 是读取进程日志的选项
+type LogOptions struct {
+	Follow bool      // 持续输出新写入的日志
+	Lines  int       // 每个日志文件输出的最后行数
+	Stream string    // stdout 或 stderr，为空时表示全部
+	Since  time.Time // 只输出这个时间之后的日志
+}
+
+// Logs 读取一个或多个进程的日志
+//
+// 参数：
+//
+//	ctx: 取消时通知 daemon 停止发送日志
+//	workDir: 工作目录路径
+//	procfile: Procfile 配置文件路径
+//	opts: 日志选项
+//	handler: 处理每一行日志的回调函数
+//	processes: 进程名列表，如果为空则读取当前项目所有进程的日志
+//
+// 返回：
+//
+//	error: 连接失败或者 daemon 返回错误时返回
+//
+// 注意事项：
+//   - follow 模式下此函数会一直阻塞，直到连接断开
+func Logs(ctx context.Context, workDir, procfile string, opts LogOptions, handler func(*codec.LogLine), processes ...string) error {
+	msg := buildActionMsg(codec.ActionLog, workDir, procfile, processes)
+	msg.Follow = opts.Follow
+	msg.Lines = opts.Lines
+	msg.Stream = opts.Stream
+	msg.Since = opts.Since
+
+	return streamLogs(ctx, msg, handler)
+}
+
+// streamLogs 发送请求并把流式响应中的日志行交给 handler 处理
+func streamLogs(ctx context.Context, msg *codec.ActionMsg, handler func(*codec.LogLine)) error {
+	var resErr error
+	err := supervisor.ClientStream(ctx, msg, func(res *codec.ResponseMsg) bool {
+		if res.Code != 200 {
+			resErr = fmt.Errorf("%d %s", res.Code, res.Message)
+			return false
+		}
+
+		for _, line := range res.Logs {
+			handler(line)
+		}
+
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return resErr
+}
+
+// GrepOptions 是搜索日志的选项
+type GrepOptions struct {
+	Pattern    string    // 搜索的正则表达式或者字符串
+	Fixed      bool      // Pattern 是普通字符串
+	IgnoreCase bool      // 忽略大小写
+	Fields     []string  // JSON 日志的字段过滤条件，格式为 key=value
+	Context    int       // 匹配行前后输出的行数
+	Stream     string    // stdout 或 stderr，为空时表示全部
+	Since      time.Time // 只搜索这个时间之后的日志
+	Until      time.Time // 只搜索这个时间之前的日志
+}
+
+// LogGrep 在进程当前和已轮转的日志文件中搜索
+//
+// 参数：
+//
+//	ctx: 取消时通知 daemon 停止搜索
+//	workDir: 工作目录路径
+//	procfile: Procfile 配置文件路径
+//	opts: 搜索选项
+//	handler: 处理每一行结果的回调函数，上下文行的 Context 为 true
+//	processes: 进程名列表，如果为空则搜索当前项目所有进程的日志
+//
+// 返回：
+//
+//	error: 连接失败或者 daemon 返回错误时返回
+func LogGrep(ctx context.Context, workDir, procfile string, opts GrepOptions, handler func(*codec.LogLine), processes ...string) error {
+	msg := buildActionMsg(codec.ActionLogGrep, workDir, procfile, processes)
+	msg.Pattern = opts.Pattern
+	msg.Fixed = opts.Fixed
+	msg.IgnoreCase = opts.IgnoreCase
+	msg.Fields = opts.Fields
+	msg.Context = opts.Context
+	msg.Stream = opts.Stream
+	msg.Since = opts.Since
+	msg.Until = opts.Until
+
+	return streamLogs(ctx, msg, handler)
+}
+
+// AuditOptions 是查询审计日志的条件
+type AuditOptions struct {
+	Since   time.Time // 只查询这个时间之后的记录
+	Until   time.Time // 只查询这个时间之前的记录
+	Peer    string    // 用户名、uid 或者证书 CN
+	Actions []string  // 动作的名称，例如 restart、stop
+	Target  string    // 目标的 glob，例如 prod::worker*
+	Failed  bool      // 只查询失败的请求
+	Lines   int       // 只返回最后的记录数，0 表示全部
+}
+
+// Audit 查询 daemon 的审计日志
+//
+// 参数：
+//
+//	ctx: 取消时通知 daemon 停止查询
+//	opts: 查询条件
+//	handler: 处理每一条记录的回调函数，记录按时间顺序返回
+//
+// 返回：
+//
+//	error: 连接失败或者 daemon 返回错误时返回
+func Audit(ctx context.Context, opts AuditOptions, handler func(*codec.AuditEntry)) error {
+	msg := &codec.ActionMsg{
+		Action:  codec.ActionAudit,
+		Since:   opts.Since,
+		Until:   opts.Until,
+		Peer:    opts.Peer,
+		Actions: opts.Actions,
+		Target:  opts.Target,
+		Failed:  opts.Failed,
+		Lines:   opts.Lines,
+	}
+
+	var resErr error
+	err := supervisor.ClientStream(ctx, msg, func(res *codec.ResponseMsg) bool {
+		if res.Code != 200 {
+			resErr = fmt.Errorf("%d %s", res.Code, res.Message)
+			return false
+		}
+
+		for _, e := range res.Audits {
+			handler(e)
+		}
+
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return resErr
+}
+
+// Events 订阅进程生命周期事件，直到 ctx 被取消或者连接断开
+//
+// 参数：
+//
+//	ctx: 取消时结束订阅
+//	project: 只订阅这个项目的事件，为空时订阅所有项目
+//	handler: 处理每一个事件的回调函数
+//
+// 返回：
+//
+//	error: 连接失败或者 daemon 返回错误时返回
+func Events(ctx context.Context, project string, handler func(*codec.Event)) error {
+	msg := &codec.ActionMsg{
+		Action:   codec.ActionEvents,
+		Projects: project,
+	}
+
+	var resErr error
+	err := supervisor.ClientStream(ctx, msg, func(res *codec.ResponseMsg) bool {
+		if res.Code != 200 {
+			resErr = fmt.Errorf("%d %s", res.Code, res.Message)
+			return false
+		}
+
+		for _, ev := range res.Events {
+			handler(ev)
+		}
+
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return resErr
+}
@@ -1,5 +1,10 @@
 package codec
 
+import (
+	"fmt"
+	"time"
+)
+
 type ActionCtl int
 
 const (
@@ -14,14 +19,51 @@ const (
 	ActionRestart
 	ActionShutdown
 	ActionReload
+	ActionLogGrep
+	ActionEvents
+	ActionSignal
+	ActionAudit
 )
 
+// actionNames 是动作的名称，用于访问控制规则和审计日志
+var actionNames = [...]string{
+	ActionRun:      "run",
+	ActionLog:      "logs",
+	ActionKill:     "kill",
+	ActionDump:     "dump",
+	ActionLoad:     "load",
+	ActionStart:    "start",
+	ActionStop:     "stop",
+	ActionStatus:   "status",
+	ActionRestart:  "restart",
+	ActionShutdown: "shutdown",
+	ActionReload:   "reload",
+	ActionLogGrep:  "grep",
+	ActionEvents:   "events",
+	ActionSignal:   "signal",
+	ActionAudit:    "audit",
+}
+
+// String 返回动作的名称，未知的动作返回 action(n)
+func (a ActionCtl) String() string {
+	if a >= 0 && int(a) < len(actionNames) {
+		return actionNames[a]
+	}
+
+	return fmt.Sprintf("action(%d)", int(a))
+}
+
 var ActionResponse = map[ActionCtl]string{
 	ActionRun:     "Run command successfully",
 	ActionStart:   "Start processes successfully",
 	ActionStop:    "Stop processes successfully",
 	ActionStatus:  "Check processes status successfully",
 	ActionRestart: "Restart processes successfully",
+	ActionLog:     "Read logs successfully",
+	ActionLogGrep: "Search logs successfully",
+	ActionEvents:  "Subscribe events successfully",
+	ActionSignal:  "Send signal successfully",
+	ActionAudit:   "Query audit log successfully",
 }
 
 type ActionMsg struct {
@@ -31,4 +73,30 @@ type ActionMsg struct {
 	Projects  string    `cbor:",omitempty"`
 	Processes string    `cbor:",omitempty"`
 	CmdLine   []string  `cbor:",omitempty"`
+
+	// Progress 要求批量操作每处理完一个进程就返回一条部分响应
+	Progress bool `cbor:",omitempty"`
+
+	// 以下字段用于 ActionLog
+	Follow bool      `cbor:",omitempty"` // 持续输出新的日志
+	Lines  int       `cbor:",omitempty"` // 每个日志文件输出的最后行数
+	Stream string    `cbor:",omitempty"` // stdout 或 stderr，为空时表示全部
+	Since  time.Time `cbor:",omitempty"` // 只输出这个时间之后的日志
+
+	// 以下字段用于 ActionLogGrep，同时使用上面的 Stream 和 Since
+	Pattern    string    `cbor:",omitempty"` // 搜索的正则表达式或者字符串
+	Fixed      bool      `cbor:",omitempty"` // Pattern 是普通字符串
+	IgnoreCase bool      `cbor:",omitempty"` // 忽略大小写
+	Fields     []string  `cbor:",omitempty"` // JSON 日志的字段过滤条件，格式为 key=value
+	Context    int       `cbor:",omitempty"` // 匹配行前后输出的行数
+	Until      time.Time `cbor:",omitempty"` // 只搜索这个时间之前的日志
+
+	// Signal 是 ActionSignal 发送的信号名，例如 HUP 或者 SIGUSR1
+	Signal string `cbor:",omitempty"`
+
+	// 以下字段用于 ActionAudit，同时使用上面的 Since、Until 和 Lines
+	Peer    string   `cbor:",omitempty"` // 用户名、uid 或者证书 CN
+	Actions []string `cbor:",omitempty"` // 动作的名称
+	Target  string   `cbor:",omitempty"` // 目标的 glob，例如 prod::worker*
+	Failed  bool     `cbor:",omitempty"` // 只返回失败的请求
 }
@@ -0,0 +1,41 @@
+package codec
+
+import "time"
+
+// AuditEntry 是审计日志中的一条记录，对应 daemon 处理的一个请求
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	Via  string    `json:"via"` // 请求的来源：socket、http 或者 remote
+
+	// 本机连接对端的用户名、uid 和 pid，无法识别时 uid 和 pid 为 -1
+	User string `json:"user,omitempty"`
+	Uid  int    `json:"uid"`
+	Pid  int    `json:"pid"`
+
+	// 远程连接的客户端证书 CN 和对端地址
+	Cert string `json:"cert,omitempty"`
+	Addr string `json:"addr,omitempty"`
+
+	Action  string   `json:"action"`
+	WorkDir string   `json:"work_dir,omitempty"`
+	Targets []string `json:"targets,omitempty"` // 请求涉及的项目或者进程，进程的格式为 project::name
+	Command []string `json:"command,omitempty"` // run 请求的命令行
+	Signal  string   `json:"signal,omitempty"`  // signal 请求的信号
+
+	// Code 是最终响应的状态码，0 表示请求在得到最终响应之前被取消
+	Code     int     `json:"code"`
+	Message  string  `json:"message,omitempty"` // 请求失败时的错误信息
+	Duration float64 `json:"duration_ms"`       // 处理请求的时间，单位为毫秒
+}
+
+// Peer 返回发起请求的身份，远程连接是证书 CN，本机连接是用户名
+func (e *AuditEntry) Peer() string {
+	if e.Cert != "" {
+		return "cert:" + e.Cert
+	}
+	if e.User != "" {
+		return e.User
+	}
+
+	return "-"
+}
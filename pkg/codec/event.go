@@ -0,0 +1,81 @@
+package codec
+
+import (
+	"fmt"
+	"time"
+)
+
+// EventType 是进程生命周期事件的类型
+type EventType string
+
+const (
+	EventStarting     EventType = "starting"      // 开始启动进程
+	EventRunning      EventType = "running"       // 进程已经启动
+	EventReady        EventType = "ready"         // 进程已就绪
+	EventFailed       EventType = "failed"        // 进程启动失败
+	EventStopping     EventType = "stopping"      // 开始停止进程
+	EventExited       EventType = "exited"        // 进程退出，ExitCode 或 Signal 是退出原因
+	EventCrashed      EventType = "crashed"       // 进程不是被 spm 停止的，而是自己退出
+	EventCrashLoop    EventType = "crashloop"     // 进程在短时间内多次崩溃
+	EventRestarting   EventType = "restarting"    // 开始重启进程
+	EventHealthFailed EventType = "health_failed" // 看门狗超时
+	EventReloaded     EventType = "reloaded"      // 进程的配置已重新加载
+	EventLogMatched   EventType = "log_matched"   // 日志触发器匹配，Message 是匹配的行
+)
+
+// Event 是一个进程生命周期事件
+type Event struct {
+	Time     time.Time `json:"time"`
+	Type     EventType `json:"type"`
+	Project  string    `json:"project"`
+	Process  string    `json:"process"`
+	Instance int       `json:"instance"`
+	Pid      int       `json:"pid,omitempty"`
+	ExitCode int       `json:"exit_code,omitempty"`
+	Signal   string    `json:"signal,omitempty"`
+	Message  string    `json:"message,omitempty"`
+}
+
+// Describe 返回事件的简短描述，不包含时间和进程名
+func (e *Event) Describe() string {
+	var text string
+
+	switch e.Type {
+	case EventStarting:
+		text = "starting"
+	case EventRunning:
+		text = fmt.Sprintf("started with pid %d", e.Pid)
+	case EventReady:
+		text = "is ready"
+	case EventFailed:
+		text = "failed to start"
+	case EventStopping:
+		text = fmt.Sprintf("stopping pid %d", e.Pid)
+	case EventExited:
+		if e.Signal != "" {
+			text = fmt.Sprintf("killed by %s", e.Signal)
+		} else {
+			text = fmt.Sprintf("exited with code %d", e.ExitCode)
+		}
+	case EventCrashed:
+		text = fmt.Sprintf("crashed with code %d", e.ExitCode)
+	case EventCrashLoop:
+		text = "is crash looping"
+	case EventRestarting:
+		text = "restarting"
+	case EventHealthFailed:
+		text = "health check failed"
+	case EventReloaded:
+		text = "configuration reloaded"
+	case EventLogMatched:
+		text = "log trigger matched"
+	default:
+		text = string(e.Type)
+	}
+
+	if e.Message != "" {
+		text += ": " + e.Message
+	}
+
+	return text
+}
@@ -0,0 +1,174 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 控制 socket 的线路协议
+//
+// 连接建立后双方先交换 12 字节的握手数据：8 个 0 字节、"SPM" 和 1 字节的协议版本。
+// 旧版本的 spm 使用 64 字节的长度前缀，消息长度在前 8 个字节中。旧版本的 daemon
+// 从握手数据中读到 0 长度后会返回错误而不是解析出乱码；旧版本的客户端发来的前 8 个
+// 字节不为 0，daemon 可以据此识别并返回明确的错误。
+//
+// 握手之后每条消息是一个帧：4 字节长度、4 字节请求 ID、1 字节帧类型和 CBOR 编码的内容，
+// 整数都使用大端字节序，内容长度不能超过 MaxFrameSize。
+//
+// 一个连接上可以同时进行多个请求，帧通过请求 ID 对应到请求。一个请求的响应由零到多个
+// FramePartial 和最后一个 FrameResponse 组成，客户端发送同一 ID 的 FrameCancel 取消请求。
+
+// ProtocolVersion 是当前的协议版本，没有握手的旧协议版本为 1
+const ProtocolVersion uint8 = 2
+
+// MaxFrameSize 是一个帧内容的最大字节数
+const MaxFrameSize = 32 << 20
+
+const protocolMagic = "SPM"
+
+const (
+	legacyLengthSize = 8  // 旧协议长度前缀中有效的字节数
+	legacyHeaderSize = 64 // 旧协议的长度前缀是 strconv.IntSize 个字节
+	handshakeSize    = legacyLengthSize + len(protocolMagic) + 1
+	frameHeaderSize  = 9
+)
+
+// FrameType 是帧的类型
+type FrameType uint8
+
+const (
+	FrameRequest  FrameType = iota + 1 // 客户端发送的请求
+	FrameResponse                      // daemon 返回的最后一条响应，请求到此结束
+	FramePartial                       // daemon 返回的部分响应，后面还有更多响应
+	FrameCancel                        // 客户端取消请求，没有内容
+)
+
+// Frame 是握手之后传输的一条消息
+type Frame struct {
+	Type    FrameType
+	ID      uint32
+	Payload []byte
+}
+
+var (
+	// ErrIncompatible 表示对端使用了不兼容的协议版本
+	ErrIncompatible = errors.New("incompatible daemon version")
+
+	// ErrFrameTooLarge 表示帧内容超过了 MaxFrameSize
+	ErrFrameTooLarge = errors.New("message exceeds maximum frame size")
+)
+
+// LegacyFrameError 表示对端使用没有握手的旧协议，Length 是旧协议消息的长度
+type LegacyFrameError struct {
+	Length uint64
+}
+
+func (e *LegacyFrameError) Error() string {
+	return fmt.Sprintf("%v: peer uses legacy protocol version 1", ErrIncompatible)
+}
+
+func (e *LegacyFrameError) Unwrap() error {
+	return ErrIncompatible
+}
+
+// WriteHandshake 发送本端的握手数据
+func WriteHandshake(w io.Writer) error {
+	buf := make([]byte, handshakeSize)
+	copy(buf[legacyLengthSize:], protocolMagic)
+	buf[handshakeSize-1] = ProtocolVersion
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadHandshake 读取对端的握手数据
+//
+// 返回：
+//
+//	uint8: 对端的协议版本
+//	error: 对端使用旧协议时返回 *LegacyFrameError，握手数据无效时返回 ErrIncompatible
+func ReadHandshake(r io.Reader) (uint8, error) {
+	head := make([]byte, legacyLengthSize)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, err
+	}
+
+	if length := binary.BigEndian.Uint64(head); length != 0 {
+		return 0, &LegacyFrameError{Length: length}
+	}
+
+	rest := make([]byte, handshakeSize-legacyLengthSize)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return 0, err
+	}
+
+	if string(rest[:len(protocolMagic)]) != protocolMagic {
+		return 0, fmt.Errorf("%w: invalid handshake", ErrIncompatible)
+	}
+
+	return rest[len(protocolMagic)], nil
+}
+
+// WriteFrame 发送一个帧，帧头和内容在一次写入中发送
+func WriteFrame(w io.Writer, f *Frame) error {
+	if len(f.Payload) > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	buf := make([]byte, frameHeaderSize+len(f.Payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(f.Payload)))
+	binary.BigEndian.PutUint32(buf[4:8], f.ID)
+	buf[8] = byte(f.Type)
+	copy(buf[frameHeaderSize:], f.Payload)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadFrame 读取一个完整的帧
+func ReadFrame(r io.Reader) (*Frame, error) {
+	head := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(head[0:4])
+	if length > MaxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+
+	f := &Frame{
+		ID:      binary.BigEndian.Uint32(head[4:8]),
+		Type:    FrameType(head[8]),
+		Payload: make([]byte, length),
+	}
+
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// WriteLegacy 按旧协议发送一条消息：64 字节长度前缀和消息内容，只用于向旧客户端返回错误
+func WriteLegacy(w io.Writer, payload []byte) error {
+	buf := make([]byte, legacyHeaderSize+len(payload))
+	binary.BigEndian.PutUint64(buf, uint64(len(payload)))
+	copy(buf[legacyHeaderSize:], payload)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// DiscardLegacy 在 ReadHandshake 返回 *LegacyFrameError 之后读掉旧协议消息剩余的长度前缀和内容，
+// 长度超过 MaxFrameSize 时不读取
+func DiscardLegacy(r io.Reader, length uint64) error {
+	if length > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	_, err := io.CopyN(io.Discard, r, int64(legacyHeaderSize-legacyLengthSize)+int64(length))
+	return err
+}
@@ -0,0 +1,177 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	frames := []*Frame{
+		{Type: FrameRequest, ID: 1, Payload: []byte("request")},
+		{Type: FramePartial, ID: 7, Payload: []byte("partial")},
+		{Type: FrameCancel, ID: 7, Payload: []byte{}},
+		{Type: FrameResponse, ID: 0xffffffff, Payload: bytes.Repeat([]byte{0xa5}, 70000)},
+	}
+
+	var buf bytes.Buffer
+	for _, f := range frames {
+		if err := WriteFrame(&buf, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, want := range frames {
+		got, err := ReadFrame(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Type != want.Type || got.ID != want.ID || !bytes.Equal(got.Payload, want.Payload) {
+			t.Fatalf("ReadFrame() = {%d %d %d bytes}, want {%d %d %d bytes}",
+				got.Type, got.ID, len(got.Payload), want.Type, want.ID, len(want.Payload))
+		}
+	}
+
+	if _, err := ReadFrame(&buf); !errors.Is(err, io.EOF) {
+		t.Fatalf("ReadFrame() on empty stream err = %v, want EOF", err)
+	}
+}
+
+func TestFrameHeader(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, &Frame{Type: FramePartial, ID: 0x01020304, Payload: []byte("ab")}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{0, 0, 0, 2, 1, 2, 3, 4, byte(FramePartial), 'a', 'b'}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("frame = %v, want %v", buf.Bytes(), want)
+	}
+}
+
+func TestFrameTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, &Frame{Type: FrameRequest, ID: 1, Payload: []byte("payload")}); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	if _, err := ReadFrame(bytes.NewReader(data[:len(data)-1])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("truncated payload err = %v, want ErrUnexpectedEOF", err)
+	}
+	if _, err := ReadFrame(bytes.NewReader(data[:4])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("truncated header err = %v, want ErrUnexpectedEOF", err)
+	}
+}
+
+func TestFrameTooLarge(t *testing.T) {
+	t.Run("write", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := WriteFrame(&buf, &Frame{Type: FrameRequest, ID: 1, Payload: make([]byte, MaxFrameSize+1)})
+		if !errors.Is(err, ErrFrameTooLarge) {
+			t.Fatalf("err = %v, want ErrFrameTooLarge", err)
+		}
+		if buf.Len() != 0 {
+			t.Fatalf("wrote %d bytes for an oversize frame", buf.Len())
+		}
+	})
+
+	t.Run("write max", func(t *testing.T) {
+		if err := WriteFrame(io.Discard, &Frame{Type: FrameRequest, ID: 1, Payload: make([]byte, MaxFrameSize)}); err != nil {
+			t.Fatalf("err = %v, want nil at MaxFrameSize", err)
+		}
+	})
+
+	t.Run("read", func(t *testing.T) {
+		// 只有帧头，长度超过上限时不应该尝试分配和读取内容
+		head := make([]byte, frameHeaderSize)
+		binary.BigEndian.PutUint32(head[0:4], MaxFrameSize+1)
+		binary.BigEndian.PutUint32(head[4:8], 1)
+		head[8] = byte(FrameRequest)
+
+		if _, err := ReadFrame(bytes.NewReader(head)); !errors.Is(err, ErrFrameTooLarge) {
+			t.Fatalf("err = %v, want ErrFrameTooLarge", err)
+		}
+	})
+}
+
+func TestHandshake(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteHandshake(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != handshakeSize {
+		t.Fatalf("handshake is %d bytes, want %d", buf.Len(), handshakeSize)
+	}
+
+	version, err := ReadHandshake(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != ProtocolVersion {
+		t.Fatalf("version = %d, want %d", version, ProtocolVersion)
+	}
+}
+
+func TestHandshakeInvalid(t *testing.T) {
+	data := make([]byte, handshakeSize)
+	copy(data[legacyLengthSize:], "XYZ")
+	data[handshakeSize-1] = ProtocolVersion
+
+	_, err := ReadHandshake(bytes.NewReader(data))
+	if !errors.Is(err, ErrIncompatible) {
+		t.Fatalf("err = %v, want ErrIncompatible", err)
+	}
+
+	var legacy *LegacyFrameError
+	if errors.As(err, &legacy) {
+		t.Fatalf("invalid magic reported as legacy frame: %v", err)
+	}
+
+	if _, err := ReadHandshake(bytes.NewReader(data[:legacyLengthSize+1])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("short handshake err = %v, want ErrUnexpectedEOF", err)
+	}
+}
+
+func TestHandshakeLegacy(t *testing.T) {
+	payload := []byte("legacy request")
+	next := []byte("next")
+
+	var buf bytes.Buffer
+	if err := WriteLegacy(&buf, payload); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != legacyHeaderSize+len(payload) {
+		t.Fatalf("legacy message is %d bytes, want %d", buf.Len(), legacyHeaderSize+len(payload))
+	}
+	buf.Write(next)
+
+	_, err := ReadHandshake(&buf)
+
+	var legacy *LegacyFrameError
+	if !errors.As(err, &legacy) {
+		t.Fatalf("err = %v, want *LegacyFrameError", err)
+	}
+	if !errors.Is(err, ErrIncompatible) {
+		t.Fatalf("err = %v does not wrap ErrIncompatible", err)
+	}
+	if legacy.Length != uint64(len(payload)) {
+		t.Fatalf("Length = %d, want %d", legacy.Length, len(payload))
+	}
+
+	// 读掉旧协议消息的剩余部分之后，流停在下一条消息的开头
+	if err := DiscardLegacy(&buf, legacy.Length); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), next) {
+		t.Fatalf("remaining = %q, want %q", buf.Bytes(), next)
+	}
+}
+
+func TestDiscardLegacyTooLarge(t *testing.T) {
+	if err := DiscardLegacy(bytes.NewReader(nil), MaxFrameSize+1); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("err = %v, want ErrFrameTooLarge", err)
+	}
+}
@@ -16,13 +16,57 @@ type ProcInfo struct {
 	Pid     int          `json:"pid"`
 	Name    string       `json:"name"`
 	Project string       `json:"project"`
+	Port    int          `json:"port,omitempty"`
 	StartAt time.Time    `json:"start_at"`
 	StopAt  time.Time    `json:"stop_at"`
 	Status  ProcessState `json:"status"`
+
+	Ready      bool   `json:"ready"`
+	StatusText string `json:"status_text,omitempty"`
+
+	// LogDropped 是日志输出端来不及写入而丢弃的行数
+	LogDropped uint64 `json:"log_dropped,omitempty"`
+
+	// Tail 是启动失败的进程最近的输出
+	Tail []string `json:"tail,omitempty"`
+	// History 是进程最近的退出记录，最新的在最后
+	History []*ExitInfo `json:"history,omitempty"`
+}
+
+// ExitInfo 是进程的一次退出记录
+type ExitInfo struct {
+	Pid      int       `json:"pid"`
+	StartAt  time.Time `json:"start_at"`
+	StopAt   time.Time `json:"stop_at"`
+	ExitCode int       `json:"exit_code"`
+	Signal   string    `json:"signal,omitempty"`
+
+	// Tail 是进程退出前最后的输出
+	Tail []string `json:"tail,omitempty"`
+}
+
+// LogLine 是一行进程输出日志
+type LogLine struct {
+	Time    time.Time `json:"time"`
+	Project string    `json:"project"`
+	Process string    `json:"process"`
+	Stream  string    `json:"stream"`
+	Line    string    `json:"line"`
+
+	// 以下字段只在搜索日志时使用
+	File    string `json:"file,omitempty"`    // 日志所在的文件
+	LineNo  int    `json:"line_no,omitempty"` // 在文件中的行号，从 1 开始
+	Context bool   `json:"context,omitempty"` // 是匹配行前后的上下文，不是匹配行
 }
 
 type ResponseMsg struct {
-	Code      int         `json:"code"`
-	Message   string      `json:"message"`
-	Processes []*ProcInfo `json:"processes"`
+	Code      int           `json:"code"`
+	Message   string        `json:"message"`
+	Processes []*ProcInfo   `json:"processes"`
+	Logs      []*LogLine    `json:"logs,omitempty"`
+	Events    []*Event      `json:"events,omitempty"`
+	Audits    []*AuditEntry `json:"audits,omitempty"`
+
+	// More 为 true 表示这是流式响应中的一部分，后面还有更多消息
+	More bool `json:"more,omitempty"`
 }
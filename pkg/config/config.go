@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"spm/pkg/utils/constants"
 
@@ -24,8 +25,33 @@ type Config struct {
 	DumpFile  string
 	PidFile   string
 	Socket    string
-	Env       []string `yaml:",omitempty"`
-	Log       Log
+
+	// SocketOwner、SocketGroup 是控制 socket 文件的属主和属组，可以是名称或者 ID，为空时不修改
+	SocketOwner string `yaml:",omitempty"`
+	SocketGroup string `yaml:",omitempty"`
+	// SocketMode 是控制 socket 文件的八进制权限，例如 "0660"，为空时不修改
+	SocketMode string `yaml:",omitempty"`
+
+	Env []string `yaml:",omitempty"`
+	Log Log
+
+	// Notifications 是进程事件的通知配置
+	Notifications Notifications `yaml:",omitempty"`
+
+	// HTTP 是 daemon 的 HTTP API 配置
+	HTTP HTTP `yaml:",omitempty"`
+
+	// Metrics 是 Prometheus 指标接口的配置
+	Metrics Metrics `yaml:",omitempty"`
+
+	// Remote 是远程控制的配置，daemon 用来监听 TCP 地址，客户端用来连接远程的 daemon
+	Remote Remote `yaml:",omitempty"`
+
+	// ACL 是控制 socket 和 HTTP API 的访问控制规则，为空时不限制访问
+	ACL []*ACLRule `yaml:",omitempty"`
+
+	// Audit 是审计日志的配置
+	Audit Audit `yaml:",omitempty"`
 }
 
 type Log struct {
@@ -38,6 +64,89 @@ type Log struct {
 	MaxBackups   int    `yaml:",omitempty"`
 }
 
+// HTTP 配置 daemon 的 HTTP API，默认不开启
+type HTTP struct {
+	Enabled bool `yaml:",omitempty"`
+	// Listen 是监听地址，unix:<路径> 表示 unix socket，否则是只能监听本机地址的 TCP 地址，例如 127.0.0.1:7070
+	Listen string `yaml:",omitempty"`
+}
+
+// Audit 配置审计日志，daemon 处理的每个请求以一行 JSON 追加到文件中，默认开启
+type Audit struct {
+	Enabled bool   `yaml:",omitempty"`
+	File    string `yaml:",omitempty"` // 审计日志文件，默认为 ~/.spm/spm.audit.log
+}
+
+// Remote 配置使用双向 TLS 认证的远程控制，协议与控制 socket 相同，默认不开启
+//
+// daemon 和客户端使用同一个 CA 签发的证书，daemon 只接受 CA 签发的客户端证书，
+// 客户端证书的 CN 作为对端的身份匹配 acl 规则。
+type Remote struct {
+	Enabled bool `yaml:",omitempty"`
+	// Listen 是 daemon 监听的 TCP 地址，例如 0.0.0.0:7443
+	Listen string `yaml:",omitempty"`
+	// CA 是用来验证对端证书的 CA 证书文件
+	CA string `yaml:"ca,omitempty"`
+	// Cert、Key 是本端的证书和私钥文件，daemon 使用服务端证书，客户端使用客户端证书
+	Cert string `yaml:",omitempty"`
+	Key  string `yaml:",omitempty"`
+	// ServerName 是客户端验证服务端证书时使用的名称，为空时使用连接地址中的主机名
+	ServerName string `yaml:",omitempty"`
+}
+
+// ACLRule 是一条访问控制规则，允许匹配的用户或组对匹配的项目执行列出的动作
+//
+// 规则之间是并集关系，只要有一条规则允许，请求就被允许。root 和运行 daemon 的用户不受限制。
+type ACLRule struct {
+	Users    []string `yaml:",omitempty"` // 用户名或 uid，"*" 表示所有用户，包括无法识别身份的连接
+	Groups   []string `yaml:",omitempty"` // 组名或 gid，用户的主组和附加组都可以匹配
+	Certs    []string `yaml:",omitempty"` // 远程连接的客户端证书 CN，"*" 表示所有客户端证书
+	Actions  []string `yaml:",omitempty"` // 允许的动作，例如 status、start、logs，"*" 表示所有动作
+	Projects []string `yaml:",omitempty"` // 项目名的 glob，为空时表示所有项目
+}
+
+// Metrics 配置 Prometheus 文本格式的指标接口，默认不开启
+type Metrics struct {
+	Enabled bool `yaml:",omitempty"`
+	// Listen 是单独的监听地址，例如 0.0.0.0:9100 或者 unix:<路径>；为空时使用 HTTP API 的监听地址
+	Listen string `yaml:",omitempty"`
+	// Path 是指标接口的路径，默认为 /metrics
+	Path string `yaml:",omitempty"`
+}
+
+// Notifications 配置进程事件发生时发送的 webhook 和执行的命令
+type Notifications struct {
+	Webhooks []*Webhook     `yaml:",omitempty"`
+	Commands []*CommandHook `yaml:",omitempty"`
+}
+
+// NotifyFilter 按事件类型和项目过滤通知，为空时不过滤
+type NotifyFilter struct {
+	Events   []string `yaml:",omitempty"`
+	Projects []string `yaml:",omitempty"`
+}
+
+// Webhook 是一个接收事件的 HTTP 地址，事件以 POST 请求发送
+type Webhook struct {
+	NotifyFilter `mapstructure:",squash" yaml:",inline"`
+
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:",omitempty"`
+	// Body 是请求内容的 text/template 模板，以事件为参数，渲染结果必须是 JSON；为空时发送事件本身
+	Body       string        `yaml:",omitempty"`
+	Timeout    time.Duration `yaml:",omitempty"` // 单次请求的超时时间，默认 10s
+	Retries    int           `yaml:",omitempty"` // 失败后的重试次数，默认 3
+	RetryDelay time.Duration `yaml:",omitempty"` // 第一次重试前的等待时间，之后每次加倍，默认 1s
+}
+
+// CommandHook 是事件发生时执行的 shell 命令，事件以 JSON 格式写入命令的标准输入
+type CommandHook struct {
+	NotifyFilter `mapstructure:",squash" yaml:",inline"`
+
+	Command string        `yaml:"command"`
+	Timeout time.Duration `yaml:",omitempty"` // 命令的超时时间，默认 30s
+}
+
 func setDefault() {
 	viper.SetDefault("daemonize", true)
 	viper.SetDefault("dumpfile", constants.DaemonDumpFilePath)
@@ -53,6 +162,19 @@ func setDefault() {
 		"maxAge":       7,
 		"maxBackups":   7,
 	})
+	viper.SetDefault("http", map[string]any{
+		"enabled": false,
+		"listen":  "unix:" + constants.DaemonHTTPSockFilePath,
+	})
+	viper.SetDefault("audit", map[string]any{
+		"enabled": true,
+		"file":    constants.DaemonAuditFilePath,
+	})
+	viper.SetDefault("metrics", map[string]any{
+		"enabled": false,
+		"listen":  "",
+		"path":    "/metrics",
+	})
 }
 
 func GetConfig() *Config {
@@ -108,6 +230,10 @@ func SetConfig(configFile string) {
 
 	if ProcfileFlag == "" {
 		ProcfileFlag = defaultProcfile
+		// 远程 daemon 上的路径与本机无关，Procfile 默认在 --workdir 指定的目录中
+		if RemoteHost() != "" {
+			ProcfileFlag = filepath.Join(WorkDirFlag, "Procfile")
+		}
 	}
 
 	if LogLevelFlag == "" {
@@ -115,32 +241,44 @@ func SetConfig(configFile string) {
 	}
 }
 
+// GetRuntimeDir 返回项目的运行时目录 tmp，目录不存在时创建，出错时退出进程
 func GetRuntimeDir(cwd string) string {
-	abs, err := filepath.Abs(cwd)
+	tmp, err := RuntimeDir(cwd)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	return tmp
+}
+
+// RuntimeDir 返回项目的运行时目录 tmp，目录不存在时创建
+//
+// 注意事项：
+//
+//	daemon 处理客户端请求时使用这个函数，目录由客户端指定，出错时只能返回错误而不能退出
+func RuntimeDir(cwd string) (string, error) {
+	abs, err := filepath.Abs(cwd)
+	if err != nil {
+		return "", err
+	}
+
 	info, err := os.Stat(abs)
-	if err == nil {
-		if !info.IsDir() {
-			log.Fatal(os.PathError{Op: "dirname", Path: cwd, Err: os.ErrInvalid})
-		}
+	if err == nil && !info.IsDir() {
+		return "", &os.PathError{Op: "dirname", Path: cwd, Err: os.ErrInvalid}
 	}
 
 	tmp := fmt.Sprintf("%s/tmp", abs)
 	info, err = os.Stat(tmp)
 	if err == nil {
-		if info.IsDir() {
-			return tmp
-		} else {
-			log.Fatal(os.PathError{Op: "mkdir", Path: tmp, Err: os.ErrExist})
-		}
-	} else {
-		if err := os.MkdirAll(tmp, 0755); err != nil {
-			log.Fatalf("create directory %q error: %v", tmp, err)
+		if !info.IsDir() {
+			return "", &os.PathError{Op: "mkdir", Path: tmp, Err: os.ErrExist}
 		}
+		return tmp, nil
 	}
 
-	return tmp
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return "", fmt.Errorf("create directory %q error: %w", tmp, err)
+	}
+
+	return tmp, nil
 }
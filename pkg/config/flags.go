@@ -1,6 +1,8 @@
 // Package config
 package config
 
+import "os"
+
 // LogLevelFlag set the log level. Overrides env var LOG_LEVEL
 // Flag: 				LogLevel (string)
 // default: 		""
@@ -13,3 +15,19 @@ var WorkDirFlag string
 var ProcfileFlag string
 
 var ForegroundFlag bool
+
+// ExitOnFailureFlag 在前台模式下任何一个进程退出时停止所有进程并退出
+var ExitOnFailureFlag bool
+
+// HostFlag 是远程 daemon 的 TCP 地址，例如 10.0.0.5:7443
+var HostFlag string
+
+// RemoteHost 返回客户端要连接的远程 daemon 地址，没有指定 --host 时使用环境变量 SPM_HOST，
+// 都为空时返回空字符串，表示连接本机的控制 socket
+func RemoteHost() string {
+	if HostFlag != "" {
+		return HostFlag
+	}
+
+	return os.Getenv("SPM_HOST")
+}
@@ -0,0 +1,443 @@
+// 控制 socket 和 HTTP API 的访问控制
+//
+// daemon 在接受 unix socket 连接时通过 SO_PEERCRED 读取对端进程的 uid、gid 和 pid，
+// 再按全局配置中的 acl 规则检查每个请求：
+//   - 没有配置规则时不限制访问，与之前的版本一致
+//   - root 和运行 daemon 的用户不受限制
+//   - 其他用户只能执行匹配的规则允许的动作，请求涉及的每个项目都要匹配规则中的项目 glob
+//   - shutdown、dump、load、audit 这样作用于整个 daemon 的动作，要求规则的项目包含 "*"
+//   - status、logs、grep 中不带项目名的进程名按工作目录的路径展开，status 的 "*" 只包含有权限查看的项目
+//
+// 远程控制的连接以客户端证书的 CN 作为身份，只匹配 certs 中列出这个 CN 的规则，没有配置规则时拒绝所有请求。
+// TCP 上的 HTTP API 请求持有 daemon 的令牌，以 daemon 的用户作为身份。
+// 被拒绝的请求返回 403 响应。无法识别身份的连接只匹配 users 为 "*" 的规则。
+
+package supervisor
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"spm/pkg/codec"
+	"spm/pkg/config"
+)
+
+// allProjects 表示请求涉及所有项目或者 daemon 本身
+const allProjects = "*"
+
+// peerCred 是连接对端的身份
+type peerCred struct {
+	Uid    int
+	Gid    int
+	Pid    int
+	User   string   // 用户名，查不到时是 uid
+	Groups []string // 用户所属组的 gid 和组名
+
+	CommonName string // 远程连接的客户端证书 CN，本机连接为空
+	Addr       string // 远程连接的对端地址
+}
+
+// newPeerCred 根据 uid 和 gid 查询用户名和用户所属的组
+func newPeerCred(uid, gid, pid int) *peerCred {
+	p := &peerCred{
+		Uid:  uid,
+		Gid:  gid,
+		Pid:  pid,
+		User: strconv.Itoa(uid),
+	}
+
+	gids := []string{strconv.Itoa(gid)}
+	if u, err := user.LookupId(p.User); err == nil {
+		p.User = u.Username
+		if ids, err := u.GroupIds(); err == nil {
+			gids = append(gids, ids...)
+		}
+	}
+
+	for _, id := range gids {
+		if slices.Contains(p.Groups, id) {
+			continue
+		}
+		p.Groups = append(p.Groups, id)
+		if g, err := user.LookupGroupId(id); err == nil {
+			p.Groups = append(p.Groups, g.Name)
+		}
+	}
+
+	return p
+}
+
+// newCertPeer 返回远程连接的身份，uid、gid 和 pid 都是 -1
+func newCertPeer(cn, addr string) *peerCred {
+	return &peerCred{
+		Uid:        -1,
+		Gid:        -1,
+		Pid:        -1,
+		CommonName: cn,
+		Addr:       addr,
+	}
+}
+
+// remote 判断对端是否是远程连接
+func (p *peerCred) remote() bool {
+	return p != nil && p.CommonName != ""
+}
+
+func (p *peerCred) String() string {
+	if p == nil {
+		return "unknown peer"
+	}
+
+	if p.remote() {
+		return fmt.Sprintf("cert %s(%s)", p.CommonName, p.Addr)
+	}
+
+	return fmt.Sprintf("%s(uid=%d, pid=%d)", p.User, p.Uid, p.Pid)
+}
+
+// access 是一个连接对端的访问权限，nil 表示不受限制
+type access struct {
+	peer  *peerCred
+	rules []*config.ACLRule // 匹配对端用户或组的规则
+}
+
+// newAccess 返回对端的访问权限，没有配置规则或者对端是 root、daemon 的用户时返回 nil
+//
+// 注意事项：
+//
+//	远程连接总是受限的，没有匹配的规则时不能执行任何请求
+func newAccess(peer *peerCred) *access {
+	return newRuleAccess(peer, config.GetConfig().ACL)
+}
+
+// newRuleAccess 按给定的规则返回对端的访问权限，规则与 newAccess 相同
+func newRuleAccess(peer *peerCred, rules []*config.ACLRule) *access {
+	if len(rules) == 0 && !peer.remote() {
+		return nil
+	}
+
+	if peer != nil && !peer.remote() && (peer.Uid == 0 || peer.Uid == os.Geteuid()) {
+		return nil
+	}
+
+	a := &access{peer: peer}
+	for _, rule := range rules {
+		if ruleMatchesPeer(rule, peer) {
+			a.rules = append(a.rules, rule)
+		}
+	}
+
+	return a
+}
+
+// ruleMatchesPeer 判断规则是否适用于对端
+func ruleMatchesPeer(rule *config.ACLRule, peer *peerCred) bool {
+	// 远程连接只按证书的 CN 匹配，不会匹配本机的用户和组
+	if peer.remote() {
+		return slices.Contains(rule.Certs, "*") || slices.Contains(rule.Certs, peer.CommonName)
+	}
+
+	if slices.Contains(rule.Users, "*") {
+		return true
+	}
+
+	if peer == nil {
+		return false
+	}
+
+	if slices.Contains(rule.Users, peer.User) || slices.Contains(rule.Users, strconv.Itoa(peer.Uid)) {
+		return true
+	}
+
+	for _, g := range rule.Groups {
+		if g == "*" || slices.Contains(peer.Groups, g) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ruleAllowsAction 判断规则是否允许动作，kill 与 shutdown 相同
+func ruleAllowsAction(rule *config.ACLRule, action codec.ActionCtl) bool {
+	if action == codec.ActionKill {
+		action = codec.ActionShutdown
+	}
+
+	return slices.Contains(rule.Actions, "*") || slices.Contains(rule.Actions, action.String())
+}
+
+// ruleAllowsProject 判断规则的项目 glob 是否匹配项目，allProjects 只能被 "*" 匹配
+func ruleAllowsProject(rule *config.ACLRule, project string) bool {
+	if len(rule.Projects) == 0 {
+		return true
+	}
+
+	for _, pattern := range rule.Projects {
+		if pattern == "*" {
+			return true
+		}
+		if project == allProjects {
+			continue
+		}
+		if ok, _ := path.Match(pattern, project); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allows 判断是否允许对项目执行动作
+func (a *access) allows(action codec.ActionCtl, project string) bool {
+	if a == nil {
+		return true
+	}
+
+	for _, rule := range a.rules {
+		if ruleAllowsAction(rule, action) && ruleAllowsProject(rule, project) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowsAny 判断是否允许对至少一个项目执行动作
+func (a *access) allowsAny(action codec.ActionCtl) bool {
+	if a == nil {
+		return true
+	}
+
+	return slices.ContainsFunc(a.rules, func(rule *config.ACLRule) bool {
+		return ruleAllowsAction(rule, action)
+	})
+}
+
+// errUnqualifiedName 是受限的对端使用了不带项目名的名称时返回的错误
+var errUnqualifiedName = errors.New("process names must be qualified as project::process")
+
+// readOnlyActions 是只查看进程状态和日志的动作，受限的对端使用不带项目名的进程名时会被展开
+var readOnlyActions = []codec.ActionCtl{codec.ActionStatus, codec.ActionLog, codec.ActionLogGrep}
+
+// qualifyNames 把只读请求中不带项目名的进程名展开为完整进程名，其他请求不做修改
+//
+// 展开规则与 doAction 和 resolveProcs 一致：
+//   - status 的 "*" 展开为对端有权限查看的所有项目中的进程
+//   - logs、grep 的 "*" 和空名称展开为工作目录对应项目中的进程
+//   - "name" 展开为工作目录对应项目中的进程
+//
+// 注意事项：
+//
+//	项目名只由工作目录的路径决定，展开时不读取客户端目录中的文件；
+//	展开之后请求中的名称都带有项目名，仍然要经过 requestProjects 检查权限
+func (se *SpmSession) qualifyNames(msg *codec.ActionMsg) error {
+	if !slices.Contains(readOnlyActions, msg.Action) {
+		return nil
+	}
+
+	names := make([]string, 0)
+	for _, n := range strings.Split(cmp.Or(msg.Processes, "*"), ";") {
+		switch {
+		case strings.Contains(n, "::"):
+			names = append(names, n)
+		case n == "*" && msg.Action == codec.ActionStatus:
+			visible := make([]string, 0)
+			for _, fullName := range se.sv.procList.All() {
+				project, _, _ := strings.Cut(fullName, "::")
+				if se.access.allows(msg.Action, project) {
+					visible = append(visible, fullName)
+				}
+			}
+			slices.Sort(visible)
+			names = append(names, visible...)
+		default:
+			project, err := GetAppName(msg.WorkDir)
+			if err != nil {
+				return err
+			}
+
+			if n != "*" {
+				names = append(names, project+"::"+n)
+				continue
+			}
+
+			proj := se.sv.projectTable.Get(project)
+			if proj == nil {
+				return fmt.Errorf("cannot find project %s", project)
+			}
+			for _, p := range proj.GetProcs() {
+				names = append(names, p.FullName)
+			}
+		}
+	}
+
+	if len(names) == 0 {
+		return fmt.Errorf("no processes visible to %s", se.peer)
+	}
+
+	msg.Processes = strings.Join(names, ";")
+	return nil
+}
+
+// requestProjects 返回请求涉及的项目
+//
+// 返回：
+//
+//	[]string: 项目名列表，allProjects 表示涉及所有项目或者 daemon 本身；
+//	  为 nil 时表示请求不限定项目，只处理对端有权限的项目（用于订阅事件）
+//	error: 无法确定项目时返回错误
+//
+// 注意事项：
+//
+//	工作目录和 Procfile 由客户端指定，检查权限之前不能读取和解析其中的文件，
+//	所以 qualifyNames 没有展开的不带项目名的进程名和不指定项目的 reload 请求无法确定项目，直接拒绝
+func (se *SpmSession) requestProjects(msg *codec.ActionMsg) ([]string, error) {
+	switch msg.Action {
+	case codec.ActionShutdown, codec.ActionKill, codec.ActionDump, codec.ActionLoad, codec.ActionAudit:
+		return []string{allProjects}, nil
+	case codec.ActionEvents:
+		if msg.Projects == "" {
+			return nil, nil
+		}
+		return []string{msg.Projects}, nil
+	case codec.ActionRun:
+		// 项目名只由工作目录的路径决定，不需要读取其中的文件
+		name, err := GetAppName(msg.WorkDir)
+		if err != nil {
+			return nil, err
+		}
+		return []string{name}, nil
+	case codec.ActionReload:
+		if msg.Projects == "" {
+			return nil, errUnqualifiedName
+		}
+		return strings.Split(msg.Projects, ";"), nil
+	}
+
+	if msg.Processes == "" {
+		return nil, errUnqualifiedName
+	}
+
+	projects := make([]string, 0)
+	for _, n := range strings.Split(msg.Processes, ";") {
+		project, _, ok := strings.Cut(n, "::")
+		if !ok || project == "" {
+			return nil, errUnqualifiedName
+		}
+
+		if !slices.Contains(projects, project) {
+			projects = append(projects, project)
+		}
+	}
+
+	return projects, nil
+}
+
+// authorize 检查会话的对端是否可以执行请求
+func (se *SpmSession) authorize(msg *codec.ActionMsg) error {
+	if se.access == nil {
+		return nil
+	}
+
+	if err := se.qualifyNames(msg); err != nil {
+		return fmt.Errorf("permission denied: %v", err)
+	}
+
+	projects, err := se.requestProjects(msg)
+	if err != nil {
+		return fmt.Errorf("permission denied: %v", err)
+	}
+
+	if projects == nil {
+		if se.access.allowsAny(msg.Action) {
+			return nil
+		}
+		return fmt.Errorf("permission denied: %s cannot %s", se.peer, msg.Action)
+	}
+
+	for _, project := range projects {
+		if se.access.allows(msg.Action, project) {
+			continue
+		}
+
+		if project == allProjects {
+			return fmt.Errorf("permission denied: %s cannot %s all projects", se.peer, msg.Action)
+		}
+		return fmt.Errorf("permission denied: %s cannot %s project %s", se.peer, msg.Action, project)
+	}
+
+	return nil
+}
+
+// lookupID 把用户名或组名解析为 ID，name 是数字时直接使用
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	id, err := lookup(name)
+	if err != nil {
+		return -1, err
+	}
+
+	return strconv.Atoi(id)
+}
+
+// setSocketPerms 按配置修改 socket 文件的属主、属组和权限
+func setSocketPerms(file string) error {
+	cfg := config.GetConfig()
+	uid, gid := -1, -1
+
+	var err error
+	if cfg.SocketOwner != "" {
+		uid, err = lookupID(cfg.SocketOwner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("socket owner %s: %w", cfg.SocketOwner, err)
+		}
+	}
+
+	if cfg.SocketGroup != "" {
+		gid, err = lookupID(cfg.SocketGroup, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("socket group %s: %w", cfg.SocketGroup, err)
+		}
+	}
+
+	if uid != -1 || gid != -1 {
+		if err := os.Chown(file, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	if cfg.SocketMode != "" {
+		mode, err := strconv.ParseUint(cfg.SocketMode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid socket mode %q", cfg.SocketMode)
+		}
+		if err := os.Chmod(file, os.FileMode(mode)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
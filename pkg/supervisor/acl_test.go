@@ -0,0 +1,302 @@
+package supervisor
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"spm/pkg/codec"
+	"spm/pkg/config"
+)
+
+// testPeer 是一个既不是 root 也不是 daemon 用户的本机用户
+func testPeer() *peerCred {
+	return &peerCred{
+		Uid:    12345,
+		Gid:    100,
+		Pid:    42,
+		User:   "alice",
+		Groups: []string{"100", "users", "200", "ops"},
+	}
+}
+
+func TestNewRuleAccess(t *testing.T) {
+	alice := testPeer()
+	cert := newCertPeer("deploy", "10.0.0.1:5000")
+	root := &peerCred{Uid: 0, User: "root"}
+	daemon := &peerCred{Uid: os.Geteuid(), User: "daemon"}
+
+	everyone := &config.ACLRule{Users: []string{"*"}, Actions: []string{"status"}}
+	byName := &config.ACLRule{Users: []string{"alice"}, Actions: []string{"logs"}}
+	byUid := &config.ACLRule{Users: []string{"12345"}, Actions: []string{"grep"}}
+	byGroup := &config.ACLRule{Groups: []string{"ops"}, Actions: []string{"start"}}
+	byGid := &config.ACLRule{Groups: []string{"200"}, Actions: []string{"stop"}}
+	anyGroup := &config.ACLRule{Groups: []string{"*"}, Actions: []string{"restart"}}
+	otherUser := &config.ACLRule{Users: []string{"bob", "999"}, Groups: []string{"wheel"}, Actions: []string{"*"}}
+	anyCert := &config.ACLRule{Certs: []string{"*"}, Actions: []string{"events"}}
+	namedCert := &config.ACLRule{Certs: []string{"deploy"}, Actions: []string{"signal"}}
+	otherCert := &config.ACLRule{Certs: []string{"ci"}, Actions: []string{"*"}}
+
+	all := []*config.ACLRule{everyone, byName, byUid, byGroup, byGid, anyGroup, otherUser, anyCert, namedCert, otherCert}
+
+	tests := []struct {
+		name         string
+		peer         *peerCred
+		rules        []*config.ACLRule
+		unrestricted bool
+		want         []*config.ACLRule
+	}{
+		{name: "no rules", peer: alice, unrestricted: true},
+		{name: "no rules unknown peer", peer: nil, unrestricted: true},
+		{name: "no rules remote", peer: cert, want: nil},
+		{name: "root", peer: root, rules: all, unrestricted: true},
+		{name: "daemon user", peer: daemon, rules: all, unrestricted: true},
+		{name: "local user", peer: alice, rules: all, want: []*config.ACLRule{everyone, byName, byUid, byGroup, byGid, anyGroup}},
+		{name: "unknown peer", peer: nil, rules: all, want: []*config.ACLRule{everyone}},
+		{name: "remote", peer: cert, rules: all, want: []*config.ACLRule{anyCert, namedCert}},
+		{name: "remote without cert rules", peer: cert, rules: []*config.ACLRule{everyone, anyGroup}, want: nil},
+		{name: "root over remote", peer: newCertPeer("root", "10.0.0.2:5000"), rules: []*config.ACLRule{everyone}, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newRuleAccess(tt.peer, tt.rules)
+			if tt.unrestricted {
+				if a != nil {
+					t.Fatalf("access = %+v, want unrestricted", a.rules)
+				}
+				return
+			}
+
+			if a == nil {
+				t.Fatal("access is unrestricted, want restricted")
+			}
+			if !slices.Equal(a.rules, tt.want) {
+				t.Fatalf("matched %d rules, want %d", len(a.rules), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestAccessAllows(t *testing.T) {
+	a := &access{
+		peer: testPeer(),
+		rules: []*config.ACLRule{
+			{Actions: []string{"status", "logs"}},
+			{Actions: []string{"start", "stop"}, Projects: []string{"web-*", "api"}},
+			{Actions: []string{"*"}, Projects: []string{"sandbox"}},
+			{Actions: []string{"shutdown", "dump"}, Projects: []string{"*"}},
+		},
+	}
+
+	tests := []struct {
+		action  codec.ActionCtl
+		project string
+		want    bool
+	}{
+		{codec.ActionStatus, "anything", true},
+		{codec.ActionStatus, allProjects, true},
+		{codec.ActionLog, "web-1", true},
+		{codec.ActionStart, "web-1", true},
+		{codec.ActionStop, "api", true},
+		{codec.ActionStart, "api-2", false},
+		{codec.ActionStart, "worker", false},
+		{codec.ActionStart, allProjects, false},
+		{codec.ActionRestart, "web-1", false},
+		{codec.ActionRestart, "sandbox", true},
+		{codec.ActionRun, "sandbox", true},
+		{codec.ActionRun, allProjects, false},
+		{codec.ActionShutdown, allProjects, true},
+		{codec.ActionKill, allProjects, true},
+		{codec.ActionDump, allProjects, true},
+		{codec.ActionLoad, allProjects, false},
+	}
+
+	for _, tt := range tests {
+		if got := a.allows(tt.action, tt.project); got != tt.want {
+			t.Errorf("allows(%s, %q) = %v, want %v", tt.action, tt.project, got, tt.want)
+		}
+	}
+
+	named := &access{peer: a.peer, rules: a.rules[:2]}
+	if !named.allowsAny(codec.ActionStart) || named.allowsAny(codec.ActionAudit) || !a.allowsAny(codec.ActionAudit) {
+		t.Error("allowsAny does not follow the rule actions")
+	}
+
+	var unrestricted *access
+	if !unrestricted.allows(codec.ActionShutdown, allProjects) || !unrestricted.allowsAny(codec.ActionAudit) {
+		t.Error("nil access must allow everything")
+	}
+}
+
+// newACLTestSupervisor 创建只有进程表的 supervisor，projects 是项目名和其中的进程名
+func newACLTestSupervisor(projects map[string][]string) *Supervisor {
+	sv := &Supervisor{
+		logger: zap.NewNop().Sugar(),
+		projectTable: &ProjectTable{
+			table: make(map[string]*Project),
+		},
+		procList: NewProcList(),
+	}
+
+	for name, procs := range projects {
+		proj := &Project{Name: name, procTable: NewProcTable(), running: make(map[string]bool)}
+		for _, n := range procs {
+			fullName := name + "::" + n
+			proj.procTable.Set(n, &Process{Name: n, FullName: fullName})
+			sv.procList.Add(fullName)
+		}
+		sv.projectTable.Set(name, proj)
+	}
+
+	return sv
+}
+
+func TestRequestProjects(t *testing.T) {
+	workDir := t.TempDir()
+	local, err := GetAppName(workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	se := &SpmSession{}
+
+	tests := []struct {
+		name string
+		msg  *codec.ActionMsg
+		want []string
+		err  error
+	}{
+		{name: "shutdown", msg: &codec.ActionMsg{Action: codec.ActionShutdown}, want: []string{allProjects}},
+		{name: "audit", msg: &codec.ActionMsg{Action: codec.ActionAudit}, want: []string{allProjects}},
+		{name: "all events", msg: &codec.ActionMsg{Action: codec.ActionEvents}, want: nil},
+		{name: "project events", msg: &codec.ActionMsg{Action: codec.ActionEvents, Projects: "web"}, want: []string{"web"}},
+		{name: "run", msg: &codec.ActionMsg{Action: codec.ActionRun, WorkDir: workDir}, want: []string{local}},
+		{name: "reload", msg: &codec.ActionMsg{Action: codec.ActionReload, Projects: "web;api"}, want: []string{"web", "api"}},
+		{name: "reload local", msg: &codec.ActionMsg{Action: codec.ActionReload, WorkDir: workDir}, err: errUnqualifiedName},
+		{name: "qualified", msg: &codec.ActionMsg{Action: codec.ActionStart, Processes: "web::a;api::b;web::c"}, want: []string{"web", "api"}},
+		{name: "unqualified", msg: &codec.ActionMsg{Action: codec.ActionStart, Processes: "web::a;b"}, err: errUnqualifiedName},
+		{name: "wildcard", msg: &codec.ActionMsg{Action: codec.ActionStop, Processes: "*"}, err: errUnqualifiedName},
+		{name: "empty project", msg: &codec.ActionMsg{Action: codec.ActionStop, Processes: "::a"}, err: errUnqualifiedName},
+		{name: "empty", msg: &codec.ActionMsg{Action: codec.ActionSignal}, err: errUnqualifiedName},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := se.requestProjects(tt.msg)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("projects = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	workDir := t.TempDir()
+	local, err := GetAppName(workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sv := newACLTestSupervisor(map[string][]string{
+		local:    {"web", "worker"},
+		"web-1":  {"api"},
+		"secret": {"db"},
+	})
+
+	rules := []*config.ACLRule{
+		{Users: []string{"alice"}, Actions: []string{"status", "logs", "grep"}, Projects: []string{"web-*", local}},
+		{Groups: []string{"ops"}, Actions: []string{"restart"}, Projects: []string{local}},
+	}
+	se := &SpmSession{sv: sv, peer: testPeer(), access: newRuleAccess(testPeer(), rules)}
+
+	tests := []struct {
+		name      string
+		msg       *codec.ActionMsg
+		allowed   bool
+		processes string // 展开之后的进程名
+	}{
+		{
+			name:      "plain status",
+			msg:       &codec.ActionMsg{Action: codec.ActionStatus, Processes: "*", WorkDir: workDir},
+			allowed:   true,
+			processes: strings.Join([]string{local + "::web", local + "::worker", "web-1::api"}, ";"),
+		},
+		{
+			name:      "plain logs",
+			msg:       &codec.ActionMsg{Action: codec.ActionLog, Processes: "*", WorkDir: workDir},
+			allowed:   true,
+			processes: local + "::web;" + local + "::worker",
+		},
+		{
+			name:      "empty grep",
+			msg:       &codec.ActionMsg{Action: codec.ActionLogGrep, WorkDir: workDir},
+			allowed:   true,
+			processes: local + "::web;" + local + "::worker",
+		},
+		{
+			name:      "unqualified logs",
+			msg:       &codec.ActionMsg{Action: codec.ActionLog, Processes: "worker;web-1::api", WorkDir: workDir},
+			allowed:   true,
+			processes: local + "::worker;web-1::api",
+		},
+		{
+			name: "qualified status of a hidden project",
+			msg:  &codec.ActionMsg{Action: codec.ActionStatus, Processes: "secret::db", WorkDir: workDir},
+		},
+		{
+			name: "logs in a hidden project",
+			msg:  &codec.ActionMsg{Action: codec.ActionLog, Processes: "*", WorkDir: t.TempDir()},
+		},
+		{
+			name:      "qualified restart by group",
+			msg:       &codec.ActionMsg{Action: codec.ActionRestart, Processes: local + "::web"},
+			allowed:   true,
+			processes: local + "::web",
+		},
+		{
+			name:      "unqualified restart",
+			msg:       &codec.ActionMsg{Action: codec.ActionRestart, Processes: "web", WorkDir: workDir},
+			processes: "web",
+		},
+		{
+			name:      "restart all",
+			msg:       &codec.ActionMsg{Action: codec.ActionRestart, Processes: "*", WorkDir: workDir},
+			processes: "*",
+		},
+		{
+			name: "stop",
+			msg:  &codec.ActionMsg{Action: codec.ActionStop, Processes: local + "::web"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := se.authorize(tt.msg)
+			if allowed := err == nil; allowed != tt.allowed {
+				t.Fatalf("authorize() err = %v, want allowed = %v", err, tt.allowed)
+			}
+			if tt.processes != "" && tt.msg.Processes != tt.processes {
+				t.Fatalf("processes = %q, want %q", tt.msg.Processes, tt.processes)
+			}
+		})
+	}
+}
+
+func TestAuthorizeNothingVisible(t *testing.T) {
+	sv := newACLTestSupervisor(map[string][]string{"secret": {"db"}})
+	rules := []*config.ACLRule{{Users: []string{"alice"}, Actions: []string{"status"}, Projects: []string{"web-*"}}}
+	se := &SpmSession{sv: sv, peer: testPeer(), access: newRuleAccess(testPeer(), rules)}
+
+	msg := &codec.ActionMsg{Action: codec.ActionStatus, Processes: "*", WorkDir: t.TempDir()}
+	if err := se.authorize(msg); err == nil {
+		t.Fatalf("authorize() allowed %q", msg.Processes)
+	}
+}
@@ -67,6 +67,7 @@ func (sv *Supervisor) UpdateApp(
 			for name, opt := range procOpts.Processes {
 				proc := newProj.Register(name, opt)
 				sv.procList.Add(proc.FullName)
+				proc.ID = sv.procList.Index(proc.FullName)
 			}
 
 			return newProj, nil
@@ -80,6 +81,9 @@ func (sv *Supervisor) UpdateApp(
 			for _, name := range oldProcList {
 				if !newProj.IsExist(name) && !oldProj.GetState(name) {
 					fullName := fmt.Sprintf("%s::%s", oldProj.Name, name)
+					if proc, ok := oldProj.procTable.Get(name); ok {
+						proc.CloseListeners()
+					}
 					oldProj.Unset(name)
 					_ = oldProj.procTable.Del(name)
 					_ = sv.procList.Del(fullName)
@@ -96,6 +100,7 @@ func (sv *Supervisor) UpdateApp(
 
 				proc := oldProj.Register(name, opt)
 				sv.procList.Add(fullName)
+				proc.ID = sv.procList.Index(fullName)
 
 				pList = append(pList, proc)
 			}
@@ -0,0 +1,413 @@
+// daemon 的审计日志
+//
+// daemon 处理的每个请求，包括控制 socket、HTTP API 和远程控制上的请求，都在结束时以一行 JSON
+// 追加到审计日志中，记录请求的来源、身份、动作、目标、最终的状态码和处理时间，被 acl 拒绝的请求也会记录。
+// 审计日志只追加不修改，收到 SIGUSR1 时与进程日志一起重新打开，便于 logrotate 轮转。
+//
+// spm audit 通过 ActionAudit 请求在 daemon 中按条件查询审计日志。
+
+package supervisor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"spm/pkg/codec"
+	"spm/pkg/config"
+)
+
+// auditBatchSize 是查询审计日志时每条响应消息最多包含的记录数
+const auditBatchSize = 200
+
+// auditLog 是只追加的审计日志文件，关闭之后的写入和重新打开都会被忽略
+type auditLog struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	closed bool
+}
+
+// openAuditLog 以追加模式打开审计日志，文件不存在时创建
+func openAuditLog(path string) (*auditLog, error) {
+	a := &auditLog{path: path}
+	if err := a.reopen(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// reopen 重新打开审计日志文件，用于日志轮转之后
+func (a *auditLog) reopen() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	old := a.file
+	if a.closed {
+		// daemon 退出时已经关闭，不再重新打开
+		a.mu.Unlock()
+		_ = f.Close()
+		return nil
+	}
+	a.file = f
+	a.mu.Unlock()
+
+	if old != nil {
+		_ = old.Close()
+	}
+
+	return nil
+}
+
+// write 追加一条记录
+func (a *auditLog) write(e *codec.AuditEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return nil
+	}
+
+	_, err = a.file.Write(data)
+	return err
+}
+
+// Close 关闭审计日志文件，重复调用时直接返回
+func (a *auditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return nil
+	}
+
+	a.closed = true
+	return a.file.Close()
+}
+
+// startAudit 在配置开启时打开审计日志，返回的审计日志在 daemon 退出时关闭
+//
+// 注意事项：
+//
+//	开启了审计却无法打开审计日志时返回错误，daemon 不能在没有审计记录的情况下处理请求
+func (sv *Supervisor) startAudit() (*auditLog, error) {
+	cfg := config.GetConfig().Audit
+	if !cfg.Enabled || cfg.File == "" {
+		return nil, nil
+	}
+
+	a, err := openAuditLog(cfg.File)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open audit log: %w", err)
+	}
+	sv.audit = a
+
+	return a, nil
+}
+
+// auditResponder 在发送响应的同时记录最终响应的状态码和响应中的进程
+type auditResponder struct {
+	responder
+
+	mu        sync.Mutex
+	code      int
+	message   string
+	processes []string
+}
+
+func (ar *auditResponder) WriteResponse(id uint32, res *codec.ResponseMsg) error {
+	ar.mu.Lock()
+	for _, info := range res.Processes {
+		name := info.Project + "::" + info.Name
+		if !slices.Contains(ar.processes, name) {
+			ar.processes = append(ar.processes, name)
+		}
+	}
+	if !res.More {
+		ar.code = res.Code
+		if res.Code >= 300 {
+			ar.message = res.Message
+		}
+	}
+	ar.mu.Unlock()
+
+	return ar.responder.WriteResponse(id, res)
+}
+
+// splitNames 按 ; 拆分请求中的项目名或进程名，空字符串返回 nil
+func splitNames(names string) []string {
+	if names == "" {
+		return nil
+	}
+
+	return strings.Split(names, ";")
+}
+
+// auditTargets 返回请求涉及的目标
+//
+// 改变进程状态的请求使用响应中的进程，名称中带有项目名，便于按项目查询；
+// 查询类的请求和没有返回进程的请求使用请求中的项目名或进程名。
+func auditTargets(msg *codec.ActionMsg, processes []string) []string {
+	if len(processes) > 0 && msg.Action != codec.ActionStatus {
+		return processes
+	}
+
+	switch msg.Action {
+	case codec.ActionReload, codec.ActionEvents:
+		return splitNames(msg.Projects)
+	case codec.ActionShutdown, codec.ActionKill, codec.ActionDump, codec.ActionLoad, codec.ActionAudit, codec.ActionRun:
+		return nil
+	}
+
+	return splitNames(msg.Processes)
+}
+
+// auditVia 返回会话的请求来源
+func (se *SpmSession) auditVia() string {
+	if se.peer.remote() {
+		return "remote"
+	}
+	if _, ok := se.out.(*httpResponder); ok {
+		return "http"
+	}
+
+	return "socket"
+}
+
+// startAudit 开始记录一个请求，返回的函数在请求处理完之后写入审计日志
+//
+// 注意事项：
+//
+//	会话的 out 被替换为 auditResponder，调用方必须是只处理这个请求的会话副本
+func (se *SpmSession) startAudit(msg *codec.ActionMsg) func() {
+	if se.sv.audit == nil {
+		return func() {}
+	}
+
+	start := time.Now()
+	e := &codec.AuditEntry{
+		Time:    start,
+		Via:     se.auditVia(),
+		Uid:     -1,
+		Pid:     -1,
+		Action:  msg.Action.String(),
+		WorkDir: msg.WorkDir,
+		Signal:  msg.Signal,
+	}
+
+	if msg.Action == codec.ActionRun {
+		e.Command = msg.CmdLine
+	}
+
+	if p := se.peer; p.remote() {
+		e.Cert = p.CommonName
+		e.Addr = p.Addr
+	} else if p != nil {
+		e.User = p.User
+		e.Uid = p.Uid
+		e.Pid = p.Pid
+	}
+
+	ar := &auditResponder{responder: se.out}
+	se.out = ar
+
+	return func() {
+		ar.mu.Lock()
+		e.Code = ar.code
+		e.Message = ar.message
+		e.Targets = auditTargets(msg, ar.processes)
+		ar.mu.Unlock()
+		e.Duration = float64(time.Since(start).Microseconds()) / 1000
+
+		if err := se.sv.audit.write(e); err != nil {
+			se.logger.Errorf("Cannot write audit log: %v", err)
+		}
+	}
+}
+
+// auditFilter 是查询审计日志的条件
+type auditFilter struct {
+	since   time.Time
+	until   time.Time
+	peer    string
+	actions []string
+	target  string
+	failed  bool
+}
+
+func newAuditFilter(msg *codec.ActionMsg) (*auditFilter, error) {
+	if msg.Target != "" {
+		if _, err := path.Match(msg.Target, ""); err != nil {
+			return nil, fmt.Errorf("invalid target %q: %w", msg.Target, err)
+		}
+	}
+
+	return &auditFilter{
+		since:   msg.Since,
+		until:   msg.Until,
+		peer:    msg.Peer,
+		actions: msg.Actions,
+		target:  msg.Target,
+		failed:  msg.Failed,
+	}, nil
+}
+
+// match 判断记录是否满足所有条件
+func (f *auditFilter) match(e *codec.AuditEntry) bool {
+	if !f.since.IsZero() && e.Time.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && e.Time.After(f.until) {
+		return false
+	}
+
+	if f.peer != "" && f.peer != e.User && f.peer != e.Cert && f.peer != strconv.Itoa(e.Uid) {
+		return false
+	}
+
+	if len(f.actions) > 0 && !slices.Contains(f.actions, e.Action) {
+		return false
+	}
+
+	if f.failed && e.Code >= 200 && e.Code < 300 {
+		return false
+	}
+
+	if f.target != "" {
+		return slices.ContainsFunc(e.Targets, func(t string) bool {
+			ok, _ := path.Match(f.target, t)
+			return ok
+		})
+	}
+
+	return true
+}
+
+// sendAudits 发送一批审计记录，final 为 true 时是最后一条响应
+func (se *SpmSession) sendAudits(entries []*codec.AuditEntry, final bool) codec.ResponseCtl {
+	for {
+		n := min(len(entries), auditBatchSize)
+		last := n == len(entries)
+
+		res := &codec.ResponseMsg{
+			Code:    200,
+			Message: codec.ActionResponse[codec.ActionAudit],
+			Audits:  entries[:n],
+			More:    !(final && last),
+		}
+		if se.sendResponse(res, codec.ResponseNormal) == codec.ResponseMsgErr {
+			return codec.ResponseMsgErr
+		}
+
+		entries = entries[n:]
+		if last {
+			return codec.ResponseNormal
+		}
+	}
+}
+
+// readAuditFile 读取一个审计日志文件，gzip 压缩的轮转文件自动解压，emit 返回 false 时停止读取
+func readAuditFile(path string, emit func(e *codec.AuditEntry) bool) error {
+	f, err := openLogFile(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), codec.MaxFrameSize)
+
+	for scanner.Scan() {
+		e := new(codec.AuditEntry)
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			continue
+		}
+		if !emit(e) {
+			return nil
+		}
+	}
+
+	return scanner.Err()
+}
+
+// doAudit 处理 ActionAudit 请求，按条件查询审计日志，以流式响应的方式发送结果
+//
+// 注意事项：
+//
+//	包括 logrotate 轮转出的 <file>.N 和 <file>.N.gz，按修改时间从旧到新读取
+//	Lines 大于 0 时只返回满足条件的最后 Lines 条记录，需要读完所有文件之后才开始发送
+func (se *SpmSession) doAudit(msg *codec.ActionMsg) codec.ResponseCtl {
+	if se.sv.audit == nil {
+		return se.sendResponse(&codec.ResponseMsg{
+			Code:    404,
+			Message: "audit log is disabled",
+		}, codec.ResponseMsgErr)
+	}
+
+	filter, err := newAuditFilter(msg)
+	if err != nil {
+		return se.sendResponse(&codec.ResponseMsg{
+			Code:    400,
+			Message: err.Error(),
+		}, codec.ResponseMsgErr)
+	}
+
+	entries := make([]*codec.AuditEntry, 0, auditBatchSize)
+	result := codec.ResponseNormal
+	emit := func(e *codec.AuditEntry) bool {
+		if se.ctx.Err() != nil {
+			return false
+		}
+		if !filter.match(e) {
+			return true
+		}
+
+		entries = append(entries, e)
+		if msg.Lines > 0 {
+			if len(entries) > msg.Lines {
+				entries = entries[1:]
+			}
+			return true
+		}
+
+		if len(entries) == auditBatchSize {
+			if se.sendAudits(entries, false) == codec.ResponseMsgErr {
+				result = codec.ResponseMsgErr
+				return false
+			}
+			entries = make([]*codec.AuditEntry, 0, auditBatchSize)
+		}
+		return true
+	}
+
+	// 按从旧到新的顺序读取轮转文件和当前文件
+	for _, path := range rotatedFiles(se.sv.audit.path) {
+		if err := readAuditFile(path, emit); err != nil {
+			se.logger.Warnf("read audit log %s failed: %v", path, err)
+		}
+		if result != codec.ResponseNormal || se.ctx.Err() != nil {
+			return result
+		}
+	}
+
+	return se.sendAudits(entries, true)
+}
@@ -13,6 +13,7 @@ import (
 //	toDo: 操作类型（ActionStart/ActionStop/ActionRestart/ActionStatus）
 //	opt: Procfile 配置选项
 //	procs: 进程名列表，["*"] 表示所有进程
+//	progress: 每处理完一个进程就调用一次，用于流式返回进度，可以为 nil
 //
 // 返回：
 //
@@ -35,13 +36,13 @@ import (
 //
 // 示例：
 //
-//	infos := sv.BatchDo(ActionStart, opt, []string{"*"})
-//	infos := sv.BatchDo(ActionStop, opt, []string{"web-server", "worker"})
+//	infos := sv.BatchDo(ActionStart, opt, []string{"*"}, nil)
+//	infos := sv.BatchDo(ActionStop, opt, []string{"web-server", "worker"}, nil)
 //
 // 创建时间: 2025-12-06
-func (sv *Supervisor) BatchDo(toDo codec.ActionCtl, opt *ProcfileOption, procs []string) []*codec.ProcInfo {
+func (sv *Supervisor) BatchDo(toDo codec.ActionCtl, opt *ProcfileOption, procs []string, progress func(*codec.ProcInfo)) []*codec.ProcInfo {
 	var doFn func(*Process) *Process
-	var doMany func(string) []*Process
+	var doMany func(string, func(*Process)) []*Process
 
 	proj, _ := sv.UpdateApp(true, opt)
 	if proj == nil {
@@ -64,42 +65,62 @@ func (sv *Supervisor) BatchDo(toDo codec.ActionCtl, opt *ProcfileOption, procs [
 		doMany = sv.StatusAll
 	}
 
+	var report func(*Process)
+	if progress != nil {
+		report = func(p *Process) {
+			progress(sv.procInfo(p, proj.Name))
+		}
+	}
+
 	var pInfo = make([]*codec.ProcInfo, 0)
 	if slices.Contains(procs, "*") {
-		completed := doMany("*")
+		completed := doMany("*", report)
 
 		for _, p := range completed {
-			id := sv.procList.Index(p.FullName)
-
-			pInfo = append(pInfo, &codec.ProcInfo{
-				ID:      id,
-				Pid:     p.Pid,
-				Name:    p.Name,
-				Project: proj.Name,
-				StartAt: p.StartAt,
-				StopAt:  p.StopAt,
-				Status:  p.State,
-			})
+			pInfo = append(pInfo, sv.procInfo(p, proj.Name))
 		}
 	} else {
 		for _, name := range procs {
 			proc := sv.GetProcByName(name)
 			p := doFn(proc)
 			if p != nil {
-				id := sv.procList.Index(p.FullName)
-
-				pInfo = append(pInfo, &codec.ProcInfo{
-					ID:      id,
-					Pid:     p.Pid,
-					Name:    p.Name,
-					Project: proj.Name,
-					StartAt: p.StartAt,
-					StopAt:  p.StopAt,
-					Status:  p.State,
-				})
+				info := sv.procInfo(p, proj.Name)
+				pInfo = append(pInfo, info)
+				if progress != nil {
+					progress(info)
+				}
 			}
 		}
 	}
 
 	return pInfo
 }
+
+// procInfo 把进程实例转换为返回给客户端的 ProcInfo
+func (sv *Supervisor) procInfo(p *Process, project string) *codec.ProcInfo {
+	info := &codec.ProcInfo{
+		ID:         sv.procList.Index(p.FullName),
+		Pid:        p.Pid,
+		Name:       p.Name,
+		Project:    project,
+		Port:       p.Port,
+		StartAt:    p.StartAt,
+		StopAt:     p.StopAt,
+		Status:     p.State,
+		Ready:      p.Ready,
+		StatusText: p.StatusText,
+	}
+
+	// 启动失败时返回的是进程的副本，输出和退出记录要从进程表中的实例读取
+	if proc, ok := sv.findProc(p.FullName); ok {
+		info.LogDropped = proc.logDropped.Load()
+		info.History = proc.exitHistory()
+		if p.State == codec.ProcessFailed {
+			for _, line := range proc.recentOutput(exitTailLines) {
+				info.Tail = append(info.Tail, line.Line)
+			}
+		}
+	}
+
+	return info
+}
@@ -0,0 +1,80 @@
+// 前台模式下的多路输出
+//
+// spm start -f 时所有进程的输出都打印到终端，每行前面加上
+// "HH:MM:SS name.1 |" 形式的标签，标签按最长的进程名对齐，
+// 每个进程使用固定的颜色。进程启动、退出、重启等事件以 system 标签输出。
+
+package supervisor
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"spm/pkg/codec"
+	"spm/pkg/config"
+	"spm/pkg/utils"
+)
+
+// systemLabel 是前台模式下 supervisor 事件的标签
+const systemLabel = "system"
+
+// systemColor 是 supervisor 事件使用的颜色
+const systemColor = "1;37"
+
+// console 把多个进程的输出合并打印到标准输出
+type console struct {
+	mu    sync.Mutex
+	once  sync.Once
+	width int
+	color bool
+}
+
+var foreground = &console{width: len(systemLabel)}
+
+// register 登记一个标签，所有标签按最长的一个对齐
+func (c *console) register(label string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.width = max(c.width, len(label))
+}
+
+// print 输出一行带标签的文本
+func (c *console) print(t time.Time, label, color, text string) {
+	c.once.Do(func() {
+		c.color = utils.ColorEnabled(os.Stdout)
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prefix := fmt.Sprintf("%s %-*s |", t.Format(time.TimeOnly), c.width, label)
+	if c.color {
+		prefix = utils.Colorize(color, prefix)
+	}
+
+	_, _ = fmt.Fprintf(os.Stdout, "%s %s\n", prefix, strings.TrimRight(text, "\r"))
+}
+
+// consoleLabel 返回进程在前台输出中的标签
+func (p *Process) consoleLabel() string {
+	return fmt.Sprintf("%s.%d", p.Name, p.Instance)
+}
+
+// printLine 在前台模式下输出进程的一行日志
+func (p *Process) printLine(line *codec.LogLine) {
+	foreground.print(line.Time, p.consoleLabel(), utils.ColorFor(p.FullName), line.Line)
+}
+
+// event 在前台模式下输出进程的系统事件
+func (p *Process) event(format string, args ...any) {
+	if !config.ForegroundFlag {
+		return
+	}
+
+	text := p.consoleLabel() + " " + fmt.Sprintf(format, args...)
+	foreground.print(time.Now(), systemLabel, systemColor, text)
+}
@@ -1,12 +1,13 @@
 package supervisor
 
 import (
-	"encoding/binary"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
-	"strconv"
+	"sync"
 
 	"spm/pkg/codec"
 	"spm/pkg/config"
@@ -16,97 +17,305 @@ import (
 	"go.uber.org/zap"
 )
 
+// SpmClient 是与 daemon 之间的一个长连接
+//
+// 一个连接上可以同时进行多个请求，后台 goroutine 读取响应帧并按请求 ID 交给对应的请求。
 type SpmClient struct {
 	sock   *rpcSocket
 	logger *zap.SugaredLogger
+
+	mu      sync.Mutex
+	nextID  uint32
+	pending map[uint32]*clientCall
+	err     error         // 连接断开的原因
+	done    chan struct{} // 连接断开时关闭
 }
 
-func ClientRun(msg *codec.ActionMsg) []*codec.ProcInfo {
-	c := new(SpmClient)
-	c.logger = logger.Logging("spm-cli")
+// clientCall 是一个进行中的请求
+type clientCall struct {
+	frames chan *codec.Frame
+	quit   chan struct{} // 请求结束时关闭，不再接收响应帧
+}
 
-	conn, err := net.Dial("unix", config.GetConfig().Socket)
+// Dial 连接 daemon 并完成握手，指定了远程 daemon 的地址时通过 TLS 连接远程 daemon
+func Dial() (*SpmClient, error) {
+	c := &SpmClient{
+		logger:  logger.Logging("spm-cli"),
+		pending: make(map[uint32]*clientCall),
+		done:    make(chan struct{}),
+	}
+
+	var conn net.Conn
+	var err error
+	if host := config.RemoteHost(); host != "" {
+		conn, err = dialRemote(host)
+	} else {
+		conn, err = net.Dial("unix", config.GetConfig().Socket)
+	}
 	if err != nil {
 		c.logger.Error(err)
-		_, _ = fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
-		return nil
+		return nil, err
 	}
 
-	defer func() {
-		_ = conn.Close()
-	}()
-
 	c.sock = &rpcSocket{
 		conn: conn,
 	}
 
-	var data []byte
+	if err = c.handshake(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	go c.readLoop()
+
+	return c, nil
+}
+
+// Close 关闭连接，进行中的请求都会结束
+func (c *SpmClient) Close() error {
+	return c.sock.Close()
+}
+
+// handshake 与 daemon 交换协议版本，daemon 的版本不兼容时返回错误
+func (c *SpmClient) handshake() error {
+	if err := codec.WriteHandshake(c.sock.conn); err != nil {
+		c.logger.Error(err)
+		return err
+	}
 
+	version, err := codec.ReadHandshake(c.sock.conn)
+	if errors.Is(err, codec.ErrIncompatible) {
+		// 旧版本的 daemon 不认识握手数据，会按旧协议返回一条错误响应
+		var legacy *codec.LegacyFrameError
+		if errors.As(err, &legacy) {
+			version = 1
+		} else {
+			return err
+		}
+	} else if err != nil {
+		c.logger.Error(err)
+		return err
+	}
+
+	if version != codec.ProtocolVersion {
+		return fmt.Errorf("%w: daemon protocol %d, client protocol %d, restart the daemon with the same spm version",
+			codec.ErrIncompatible, version, codec.ProtocolVersion)
+	}
+
+	return nil
+}
+
+// readLoop 读取响应帧并交给对应的请求，连接断开时记录原因并关闭 done
+func (c *SpmClient) readLoop() {
+	for {
+		frame, err := c.sock.ReadFrame()
+		if err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+			close(c.done)
+			return
+		}
+
+		c.mu.Lock()
+		call, ok := c.pending[frame.ID]
+		c.mu.Unlock()
+
+		// 已经结束的请求在取消之后可能还会收到响应
+		if !ok {
+			continue
+		}
+
+		select {
+		case call.frames <- frame:
+		case <-call.quit:
+		}
+	}
+}
+
+// send 编码并发送一条请求消息，每条请求使用新的请求 ID
+func (c *SpmClient) send(msg *codec.ActionMsg) (uint32, *clientCall, error) {
 	encoder, err := codec.GetEncoder()
 	if err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
-		return nil
+		return 0, nil, err
 	}
 
-	data, err = encoder.Marshal(msg)
+	data, err := encoder.Marshal(msg)
 	if err != nil {
 		c.logger.Error(err)
-		_, _ = fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
-		return nil
+		return 0, nil, err
 	}
 
-	size := make([]byte, strconv.IntSize)
-	binary.BigEndian.PutUint64(size, uint64(len(data)))
+	call := &clientCall{
+		frames: make(chan *codec.Frame, 16),
+		quit:   make(chan struct{}),
+	}
 
-	err = c.sock.Send(size)
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = call
+	c.mu.Unlock()
+
+	err = c.sock.WriteFrame(&codec.Frame{
+		Type:    codec.FrameRequest,
+		ID:      id,
+		Payload: data,
+	})
 	if err != nil {
+		c.finish(id, call)
 		c.logger.Error(err)
-		_, _ = fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
-		return nil
+		return 0, nil, err
 	}
 
-	err = c.sock.Send(data)
-	if err != nil {
-		c.logger.Error(err)
-		_, _ = fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+	return id, call, nil
+}
+
+// finish 结束一个请求，之后收到的响应帧都会被丢弃
+func (c *SpmClient) finish(id uint32, call *clientCall) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+
+	close(call.quit)
+}
+
+// cancel 通知 daemon 取消请求
+func (c *SpmClient) cancel(id uint32) {
+	_ = c.sock.WriteFrame(&codec.Frame{
+		Type: codec.FrameCancel,
+		ID:   id,
+	})
+}
+
+// closeErr 返回连接断开的原因，daemon 正常关闭连接时返回 nil
+func (c *SpmClient) closeErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if errors.Is(c.err, io.EOF) {
 		return nil
 	}
 
-	var length uint64
-	data, err = c.sock.Recv(strconv.IntSize)
+	return c.err
+}
+
+// Do 发送一个请求并逐条处理它的响应
+//
+// 参数：
+//
+//	ctx: 取消时通知 daemon 取消请求并立即返回
+//	msg: 请求消息
+//	handler: 处理每一条响应的回调函数，返回 false 时取消请求并停止接收
+//
+// 返回：
+//
+//	error: 连接或者编解码失败、ctx 被取消时返回错误
+//
+// 注意事项：
+//
+//	可以在多个 goroutine 中同时调用，收到最后一条响应或者连接关闭时返回
+func (c *SpmClient) Do(ctx context.Context, msg *codec.ActionMsg, handler func(*codec.ResponseMsg) bool) error {
+	id, call, err := c.send(msg)
 	if err != nil {
-		if err != io.EOF {
+		return err
+	}
+
+	defer c.finish(id, call)
+
+	// handle 处理一个响应帧，返回 true 表示请求已经结束
+	handle := func(frame *codec.Frame) (bool, error) {
+		var res = new(codec.ResponseMsg)
+		if err := cbor.Unmarshal(frame.Payload, res); err != nil {
 			c.logger.Error(err)
-			_, _ = fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
-			return nil
+			c.cancel(id)
+			return true, err
 		}
-	}
 
-	if data != nil {
-		length = binary.BigEndian.Uint64(data)
+		final := frame.Type == codec.FrameResponse
+		if !handler(res) {
+			if !final {
+				c.cancel(id)
+			}
+			return true, nil
+		}
+
+		return final, nil
 	}
 
-	data, err = c.sock.Recv(length)
-	if err != nil {
-		if err != io.EOF {
-			c.logger.Error(err)
-			_, _ = fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
-			return nil
+	for {
+		select {
+		case <-ctx.Done():
+			c.cancel(id)
+			return ctx.Err()
+		case frame := <-call.frames:
+			if end, err := handle(frame); end {
+				return err
+			}
+		case <-c.done:
+			// 连接断开之前收到的响应帧仍然需要处理
+			select {
+			case frame := <-call.frames:
+				if end, err := handle(frame); end {
+					return err
+				}
+				continue
+			default:
+			}
+
+			return c.closeErr()
 		}
 	}
+}
 
-	if len(data) == 0 {
+func ClientRun(msg *codec.ActionMsg) []*codec.ProcInfo {
+	return ClientProgress(msg, nil)
+}
+
+// ClientProgress 发送批量操作请求，每处理完一个进程调用一次 progress
+//
+// 参数：
+//
+//	msg: 请求消息
+//	progress: 处理进度的回调函数，为 nil 时不要求 daemon 返回进度
+//
+// 返回：
+//
+//	[]*codec.ProcInfo: 最后一条响应中的进程列表
+func ClientProgress(msg *codec.ActionMsg, progress func(*codec.ProcInfo)) []*codec.ProcInfo {
+	c, err := Dial()
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 		return nil
 	}
 
-	var res = new(codec.ResponseMsg)
-	err = cbor.Unmarshal(data, res)
+	defer func() {
+		_ = c.Close()
+	}()
+
+	msg.Progress = progress != nil
+
+	var res *codec.ResponseMsg
+	err = c.Do(context.Background(), msg, func(r *codec.ResponseMsg) bool {
+		if r.More {
+			for _, info := range r.Processes {
+				progress(info)
+			}
+			return true
+		}
+
+		res = r
+		return true
+	})
 	if err != nil {
-		c.logger.Error(err)
 		_, _ = fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 		return nil
 	}
 
+	if res == nil {
+		return nil
+	}
+
 	_, _ = fmt.Fprintf(os.Stdout, "%d\t%s\n\n", res.Code, res.Message)
 
 	if res.Processes != nil {
@@ -115,3 +324,31 @@ func ClientRun(msg *codec.ActionMsg) []*codec.ProcInfo {
 
 	return nil
 }
+
+// ClientStream 发送请求并逐条处理流式响应
+//
+// 参数：
+//
+//	ctx: 取消时通知 daemon 取消请求
+//	msg: 请求消息
+//	handler: 处理每一条响应的回调函数，返回 false 时停止接收
+//
+// 返回：
+//
+//	error: 连接或者编解码失败时返回错误
+//
+// 注意事项：
+//
+//	收到最后一条响应或者连接关闭时结束
+func ClientStream(ctx context.Context, msg *codec.ActionMsg, handler func(*codec.ResponseMsg) bool) error {
+	c, err := Dial()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = c.Close()
+	}()
+
+	return c.Do(ctx, msg, handler)
+}
@@ -1,8 +1,10 @@
 package supervisor
 
 import (
+	"fmt"
 	"net"
 	"sync"
+	"syscall"
 
 	"go.uber.org/zap"
 
@@ -38,7 +40,12 @@ SERVER:
 					continue
 				}
 
-				session := NewSession(s.sv, conn)
+				peer, err := readPeerCred(conn)
+				if err != nil {
+					s.logger.Warnf("Cannot read peer credentials: %v", err)
+				}
+
+				session := NewSession(s.sv, conn, peer)
 
 				s.wg.Add(1)
 				go func(se *SpmSession) {
@@ -57,12 +64,33 @@ SERVER:
 	s.logger.Info("Supervisor server is stopped")
 }
 
-func StartServer(s *Supervisor) {
-	socket, err := net.Listen("unix", config.GetConfig().Socket)
+// StartServer 监听控制套接字并处理客户端请求
+//
+// 开始监听后向 ready 发送 nil；无法按配置设置 socket 的权限时关闭监听并发送错误，
+// daemon 收到错误后退出，不能让 socket 以错误的权限接受连接。
+//
+// 注意事项：
+//
+//	socket 在 0177 的 umask 下创建，修改属主和权限之前只有 daemon 的用户可以连接，
+//	避免在 Listen 和 chmod 之间的窗口内被其他用户连接。umask 是进程级别的，
+//	此时 daemon 还在等待 ready，不会有其他 goroutine 创建文件
+func StartServer(s *Supervisor, ready chan<- error) {
+	file := config.GetConfig().Socket
+
+	mask := syscall.Umask(0177)
+	socket, err := net.Listen("unix", file)
+	syscall.Umask(mask)
 	if err != nil {
 		panic(err)
 	}
 
+	if err := setSocketPerms(file); err != nil {
+		_ = socket.Close()
+		ready <- fmt.Errorf("cannot set socket permissions: %w", err)
+		return
+	}
+	ready <- nil
+
 	server := &spmServer{
 		sv:     s,
 		sock:   socket,
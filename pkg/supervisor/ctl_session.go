@@ -2,14 +2,17 @@
 package supervisor
 
 import (
-	"encoding/binary"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"spm/pkg/codec"
 	"spm/pkg/config"
@@ -19,48 +22,113 @@ import (
 	"go.uber.org/zap"
 )
 
+// rpcSocket 在连接上收发 codec 定义的帧，写入加锁以便多个 goroutine 共用
 type rpcSocket struct {
 	conn net.Conn
+	wmu  sync.Mutex
 }
 
-func (s *rpcSocket) Recv(l uint64) ([]byte, error) {
-	buf := make([]byte, l)
-	n, err := s.conn.Read(buf)
+// ReadFrame 读取一个完整的帧
+func (s *rpcSocket) ReadFrame() (*codec.Frame, error) {
+	return codec.ReadFrame(s.conn)
+}
+
+// WriteFrame 发送一个帧
+func (s *rpcSocket) WriteFrame(f *codec.Frame) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	return codec.WriteFrame(s.conn, f)
+}
+
+// WriteResponse 编码响应并以请求 ID 发送，More 为 true 时发送部分响应帧
+func (s *rpcSocket) WriteResponse(id uint32, res *codec.ResponseMsg) error {
+	encoder, err := codec.GetEncoder()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if n == 0 {
-		return buf, io.EOF
-	} else {
-		return buf, nil
+
+	buf, err := encoder.Marshal(res)
+	if err != nil {
+		return err
+	}
+
+	frameType := codec.FrameResponse
+	if res.More {
+		frameType = codec.FramePartial
 	}
+
+	return s.WriteFrame(&codec.Frame{
+		Type:    frameType,
+		ID:      id,
+		Payload: buf,
+	})
 }
 
-func (s *rpcSocket) Send(v []byte) error {
-	_, e := s.conn.Write(v)
-	return e
+// CloseRead 关闭连接的读端，已经在处理的请求仍然可以发送响应
+func (s *rpcSocket) CloseRead() {
+	if c, ok := s.conn.(interface{ CloseRead() error }); ok {
+		_ = c.CloseRead()
+		return
+	}
+	_ = s.conn.SetReadDeadline(time.Now())
 }
 
 func (s *rpcSocket) Close() error {
 	return s.conn.Close()
 }
 
+// responder 把一个请求的响应发送给客户端，控制 socket 和 HTTP API 各有一个实现
+type responder interface {
+	WriteResponse(id uint32, res *codec.ResponseMsg) error
+}
+
+// SpmSession 是一个客户端连接上的会话
+//
+// 连接上的每个请求在单独的 goroutine 中处理，使用一个只属于该请求的 SpmSession 副本，
+// 副本的 reqID 和 ctx 对应这个请求，客户端取消请求或者断开连接时 ctx 被取消。
 type SpmSession struct {
 	sv     *Supervisor
 	sock   *rpcSocket
+	out    responder       // 响应的发送方式，控制 socket 的会话中就是 sock
+	reqID  uint32          // 当前处理的请求 ID，响应帧使用相同的 ID
+	ctx    context.Context // 当前请求的 ctx
+	peer   *peerCred       // 连接对端的身份，无法识别时为 nil
+	access *access         // 对端的访问权限，nil 表示不受限制
 	logger *zap.SugaredLogger
 }
 
-func NewSession(s *Supervisor, c net.Conn) *SpmSession {
+// NewSession 创建连接上的会话，peer 是连接对端的身份，用于检查请求的权限
+func NewSession(s *Supervisor, c net.Conn, peer *peerCred) *SpmSession {
+	sock := &rpcSocket{
+		conn: c,
+	}
+
 	return &SpmSession{
-		sv: s,
-		sock: &rpcSocket{
-			conn: c,
-		},
+		sv:     s,
+		sock:   sock,
+		out:    sock,
+		ctx:    context.Background(),
+		peer:   peer,
+		access: newAccess(peer),
 		logger: logger.Logging("spm-serv"),
 	}
 }
 
+// forRequest 返回处理一个请求的会话副本
+func (se *SpmSession) forRequest(id uint32, ctx context.Context) *SpmSession {
+	return &SpmSession{
+		sv:     se.sv,
+		sock:   se.sock,
+		out:    se.out,
+		reqID:  id,
+		ctx:    ctx,
+		peer:   se.peer,
+		access: se.access,
+		logger: se.logger,
+	}
+}
+
 // errorResponse 创建错误响应消息的辅助函数
 //
 // 参数：
@@ -102,37 +170,66 @@ func (se *SpmSession) errorResponse(err error) (*codec.ResponseMsg, codec.Respon
 //	codec.ResponseCtl: 如果发送成功返回传入的result，失败返回ResponseMsgErr
 //
 // 功能：
-//  1. 编码响应消息
-//  2. 发送消息长度
-//  3. 发送消息内容
-//  4. 统一处理发送过程中的错误
+//  1. 通过会话的 responder 以当前请求 ID 发送响应，More 为 true 时是部分响应
+//  2. 统一处理发送过程中的错误
 func (se *SpmSession) sendResponse(res *codec.ResponseMsg, result codec.ResponseCtl) codec.ResponseCtl {
-	encoder, err := codec.GetEncoder()
-	if err != nil {
+	if err := se.out.WriteResponse(se.reqID, res); err != nil {
 		se.logger.Error(err)
 		return codec.ResponseMsgErr
 	}
 
-	buf, err := encoder.Marshal(res)
+	return result
+}
+
+// handshake 与客户端交换协议版本，版本不兼容时返回错误
+//
+// 注意事项：
+//
+//	旧协议的客户端不会发送握手数据，按旧协议返回一条错误响应，
+//	让旧客户端能够显示 incompatible daemon version 而不是解析失败
+func (se *SpmSession) handshake() error {
+	version, err := codec.ReadHandshake(se.sock.conn)
+
+	var legacy *codec.LegacyFrameError
+	if errors.As(err, &legacy) {
+		se.rejectLegacy(legacy)
+		return err
+	}
 	if err != nil {
-		se.logger.Error(err)
-		return codec.ResponseMsgErr
+		return err
 	}
 
-	size := make([]byte, strconv.IntSize)
-	binary.BigEndian.PutUint64(size, uint64(len(buf)))
+	if err = codec.WriteHandshake(se.sock.conn); err != nil {
+		return err
+	}
 
-	if err = se.sock.Send(size); err != nil {
-		se.logger.Error(err)
-		return codec.ResponseMsgErr
+	if version != codec.ProtocolVersion {
+		return fmt.Errorf("%w: client protocol %d, daemon protocol %d", codec.ErrIncompatible, version, codec.ProtocolVersion)
 	}
 
-	if err = se.sock.Send(buf); err != nil {
-		se.logger.Error(err)
-		return codec.ResponseMsgErr
+	return nil
+}
+
+// rejectLegacy 按旧协议向旧版本的客户端返回版本不兼容的错误
+func (se *SpmSession) rejectLegacy(legacy *codec.LegacyFrameError) {
+	if err := codec.DiscardLegacy(se.sock.conn, legacy.Length); err != nil {
+		return
 	}
 
-	return result
+	encoder, err := codec.GetEncoder()
+	if err != nil {
+		return
+	}
+
+	buf, err := encoder.Marshal(&codec.ResponseMsg{
+		Code:    505,
+		Message: fmt.Sprintf("%v: daemon protocol %d, please upgrade spm", codec.ErrIncompatible, codec.ProtocolVersion),
+	})
+	if err != nil {
+		return
+	}
+
+	_ = codec.WriteLegacy(se.sock.conn, buf)
 }
 
 func (se *SpmSession) Handle() codec.ResponseCtl {
@@ -140,26 +237,85 @@ func (se *SpmSession) Handle() codec.ResponseCtl {
 		_ = se.sock.Close()
 	}()
 
-	// 服务器端处理收到的指令
+	// 先交换协议版本，不兼容的客户端直接断开
+	if err := se.handshake(); err != nil {
+		se.logger.Warn(err)
+		return codec.ResponseMsgErr
+	}
 
-	// 先接收消息的字节数组长度
-	buf, err := se.sock.Recv(strconv.IntSize)
-	if err != nil {
-		res, result := se.errorResponse(err)
-		return se.sendResponse(res, result)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		shutdown atomic.Bool
+		requests = make(map[uint32]context.CancelFunc)
+	)
+
+	// 连接断开之前一直接收请求帧，每个请求在单独的 goroutine 中处理
+	for {
+		frame, err := se.sock.ReadFrame()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				se.logger.Debug(err)
+			}
+			break
+		}
+
+		switch frame.Type {
+		case codec.FrameRequest:
+			reqCtx, reqCancel := context.WithCancel(ctx)
+
+			mu.Lock()
+			requests[frame.ID] = reqCancel
+			mu.Unlock()
+
+			wg.Add(1)
+			go func(req *SpmSession, payload []byte) {
+				defer wg.Done()
+				defer func() {
+					mu.Lock()
+					delete(requests, req.reqID)
+					mu.Unlock()
+					reqCancel()
+				}()
+
+				if req.serve(payload) == codec.ResponseShutdown {
+					// daemon 即将退出，不再接收新的请求
+					shutdown.Store(true)
+					se.sock.CloseRead()
+				}
+			}(se.forRequest(frame.ID, reqCtx), frame.Payload)
+		case codec.FrameCancel:
+			mu.Lock()
+			if reqCancel, ok := requests[frame.ID]; ok {
+				reqCancel()
+			}
+			mu.Unlock()
+		default:
+			se.forRequest(frame.ID, ctx).sendResponse(&codec.ResponseMsg{
+				Code:    400,
+				Message: fmt.Sprintf("unexpected frame type %d", frame.Type),
+			}, codec.ResponseMsgErr)
+		}
 	}
 
-	// 根据长度再接受ActionMsg消息
-	msgLen := binary.BigEndian.Uint64(buf)
-	buf, err = se.sock.Recv(msgLen)
-	if err != nil {
-		res, result := se.errorResponse(err)
-		return se.sendResponse(res, result)
+	// 连接断开时取消所有未完成的请求
+	cancel()
+	wg.Wait()
+
+	if shutdown.Load() {
+		return codec.ResponseShutdown
 	}
 
+	return codec.ResponseNormal
+}
+
+// serve 解码并处理一个请求
+func (se *SpmSession) serve(payload []byte) codec.ResponseCtl {
 	var msg = new(codec.ActionMsg)
-	err = cbor.Unmarshal(buf, msg)
-	if err != nil {
+	if err := cbor.Unmarshal(payload, msg); err != nil {
 		res, result := se.errorResponse(err)
 		return se.sendResponse(res, result)
 	}
@@ -268,7 +424,7 @@ func (se *SpmSession) doRun(msg *codec.ActionMsg) *codec.ResponseMsg {
 	_, _ = se.sv.UpdateApp(false, procOpts)
 
 	// 运行单个的进程
-	infos := se.sv.BatchDo(codec.ActionStart, procOpts, []string{fmt.Sprintf("%s::%s", appName, procName)})
+	infos := se.sv.BatchDo(codec.ActionStart, procOpts, []string{fmt.Sprintf("%s::%s", appName, procName)}, nil)
 
 	return &codec.ResponseMsg{
 		Code:      200,
@@ -298,7 +454,6 @@ func (se *SpmSession) doAction(msg *codec.ActionMsg) *codec.ResponseMsg {
 			names := strings.Split(n, "::")
 			appName := names[0]
 
-			procMap[appName] = make([]string, 0)
 			procMap[appName] = append(procMap[appName], n)
 		} else {
 			localProcs = append(localProcs, n)
@@ -327,6 +482,19 @@ func (se *SpmSession) doAction(msg *codec.ActionMsg) *codec.ResponseMsg {
 		procMap[procOpts.AppName] = localProcs
 	}
 
+	// 客户端要求进度时，每处理完一个进程就返回一条部分响应
+	var progress func(*codec.ProcInfo)
+	if msg.Progress {
+		progress = func(info *codec.ProcInfo) {
+			se.sendResponse(&codec.ResponseMsg{
+				Code:      200,
+				Message:   codec.ActionResponse[msg.Action],
+				Processes: []*codec.ProcInfo{info},
+				More:      true,
+			}, codec.ResponseNormal)
+		}
+	}
+
 	for name, procs := range procMap {
 		var opt *ProcfileOption
 
@@ -337,7 +505,43 @@ func (se *SpmSession) doAction(msg *codec.ActionMsg) *codec.ResponseMsg {
 			opt = &ProcfileOption{AppName: name}
 		}
 
-		infos = append(infos, se.sv.BatchDo(msg.Action, opt, procs)...)
+		infos = append(infos, se.sv.BatchDo(msg.Action, opt, procs, progress)...)
+	}
+
+	return &codec.ResponseMsg{
+		Code:      200,
+		Message:   codec.ActionResponse[msg.Action],
+		Processes: infos,
+	}
+}
+
+// doSignal 处理 ActionSignal 请求，向请求中的进程发送信号，返回收到信号的进程
+func (se *SpmSession) doSignal(msg *codec.ActionMsg) *codec.ResponseMsg {
+	sig, err := parseSignal(msg.Signal)
+	if err != nil {
+		return &codec.ResponseMsg{
+			Code:    400,
+			Message: err.Error(),
+		}
+	}
+
+	procs, err := se.resolveProcs(msg)
+	if err != nil {
+		return &codec.ResponseMsg{
+			Code:    404,
+			Message: err.Error(),
+		}
+	}
+
+	infos := make([]*codec.ProcInfo, 0)
+	for _, p := range procs {
+		if err := p.Signal(sig); err != nil {
+			se.logger.Warn(err)
+			continue
+		}
+
+		project, _, _ := strings.Cut(p.FullName, "::")
+		infos = append(infos, se.sv.procInfo(p, project))
 	}
 
 	return &codec.ResponseMsg{
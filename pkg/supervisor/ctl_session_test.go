@@ -0,0 +1,363 @@
+package supervisor
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"go.uber.org/zap"
+
+	"spm/pkg/codec"
+)
+
+// newTestClient 在 conn 上完成握手并创建客户端，conn 的另一端是 daemon
+func newTestClient(t *testing.T, conn net.Conn) *SpmClient {
+	t.Helper()
+
+	c := &SpmClient{
+		sock:    &rpcSocket{conn: conn},
+		logger:  zap.NewNop().Sugar(),
+		pending: make(map[uint32]*clientCall),
+		done:    make(chan struct{}),
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+
+	if err := c.handshake(); err != nil {
+		t.Fatal(err)
+	}
+	go c.readLoop()
+
+	return c
+}
+
+// serveTestSession 在 net.Pipe 的一端运行会话，返回另一端和会话结束时的返回值
+func serveTestSession(t *testing.T) (net.Conn, <-chan codec.ResponseCtl) {
+	t.Helper()
+
+	client, server := net.Pipe()
+	// 不经过 NewSession，会话的 access 为 nil，不检查权限
+	sock := &rpcSocket{conn: server}
+	se := &SpmSession{
+		sv:     newTestSupervisor(),
+		sock:   sock,
+		out:    sock,
+		ctx:    context.Background(),
+		logger: zap.NewNop().Sugar(),
+	}
+
+	result := make(chan codec.ResponseCtl, 1)
+	go func() {
+		result <- se.Handle()
+	}()
+
+	return client, result
+}
+
+func waitSession(t *testing.T, result <-chan codec.ResponseCtl) codec.ResponseCtl {
+	t.Helper()
+
+	select {
+	case r := <-result:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("session did not finish")
+		return 0
+	}
+}
+
+// subscribe 在后台订阅一个项目的事件，返回收到的响应和 Do 的返回值，订阅生效之后才返回
+func subscribe(t *testing.T, ctx context.Context, c *SpmClient, project string) (<-chan *codec.ResponseMsg, <-chan error) {
+	t.Helper()
+
+	responses := make(chan *codec.ResponseMsg, 16)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Do(ctx, &codec.ActionMsg{Action: codec.ActionEvents, Projects: project}, func(res *codec.ResponseMsg) bool {
+			responses <- res
+			return true
+		})
+	}()
+
+	// daemon 订阅之后先发送一条空的部分响应
+	select {
+	case res := <-responses:
+		if !res.More || len(res.Events) != 0 {
+			t.Fatalf("first response = %+v, want an empty partial response", res)
+		}
+	case err := <-done:
+		t.Fatalf("subscribe %s: %v", project, err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("subscribe %s timed out", project)
+	}
+
+	return responses, done
+}
+
+func TestSessionInterleavedRequests(t *testing.T) {
+	conn, result := serveTestSession(t)
+	c := newTestClient(t, conn)
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	defer cancelA()
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+
+	// 两个流式请求同时在一个连接上进行
+	resA, doneA := subscribe(t, ctxA, c, "ctl-a")
+	resB, doneB := subscribe(t, ctxB, c, "ctl-b")
+
+	// 取消其中一个请求，另一个请求不受影响
+	cancelA()
+	select {
+	case err := <-doneA:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("canceled request err = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("canceled request did not return")
+	}
+
+	eventBus.TryPub(&codec.Event{Type: codec.EventRunning, Project: "ctl-a"}, projectTopic("ctl-a"))
+	eventBus.TryPub(&codec.Event{Type: codec.EventRunning, Project: "ctl-b"}, projectTopic("ctl-b"))
+
+	select {
+	case res := <-resB:
+		if len(res.Events) != 1 || res.Events[0].Project != "ctl-b" {
+			t.Fatalf("events = %+v, want one ctl-b event", res.Events)
+		}
+	case err := <-doneB:
+		t.Fatalf("request b finished early: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("request b did not receive the event")
+	}
+
+	select {
+	case res := <-resA:
+		t.Fatalf("canceled request received %+v", res)
+	default:
+	}
+
+	cancelB()
+	if err := <-doneB; !errors.Is(err, context.Canceled) {
+		t.Fatalf("request b err = %v, want context.Canceled", err)
+	}
+
+	_ = c.Close()
+	if r := waitSession(t, result); r != codec.ResponseNormal {
+		t.Fatalf("Handle() = %v, want ResponseNormal", r)
+	}
+}
+
+func TestSessionUnexpectedFrame(t *testing.T) {
+	conn, result := serveTestSession(t)
+	c := newTestClient(t, conn)
+
+	call := &clientCall{frames: make(chan *codec.Frame, 1), quit: make(chan struct{})}
+	c.mu.Lock()
+	c.pending[42] = call
+	c.mu.Unlock()
+
+	if err := c.sock.WriteFrame(&codec.Frame{Type: codec.FramePartial, ID: 42}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case frame := <-call.frames:
+		var res codec.ResponseMsg
+		if err := cbor.Unmarshal(frame.Payload, &res); err != nil {
+			t.Fatal(err)
+		}
+		if frame.Type != codec.FrameResponse || res.Code != 400 {
+			t.Fatalf("response = type %d code %d, want a final 400", frame.Type, res.Code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no response to an unexpected frame")
+	}
+
+	_ = c.Close()
+	waitSession(t, result)
+}
+
+func TestSessionRejectsLegacyClient(t *testing.T) {
+	conn, result := serveTestSession(t)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// 旧版本的客户端直接发送 64 字节长度前缀和消息内容
+	payload := []byte("legacy request")
+	go func() {
+		_ = codec.WriteLegacy(conn, payload)
+	}()
+
+	head := make([]byte, 64)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		t.Fatal(err)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint64(head))
+	if _, err := io.ReadFull(conn, body); err != nil {
+		t.Fatal(err)
+	}
+
+	var res codec.ResponseMsg
+	if err := cbor.Unmarshal(body, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != 505 {
+		t.Fatalf("legacy response = %+v, want code 505", res)
+	}
+
+	if r := waitSession(t, result); r != codec.ResponseMsgErr {
+		t.Fatalf("Handle() = %v, want ResponseMsgErr", r)
+	}
+}
+
+func TestClientRoutesFramesByID(t *testing.T) {
+	conn, daemon := net.Pipe()
+	defer func() {
+		_ = daemon.Close()
+	}()
+
+	// 假的 daemon：收到两个请求之后先回复后一个请求，两个请求的响应帧交错发送
+	go func() {
+		if _, err := codec.ReadHandshake(daemon); err != nil {
+			return
+		}
+		if err := codec.WriteHandshake(daemon); err != nil {
+			return
+		}
+
+		ids := make([]uint32, 0, 2)
+		for len(ids) < 2 {
+			frame, err := codec.ReadFrame(daemon)
+			if err != nil {
+				return
+			}
+			ids = append(ids, frame.ID)
+		}
+
+		reply := func(id uint32, message string, more bool) {
+			buf, _ := cbor.Marshal(&codec.ResponseMsg{Code: 200, Message: message, More: more})
+			typ := codec.FrameResponse
+			if more {
+				typ = codec.FramePartial
+			}
+			_ = codec.WriteFrame(daemon, &codec.Frame{Type: typ, ID: id, Payload: buf})
+		}
+
+		reply(ids[1], "second", true)
+		reply(ids[0], "first", true)
+		reply(ids[1], "second", false)
+		reply(ids[0], "first", false)
+
+		_, _ = io.Copy(io.Discard, daemon)
+	}()
+
+	c := newTestClient(t, conn)
+
+	type outcome struct {
+		messages []string
+		err      error
+	}
+
+	do := func(project string, out chan<- outcome) {
+		var o outcome
+		o.err = c.Do(context.Background(), &codec.ActionMsg{Action: codec.ActionStatus, Projects: project}, func(res *codec.ResponseMsg) bool {
+			o.messages = append(o.messages, res.Message)
+			return true
+		})
+		out <- o
+	}
+
+	first := make(chan outcome, 1)
+	second := make(chan outcome, 1)
+	go do("first", first)
+	go do("second", second)
+
+	for _, ch := range []chan outcome{first, second} {
+		select {
+		case o := <-ch:
+			if o.err != nil {
+				t.Fatal(o.err)
+			}
+			if len(o.messages) != 2 || o.messages[0] != o.messages[1] {
+				t.Fatalf("messages = %q, want two responses of the same request", o.messages)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("request did not finish")
+		}
+	}
+}
+
+func TestClientCancel(t *testing.T) {
+	conn, daemon := net.Pipe()
+	defer func() {
+		_ = daemon.Close()
+	}()
+
+	canceled := make(chan uint32, 1)
+	go func() {
+		if _, err := codec.ReadHandshake(daemon); err != nil {
+			return
+		}
+		if err := codec.WriteHandshake(daemon); err != nil {
+			return
+		}
+
+		req, err := codec.ReadFrame(daemon)
+		if err != nil {
+			return
+		}
+
+		buf, _ := cbor.Marshal(&codec.ResponseMsg{Code: 200, More: true})
+		if err := codec.WriteFrame(daemon, &codec.Frame{Type: codec.FramePartial, ID: req.ID, Payload: buf}); err != nil {
+			return
+		}
+
+		for {
+			frame, err := codec.ReadFrame(daemon)
+			if err != nil {
+				return
+			}
+			if frame.Type == codec.FrameCancel && frame.ID == req.ID {
+				canceled <- frame.ID
+				return
+			}
+		}
+	}()
+
+	c := newTestClient(t, conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := c.Do(ctx, &codec.ActionMsg{Action: codec.ActionEvents}, func(res *codec.ResponseMsg) bool {
+		// 收到第一条部分响应后取消请求
+		cancel()
+		return true
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Do() err = %v, want context.Canceled", err)
+	}
+
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not receive a cancel frame")
+	}
+
+	c.mu.Lock()
+	pending := len(c.pending)
+	c.mu.Unlock()
+	if pending != 0 {
+		t.Fatalf("%d requests still pending after cancel", pending)
+	}
+}
@@ -101,7 +101,48 @@ func (sv *Supervisor) Daemon() {
 		}
 	}
 
-	go StartServer(sv)
+	// 审计日志在开始接收请求之前打开
+	a, err := sv.startAudit()
+	if err != nil {
+		sv.logger.Error(err)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		sv.ExitCode = 1
+		return
+	}
+	if a != nil {
+		defer func() {
+			_ = a.Close()
+		}()
+	}
+
+	// 前台模式的 AfterStart 会连接控制套接字，需要等待服务端开始监听
+	ready := make(chan error)
+	go StartServer(sv, ready)
+	if err := <-ready; err != nil {
+		sv.logger.Error(err)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		sv.ExitCode = 1
+		return
+	}
+	go sv.watchReopen()
+	go sv.watchNotifications()
+	go sv.watchRestarts()
+
+	if ln := sv.startHTTP(); ln != nil {
+		defer func() {
+			_ = ln.Close()
+		}()
+	}
+	if ln := sv.startMetrics(); ln != nil {
+		defer func() {
+			_ = ln.Close()
+		}()
+	}
+	if ln := sv.startRemote(); ln != nil {
+		defer func() {
+			_ = ln.Close()
+		}()
+	}
 
 	fmt.Printf("\033[1;32;40mSpm supervisor started at %s\033[0m\n\n", sv.StartedAt.Format(time.RFC3339))
 
@@ -111,7 +152,15 @@ func (sv *Supervisor) Daemon() {
 		go sv.AfterStart()
 	}
 
-	sig := <-utils.StopChan
+	var sig os.Signal
+	select {
+	case sig = <-utils.StopChan:
+	case <-sv.stopping:
+		sig = syscall.SIGQUIT
+	case f := <-processFailures:
+		sv.handleFailure(f)
+		sig = syscall.SIGTERM
+	}
 
 	switch sig {
 	case os.Interrupt, syscall.SIGTERM:
@@ -138,10 +187,11 @@ func (sv *Supervisor) Daemon() {
 //
 //	defer sv.Shutdown()  // 确保程序退出时调用
 func (sv *Supervisor) Shutdown() {
-	_ = sv.StopAll("*")
+	_ = sv.StopAll("*", nil)
 
 	for _, name := range sv.procList.All() {
 		proc := sv.GetProcByName(name)
+		proc.CloseListeners()
 		_ = proc.logger.Sync()
 	}
 
@@ -1,8 +1,23 @@
 package supervisor
 
-import "spm/pkg/codec"
+import (
+	"time"
+
+	"spm/pkg/codec"
+)
 
 func (se *SpmSession) dispatch(msg *codec.ActionMsg) codec.ResponseCtl {
+	defer observeRequest(msg.Action, time.Now())
+	defer se.startAudit(msg)()
+
+	if err := se.authorize(msg); err != nil {
+		se.logger.Warn(err)
+		return se.sendResponse(&codec.ResponseMsg{
+			Code:    403,
+			Message: err.Error(),
+		}, codec.ResponseMsgErr)
+	}
+
 	// 处理业务逻辑
 	var res *codec.ResponseMsg
 	var result codec.ResponseCtl
@@ -21,11 +36,14 @@ func (se *SpmSession) dispatch(msg *codec.ActionMsg) codec.ResponseCtl {
 			se.sv.Shutdown()
 		}
 	case codec.ActionLog:
-		res = &codec.ResponseMsg{
-			Code:    405,
-			Message: "Feature not implemented",
-		}
-		result = codec.ResponseMsgErr
+		// 日志以流式响应的方式发送，由 doLogs 自行发送所有消息
+		return se.doLogs(msg)
+	case codec.ActionLogGrep:
+		return se.doLogGrep(msg)
+	case codec.ActionEvents:
+		return se.doEvents(msg)
+	case codec.ActionAudit:
+		return se.doAudit(msg)
 	case codec.ActionDump:
 		res, result = se.doDump()
 	case codec.ActionLoad:
@@ -33,6 +51,9 @@ func (se *SpmSession) dispatch(msg *codec.ActionMsg) codec.ResponseCtl {
 	case codec.ActionRun:
 		res = se.doRun(msg)
 		result = codec.ResponseNormal
+	case codec.ActionSignal:
+		res = se.doSignal(msg)
+		result = codec.ResponseNormal
 	case codec.ActionReload:
 		res = se.doReload(msg)
 		result = codec.ResponseReload
@@ -16,12 +16,17 @@ func (se *SpmSession) doDump() (*codec.ResponseMsg, codec.ResponseCtl) {
 	}
 
 	for name, proj := range se.sv.projectTable.Iter() {
+		// 只保存用户配置的端口，自动分配的端口在 load 时按 BasePort 和 PortStep 重新计算
 		metadata, err := encoder.Marshal(struct {
 			WorkDir  string
 			Procfile string
+			BasePort int
+			PortStep int
 		}{
 			WorkDir:  proj.WorkDir,
 			Procfile: proj.Procfile,
+			BasePort: proj.BasePort,
+			PortStep: proj.PortStep,
 		})
 		if err != nil {
 			return se.errorResponse(err)
@@ -39,10 +44,24 @@ func (se *SpmSession) doDump() (*codec.ResponseMsg, codec.ResponseCtl) {
 			opt.LogRoot = proc.opts.LogRoot
 			opt.StopSignal = proc.opts.StopSignal
 			opt.NumProcs = proc.opts.NumProcs
+			opt.Shell = proc.opts.Shell
+			opt.Port = proc.opts.Port
+			opt.DisableSpmEnv = proc.opts.DisableSpmEnv
+			opt.Listen = make([]string, len(proc.opts.Listen))
+			_ = copy(opt.Listen, proc.opts.Listen)
+			opt.Type = proc.opts.Type
+			opt.WatchdogSec = proc.opts.WatchdogSec
+			opt.LogBuffer = proc.opts.LogBuffer
+			opt.LogRotate = proc.opts.LogRotate
+			opt.LogFormat = proc.opts.LogFormat
+			opt.MergeOutput = proc.opts.MergeOutput
+			opt.LogSinks = proc.opts.LogSinks
+			opt.LogTriggers = proc.opts.LogTriggers
 			opt.Env = make([]string, len(proc.opts.Env))
 			_ = copy(opt.Env, proc.opts.Env)
 			opt.Cmd = make([]string, len(proc.opts.Cmd))
 			_ = copy(opt.Cmd, proc.opts.Cmd)
+			opt.CmdLine = proc.opts.CmdLine
 			opt.Order = proc.opts.Order
 
 			data, err := encoder.Marshal(opt)
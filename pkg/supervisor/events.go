@@ -0,0 +1,157 @@
+// 进程生命周期事件的发布和订阅
+//
+// 进程的每次状态变化都作为 codec.Event 发布到 pkg/pubsub 上，每个事件发布到三个主题：
+//   - "*"：所有事件
+//   - "project:<项目名>"：一个项目的事件
+//   - "process:<项目名>::<进程名>"：一个进程的事件
+//
+// 订阅者只订阅其中一个主题，不会重复收到同一个事件。发布时使用 TryPub，
+// 订阅者来不及接收的事件被丢弃，不会阻塞 supervisor。
+
+package supervisor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"spm/pkg/codec"
+	"spm/pkg/pubsub"
+)
+
+const (
+	eventQueue         = 256                    // 每个订阅者的事件队列长度
+	eventFlushInterval = 100 * time.Millisecond // 合并发送事件的时间间隔
+	topicAll           = "*"
+)
+
+const (
+	crashLoopThreshold = 3               // 在 crashLoopWindow 内崩溃这么多次就是 crash loop
+	crashLoopWindow    = 5 * time.Minute // 统计崩溃次数的时间窗口
+)
+
+var eventBus = pubsub.New[string, *codec.Event](eventQueue)
+
+func projectTopic(project string) string {
+	return "project:" + project
+}
+
+func processTopic(fullName string) string {
+	return "process:" + fullName
+}
+
+// publish 补全事件的进程信息并发布，前台模式下同时输出到终端
+func (p *Process) publish(ev *codec.Event) {
+	ev.Time = time.Now()
+	ev.Project, _, _ = strings.Cut(p.FullName, "::")
+	ev.Process = p.Name
+	ev.Instance = p.Instance
+	if ev.Pid == 0 {
+		ev.Pid = int(p.runPid.Load())
+	}
+
+	eventBus.TryPub(ev, topicAll, projectTopic(ev.Project), processTopic(p.FullName))
+
+	p.event("%s", ev.Describe())
+}
+
+// publishType 发布一个只有类型的事件
+func (p *Process) publishType(typ codec.EventType) {
+	p.publish(&codec.Event{Type: typ})
+}
+
+// recordCrash 发布进程崩溃事件，短时间内多次崩溃时再发布 crashloop 事件，调用方需要持有 p.mu
+func (p *Process) recordCrash(info *codec.ExitInfo) {
+	p.publish(&codec.Event{
+		Type:     codec.EventCrashed,
+		Pid:      info.Pid,
+		ExitCode: info.ExitCode,
+		Signal:   info.Signal,
+	})
+
+	now := time.Now()
+	recent := p.crashes[:0]
+	for _, t := range p.crashes {
+		if now.Sub(t) < crashLoopWindow {
+			recent = append(recent, t)
+		}
+	}
+	p.crashes = append(recent, now)
+
+	if len(p.crashes) >= crashLoopThreshold {
+		p.publish(&codec.Event{
+			Type:    codec.EventCrashLoop,
+			Pid:     info.Pid,
+			Message: fmt.Sprintf("%d crashes in %s", len(p.crashes), crashLoopWindow),
+		})
+	}
+}
+
+// eventTopic 返回订阅请求对应的主题
+func eventTopic(msg *codec.ActionMsg) string {
+	if msg.Projects != "" {
+		return projectTopic(msg.Projects)
+	}
+
+	return topicAll
+}
+
+// doEvents 处理 ActionEvents 请求，订阅事件并以流式响应发送，直到客户端取消请求
+func (se *SpmSession) doEvents(msg *codec.ActionMsg) codec.ResponseCtl {
+	ch := eventBus.Sub(eventTopic(msg))
+	defer func() {
+		// Unsub 关闭 ch 之前要一直接收，避免 pubsub 阻塞
+		go eventBus.Unsub(ch)
+		for range ch {
+		}
+	}()
+
+	// 先发送一条空响应，客户端收到后就知道订阅已经生效
+	if se.sendEvents(nil, false) != nil {
+		return codec.ResponseNormal
+	}
+
+	ticker := time.NewTicker(eventFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*codec.Event, 0)
+	for {
+		select {
+		case <-se.ctx.Done():
+			_ = se.sendEvents(batch, true)
+			return codec.ResponseNormal
+		case ev := <-ch:
+			// 订阅所有项目时只发送对端有权限的项目的事件
+			if se.access.allows(codec.ActionEvents, ev.Project) {
+				batch = append(batch, ev)
+			}
+			continue
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		if se.sendEvents(batch, false) != nil {
+			return codec.ResponseNormal
+		}
+		batch = make([]*codec.Event, 0)
+	}
+}
+
+// sendEvents 发送一批事件，final 为 true 时是最后一条响应
+func (se *SpmSession) sendEvents(events []*codec.Event, final bool) error {
+	res := &codec.ResponseMsg{
+		Code:    200,
+		Message: codec.ActionResponse[codec.ActionEvents],
+		Events:  events,
+		More:    !final,
+	}
+
+	if se.sendResponse(res, codec.ResponseNormal) == codec.ResponseMsgErr {
+		return errors.New("send events failed")
+	}
+
+	return nil
+}
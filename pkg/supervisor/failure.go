@@ -0,0 +1,61 @@
+// 前台模式下进程失败时退出的功能
+//
+// spm start -f --exit-on-failure 的行为与 foreman start 一致：
+// 任何一个进程意外退出或者启动失败时，按启动顺序的逆序停止其他进程，
+// spm 以该进程的退出码退出，被信号杀死时退出码为 128 + 信号编号。
+
+package supervisor
+
+import (
+	"spm/pkg/config"
+)
+
+// processFailure 是一次导致前台 supervisor 退出的进程失败
+type processFailure struct {
+	process  *Process
+	exitCode int
+}
+
+// processFailures 只保留第一次失败，之后的失败都是停止其他进程引起的
+var processFailures = make(chan *processFailure, 1)
+
+// exitOnFailure 判断进程失败时是否需要退出 supervisor
+func exitOnFailure() bool {
+	return config.ForegroundFlag && config.ExitOnFailureFlag
+}
+
+// reportFailure 报告进程失败，不会阻塞
+func (p *Process) reportFailure(exitCode int) {
+	if !exitOnFailure() {
+		return
+	}
+
+	select {
+	case processFailures <- &processFailure{process: p, exitCode: exitCode}:
+	default:
+	}
+}
+
+// stopReverse 按启动顺序的逆序停止所有运行中的进程
+func (sv *Supervisor) stopReverse() {
+	names := sv.procList.All()
+
+	for i := len(names) - 1; i >= 0; i-- {
+		p, ok := sv.findProc(names[i])
+		if !ok || !p.IsRunning() {
+			continue
+		}
+
+		sv.Stop(p)
+	}
+}
+
+// handleFailure 记录失败进程的退出码，并停止其他进程
+func (sv *Supervisor) handleFailure(f *processFailure) {
+	sv.ExitCode = f.exitCode
+
+	sv.logger.Warnf("Process %s failed with exit code %d. Stopping all processes", f.process.FullName, f.exitCode)
+	f.process.event("failed, stopping all processes")
+
+	sv.stopReverse()
+}
@@ -0,0 +1,983 @@
+// daemon 的 HTTP API
+//
+// HTTP API 是控制 socket 之外的另一种访问方式，请求转换为 codec.ActionMsg 之后同样交给
+// dispatch 处理。监听地址只能是 unix socket 或者本机的 TCP 地址，所有路径都在 /api/v1 下：
+//
+//	GET  /processes                                         所有进程的状态
+//	GET  /projects                                          所有项目和进程的状态
+//	GET  /projects/{project}                                一个项目和进程的状态
+//	POST /projects/{project}/{action}                       start、stop、restart、signal 或 reload
+//	GET  /projects/{project}/processes                      一个项目所有进程的状态
+//	GET  /projects/{project}/processes/{process}            一个进程的状态
+//	POST /projects/{project}/processes/{process}/{action}   start、stop、restart 或 signal
+//	GET  /projects/{project}[/processes/{process}]/logs     日志，SSE 流
+//	GET  /projects/{project}[/processes/{process}]/logs/search  搜索日志，SSE 流
+//	GET  /events                                            生命周期事件，SSE 流
+//	POST /run、/dump、/load、/shutdown
+//
+// 根路径和 /ui/ 下是内嵌的 web 界面，见 webui.go。
+//
+// POST 请求的 Content-Type 必须是 application/json。TCP 地址上的请求还要通过 checkRequest 的
+// 检查：Host 必须是监听地址，不接受其他网站的页面发起的请求，/api/v1 下的所有请求都要在 X-Spm-Token
+// 请求头中带上 daemon 启动时生成的令牌，令牌保存在只有 daemon 的用户能读取的文件中。
+// 持有令牌的请求以 daemon 的用户作为身份，与 unix socket 上的请求一样经过 acl 检查和审计。
+//
+// 动作返回 codec.ResponseMsg 的 JSON，资源直接返回 JSON 对象或数组。出错时返回
+// {"code": 状态码, "message": 错误信息}，HTTP 状态码与 code 相同。
+//
+// SSE 流中每行日志是一个 log 事件，每个生命周期事件是一个 event 事件，data 都是 JSON；
+// 流结束时发送 end 事件，data 是最后一条响应的 code 和 message。
+
+package supervisor
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"spm/pkg/codec"
+	"spm/pkg/config"
+	"spm/pkg/logger"
+	"spm/pkg/utils/constants"
+
+	"go.uber.org/zap"
+)
+
+const httpAPIPrefix = "/api/v1"
+
+// httpTokenHeader 是 TCP 地址上的请求携带 daemon 令牌的请求头
+const httpTokenHeader = "X-Spm-Token"
+
+// httpTokenParam 是 GET 请求携带令牌的查询参数，浏览器的 EventSource 无法设置请求头
+const httpTokenParam = "token"
+
+// errNotFound 表示请求的项目或进程不存在
+var errNotFound = errors.New("not found")
+
+// httpActions 是 POST 请求路径中的动作
+var httpActions = map[string]codec.ActionCtl{
+	"start":   codec.ActionStart,
+	"stop":    codec.ActionStop,
+	"restart": codec.ActionRestart,
+	"signal":  codec.ActionSignal,
+	"reload":  codec.ActionReload,
+}
+
+// projectInfo 是 HTTP API 返回的项目资源
+type projectInfo struct {
+	Name      string            `json:"name"`
+	WorkDir   string            `json:"work_dir"`
+	Procfile  string            `json:"procfile"`
+	Processes []*codec.ProcInfo `json:"processes"`
+}
+
+// httpAPI 处理 HTTP API 的请求
+type httpAPI struct {
+	sv     *Supervisor
+	mux    *http.ServeMux
+	logger *zap.SugaredLogger
+
+	token     string    // TCP 地址上的 API 请求需要携带的令牌
+	tokenPeer *peerCred // 持有令牌的请求的身份，只有 daemon 的用户能读取令牌文件
+	hosts     []string  // TCP 地址上允许的 Host 请求头
+}
+
+// listenHTTP 按配置的地址监听
+//
+// 参数：
+//
+//	listen: unix:<路径> 或者 host:port
+//	loopback: 为 true 时 TCP 地址只允许本机地址
+//
+// 返回：
+//
+//	net.Listener: 监听器
+//	error: 地址无效或者监听失败时返回错误
+func listenHTTP(listen string, loopback bool) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(listen, "unix:"); ok {
+		// daemon 只有一个实例，残留的 socket 文件可以直接删除
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(path)
+		}
+
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := setSocketPerms(path); err != nil {
+			_ = ln.Close()
+			return nil, err
+		}
+
+		return ln, nil
+	}
+
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return nil, err
+	}
+
+	if loopback && host != "localhost" {
+		ip := net.ParseIP(host)
+		if ip == nil || !ip.IsLoopback() {
+			return nil, fmt.Errorf("http listen address %s is not a loopback address", listen)
+		}
+	}
+
+	return net.Listen("tcp", listen)
+}
+
+// peerKey 是请求 ctx 中保存连接对端身份的键
+type peerKey struct{}
+
+// tcpConnKey 是请求 ctx 中保存连接是否为 TCP 连接的键
+type tcpConnKey struct{}
+
+// serveHTTP 在后台处理监听器上的 HTTP 请求，监听器关闭后结束
+func (sv *Supervisor) serveHTTP(ln net.Listener, handler http.Handler) {
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		// unix socket 上的连接读取对端身份，用于访问控制
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			peer, err := readPeerCred(c)
+			if err != nil {
+				sv.logger.Warnf("Cannot read peer credentials: %v", err)
+			}
+			ctx = context.WithValue(ctx, tcpConnKey{}, c.LocalAddr().Network() == "tcp")
+			return context.WithValue(ctx, peerKey{}, peer)
+		},
+	}
+
+	go func() {
+		if err := server.Serve(ln); err != nil && !errors.Is(err, net.ErrClosed) && !errors.Is(err, http.ErrServerClosed) {
+			sv.logger.Error(err)
+		}
+	}()
+}
+
+// startHTTP 在配置开启时启动 HTTP API，返回的监听器在 daemon 退出时关闭
+func (sv *Supervisor) startHTTP() net.Listener {
+	cfg := config.GetConfig()
+	if !cfg.HTTP.Enabled {
+		return nil
+	}
+
+	ln, err := listenHTTP(cfg.HTTP.Listen, true)
+	if err != nil {
+		sv.logger.Errorf("Cannot start HTTP API: %v", err)
+		return nil
+	}
+
+	api := newHTTPAPI(sv)
+	if err := api.setupTCP(cfg.HTTP.Listen); err != nil {
+		_ = ln.Close()
+		sv.logger.Errorf("Cannot start HTTP API: %v", err)
+		return nil
+	}
+	if cfg.Metrics.Enabled && cfg.Metrics.Listen == "" {
+		api.mux.HandleFunc("GET "+metricsPath(), sv.serveMetrics)
+	}
+
+	sv.serveHTTP(ln, api)
+	sv.logger.Infof("HTTP API is listening on %s", cfg.HTTP.Listen)
+
+	return ln
+}
+
+func newHTTPAPI(sv *Supervisor) *httpAPI {
+	api := &httpAPI{
+		sv:     sv,
+		mux:    http.NewServeMux(),
+		logger: logger.Logging("spm-http"),
+	}
+
+	api.handle("GET /processes", api.listProcesses)
+	api.handle("GET /projects", api.listProjects)
+	api.handle("GET /projects/{project}", api.getProject)
+	api.handle("POST /projects/{project}/{action}", api.doAction)
+	api.handle("GET /projects/{project}/processes", api.listProjectProcesses)
+	api.handle("GET /projects/{project}/processes/{process}", api.getProcess)
+	api.handle("POST /projects/{project}/processes/{process}/{action}", api.doAction)
+	api.handle("GET /projects/{project}/logs", api.streamLogs)
+	api.handle("GET /projects/{project}/processes/{process}/logs", api.streamLogs)
+	api.handle("GET /projects/{project}/logs/search", api.searchLogs)
+	api.handle("GET /projects/{project}/processes/{process}/logs/search", api.searchLogs)
+	api.handle("GET /events", api.streamEvents)
+	api.handle("GET /audit", api.queryAudit)
+	api.handle("POST /run", api.run)
+	api.handle("POST /dump", api.simple(codec.ActionDump))
+	api.handle("POST /load", api.simple(codec.ActionLoad))
+	api.handle("POST /shutdown", api.shutdown)
+	api.handleWebUI()
+
+	return api
+}
+
+// setupTCP 为 TCP 监听地址生成令牌并写入令牌文件，计算允许的 Host 请求头，unix socket 不需要
+func (api *httpAPI) setupTCP(listen string) error {
+	if strings.HasPrefix(listen, "unix:") {
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return err
+	}
+
+	api.hosts = []string{strings.ToLower(listen)}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		api.hosts = append(api.hosts,
+			net.JoinHostPort("localhost", port),
+			net.JoinHostPort("127.0.0.1", port),
+			net.JoinHostPort("::1", port),
+		)
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	api.token = hex.EncodeToString(buf)
+	api.tokenPeer = newPeerCred(os.Geteuid(), os.Getegid(), -1)
+
+	// 先删除旧文件，保证新文件的权限是 0600
+	_ = os.Remove(constants.DaemonHTTPTokenFilePath)
+	return os.WriteFile(constants.DaemonHTTPTokenFilePath, []byte(api.token+"\n"), 0600)
+}
+
+func (api *httpAPI) handle(pattern string, fn http.HandlerFunc) {
+	method, path, _ := strings.Cut(pattern, " ")
+	api.mux.HandleFunc(method+" "+httpAPIPrefix+path, fn)
+}
+
+// ServeHTTP 分发请求，没有匹配的路由时也以 JSON 返回错误
+func (api *httpAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	peer, status, err := api.checkRequest(r)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+	if peer != nil {
+		r = r.WithContext(context.WithValue(r.Context(), peerKey{}, peer))
+	}
+
+	if _, pattern := api.mux.Handler(r); pattern == "" {
+		// ServeMux 自己生成 404 或者 405 响应，这里只取它的状态码和 Allow 头
+		rec := &statusRecorder{header: w.Header()}
+		api.mux.ServeHTTP(rec, r)
+		writeError(w, rec.status, http.StatusText(rec.status))
+		return
+	}
+
+	api.mux.ServeHTTP(w, r)
+}
+
+// checkRequest 拒绝可能由浏览器中其他网站的页面发起的请求
+//
+// TCP 连接无法识别对端身份，浏览器中任何网页都能向本机地址发送不需要预检的请求，所以 TCP 连接上：
+//   - Host 必须是监听地址，防止 DNS 重绑定
+//   - 带有 Origin 或者 Sec-Fetch-Site 请求头的请求必须来自同源的页面
+//   - /api/v1 下的请求必须带上 daemon 的令牌，没有令牌文件读取权限的本机用户不能查看日志、事件和审计日志，
+//     也不能修改进程。web 界面的静态文件不需要令牌
+//
+// 所有连接上的 POST 请求都要求 Content-Type 是 application/json，浏览器跨站发送这种请求之前必须预检。
+// unix socket 浏览器无法访问，对端身份由 socket 的权限和 acl 规则控制。
+//
+// 返回：
+//
+//	*peerCred: TCP 连接上持有令牌的请求的身份，其他请求为 nil
+//	int: 拒绝请求时的 HTTP 状态码
+//	error: 拒绝请求的原因
+func (api *httpAPI) checkRequest(r *http.Request) (*peerCred, int, error) {
+	if r.Method == http.MethodPost {
+		mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mt != "application/json" {
+			return nil, http.StatusUnsupportedMediaType, errors.New("content type must be application/json")
+		}
+	}
+
+	if tcp, _ := r.Context().Value(tcpConnKey{}).(bool); !tcp {
+		return nil, 0, nil
+	}
+
+	if !slices.Contains(api.hosts, strings.ToLower(r.Host)) {
+		return nil, http.StatusMisdirectedRequest, fmt.Errorf("invalid host %q", r.Host)
+	}
+
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+		return nil, http.StatusForbidden, errors.New("cross-site request is not allowed")
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && !strings.EqualFold(origin, "http://"+r.Host) {
+		return nil, http.StatusForbidden, errors.New("cross-origin request is not allowed")
+	}
+
+	if !strings.HasPrefix(r.URL.Path, httpAPIPrefix+"/") {
+		return nil, 0, nil
+	}
+
+	token := r.Header.Get(httpTokenHeader)
+	if token == "" && r.Method == http.MethodGet {
+		token = r.URL.Query().Get(httpTokenParam)
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(api.token)) != 1 {
+		return nil, http.StatusUnauthorized, fmt.Errorf("missing or invalid %s header, the token is in %s", httpTokenHeader, constants.DaemonHTTPTokenFilePath)
+	}
+
+	return api.tokenPeer, 0, nil
+}
+
+// statusRecorder 只记录状态码，丢弃响应内容
+type statusRecorder struct {
+	header http.Header
+	status int
+}
+
+func (rec *statusRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+}
+
+// writeJSON 以指定的状态码返回 JSON
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// apiError 是 HTTP API 返回的错误
+type apiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError 以 JSON 返回错误
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, &apiError{
+		Code:    status,
+		Message: message,
+	})
+}
+
+// writeResponse 以 JSON 返回响应消息，code 表示错误时只返回 code 和 message
+func writeResponse(w http.ResponseWriter, res *codec.ResponseMsg) {
+	status := httpStatus(res)
+	if status >= 400 {
+		writeError(w, status, res.Message)
+		return
+	}
+
+	writeJSON(w, status, res)
+}
+
+// httpStatus 把响应消息的 code 转换为 HTTP 状态码
+func httpStatus(res *codec.ResponseMsg) int {
+	if res.Code < 100 || res.Code > 599 {
+		return http.StatusOK
+	}
+
+	return res.Code
+}
+
+// session 创建处理一个 HTTP 请求的会话，请求结束或者客户端断开时 ctx 被取消
+func (api *httpAPI) session(ctx context.Context, out responder) *SpmSession {
+	peer, _ := ctx.Value(peerKey{}).(*peerCred)
+
+	return &SpmSession{
+		sv:     api.sv,
+		out:    out,
+		ctx:    ctx,
+		peer:   peer,
+		access: newAccess(peer),
+		logger: api.logger,
+	}
+}
+
+// access 返回请求对端的访问权限
+func (api *httpAPI) access(r *http.Request) *access {
+	peer, _ := r.Context().Value(peerKey{}).(*peerCred)
+	return newAccess(peer)
+}
+
+// call 通过 dispatch 处理一个请求，返回合并之后的响应
+func (api *httpAPI) call(r *http.Request, msg *codec.ActionMsg) (*codec.ResponseMsg, codec.ResponseCtl) {
+	out := &httpResponder{}
+	result := api.session(r.Context(), out).dispatch(msg)
+
+	if out.res == nil {
+		return &codec.ResponseMsg{
+			Code:    http.StatusInternalServerError,
+			Message: "no response",
+		}, codec.ResponseMsgErr
+	}
+
+	return out.res, result
+}
+
+// reply 通过 dispatch 处理一个请求并以 JSON 返回响应
+func (api *httpAPI) reply(w http.ResponseWriter, r *http.Request, msg *codec.ActionMsg) {
+	res, _ := api.call(r, msg)
+	writeResponse(w, res)
+}
+
+// stream 通过 dispatch 处理一个流式请求，响应以 SSE 发送
+func (api *httpAPI) stream(w http.ResponseWriter, r *http.Request, msg *codec.ActionMsg) {
+	out := &httpResponder{
+		w:      w,
+		rc:     http.NewResponseController(w),
+		stream: true,
+	}
+	_ = api.session(r.Context(), out).dispatch(msg)
+
+	// 开始发送 SSE 之前就出错的请求以 JSON 返回错误
+	if !out.started && out.res != nil {
+		writeResponse(w, out.res)
+	}
+}
+
+// targets 返回请求路径中的进程，格式与 ActionMsg.Processes 相同，路径中没有进程名时是项目的所有进程
+func (api *httpAPI) targets(r *http.Request) (string, error) {
+	project := r.PathValue("project")
+	process := r.PathValue("process")
+
+	proj := api.sv.projectTable.Get(project)
+	if proj == nil {
+		return "", fmt.Errorf("%w: project %s", errNotFound, project)
+	}
+
+	if process != "" {
+		if _, ok := proj.procTable.Get(process); !ok {
+			return "", fmt.Errorf("%w: process %s::%s", errNotFound, project, process)
+		}
+		return project + "::" + process, nil
+	}
+
+	names := proj.GetProcNames()
+	for i, n := range names {
+		names[i] = project + "::" + n
+	}
+
+	return strings.Join(names, ";"), nil
+}
+
+// status 查询进程的状态，procs 为空时返回空列表
+func (api *httpAPI) status(r *http.Request, procs string) (*codec.ResponseMsg, bool) {
+	if procs == "" {
+		return &codec.ResponseMsg{
+			Code:      http.StatusOK,
+			Message:   codec.ActionResponse[codec.ActionStatus],
+			Processes: make([]*codec.ProcInfo, 0),
+		}, true
+	}
+
+	res, _ := api.call(r, &codec.ActionMsg{
+		Action:    codec.ActionStatus,
+		Processes: procs,
+	})
+	if res.Processes == nil {
+		res.Processes = make([]*codec.ProcInfo, 0)
+	}
+
+	return res, res.Code < 300
+}
+
+// allTargets 返回请求对端有权限查看的所有项目的所有进程，项目按名称排序
+func (api *httpAPI) allTargets(r *http.Request) ([]*Project, string) {
+	acc := api.access(r)
+
+	projects := make([]*Project, 0)
+	for _, proj := range api.sv.projectTable.Iter() {
+		if acc.allows(codec.ActionStatus, proj.Name) {
+			projects = append(projects, proj)
+		}
+	}
+	slices.SortFunc(projects, func(a, b *Project) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	names := make([]string, 0)
+	for _, proj := range projects {
+		for _, n := range proj.GetProcNames() {
+			names = append(names, proj.Name+"::"+n)
+		}
+	}
+
+	return projects, strings.Join(names, ";")
+}
+
+func (api *httpAPI) listProcesses(w http.ResponseWriter, r *http.Request) {
+	_, procs := api.allTargets(r)
+
+	res, ok := api.status(r, procs)
+	if !ok {
+		writeResponse(w, res)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res.Processes)
+}
+
+func (api *httpAPI) listProjects(w http.ResponseWriter, r *http.Request) {
+	projects, procs := api.allTargets(r)
+
+	res, ok := api.status(r, procs)
+	if !ok {
+		writeResponse(w, res)
+		return
+	}
+
+	infos := make([]*projectInfo, 0, len(projects))
+	for _, proj := range projects {
+		info := &projectInfo{
+			Name:      proj.Name,
+			WorkDir:   proj.WorkDir,
+			Procfile:  proj.Procfile,
+			Processes: make([]*codec.ProcInfo, 0),
+		}
+		for _, p := range res.Processes {
+			if p.Project == proj.Name {
+				info.Processes = append(info.Processes, p)
+			}
+		}
+		infos = append(infos, info)
+	}
+
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (api *httpAPI) getProject(w http.ResponseWriter, r *http.Request) {
+	procs, err := api.targets(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	res, ok := api.status(r, procs)
+	if !ok {
+		writeResponse(w, res)
+		return
+	}
+
+	proj := api.sv.projectTable.Get(r.PathValue("project"))
+	writeJSON(w, http.StatusOK, &projectInfo{
+		Name:      proj.Name,
+		WorkDir:   proj.WorkDir,
+		Procfile:  proj.Procfile,
+		Processes: res.Processes,
+	})
+}
+
+func (api *httpAPI) listProjectProcesses(w http.ResponseWriter, r *http.Request) {
+	procs, err := api.targets(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	res, ok := api.status(r, procs)
+	if !ok {
+		writeResponse(w, res)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res.Processes)
+}
+
+func (api *httpAPI) getProcess(w http.ResponseWriter, r *http.Request) {
+	procs, err := api.targets(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	res, ok := api.status(r, procs)
+	if !ok {
+		writeResponse(w, res)
+		return
+	}
+
+	if len(res.Processes) == 0 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%v: process %s", errNotFound, procs))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res.Processes[0])
+}
+
+// doAction 处理项目或进程的 start、stop、restart、signal 和 reload
+func (api *httpAPI) doAction(w http.ResponseWriter, r *http.Request) {
+	action, ok := httpActions[r.PathValue("action")]
+	if !ok || (action == codec.ActionReload && r.PathValue("process") != "") {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown action %s", r.PathValue("action")))
+		return
+	}
+
+	procs, err := api.targets(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	msg := &codec.ActionMsg{
+		Action:    action,
+		Processes: procs,
+	}
+
+	switch action {
+	case codec.ActionReload:
+		msg.Projects = r.PathValue("project")
+		msg.Processes = ""
+	case codec.ActionSignal:
+		var body struct {
+			Signal string `json:"signal"`
+		}
+		body.Signal = r.URL.Query().Get("signal")
+		if body.Signal == "" {
+			if err := decodeBody(r, &body); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if body.Signal == "" {
+			writeError(w, http.StatusBadRequest, "missing signal")
+			return
+		}
+		msg.Signal = body.Signal
+	}
+
+	if msg.Processes == "" && msg.Action != codec.ActionReload {
+		writeJSON(w, http.StatusOK, &codec.ResponseMsg{
+			Code:      http.StatusOK,
+			Message:   codec.ActionResponse[action],
+			Processes: make([]*codec.ProcInfo, 0),
+		})
+		return
+	}
+
+	api.reply(w, r, msg)
+}
+
+// decodeBody 解码 JSON 请求内容，没有内容时不做任何事情
+func decodeBody(r *http.Request, v any) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+
+	return nil
+}
+
+// queryBool 读取布尔类型的查询参数，只有参数名没有值时为 true
+func queryBool(r *http.Request, key string) (bool, error) {
+	q := r.URL.Query()
+	if !q.Has(key) {
+		return false, nil
+	}
+
+	v := q.Get(key)
+	if v == "" {
+		return true, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %q", key, v)
+	}
+
+	return b, nil
+}
+
+// queryTime 读取时间类型的查询参数，可以是 RFC3339 时间或者 10m 这样的相对时间
+func queryTime(r *http.Request, key string) (time.Time, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(v); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %q", key, v)
+	}
+
+	return t, nil
+}
+
+// logParams 读取日志请求共用的查询参数
+func (api *httpAPI) logParams(r *http.Request, msg *codec.ActionMsg) error {
+	procs, err := api.targets(r)
+	if err != nil {
+		return err
+	}
+	msg.Processes = procs
+
+	msg.Stream = r.URL.Query().Get("stream")
+	if msg.Stream != "" && msg.Stream != streamStdout && msg.Stream != streamStderr {
+		return fmt.Errorf("invalid stream: %q", msg.Stream)
+	}
+
+	msg.Since, err = queryTime(r, "since")
+	return err
+}
+
+// paramError 按错误类型返回 404 或者 400
+func paramError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	writeError(w, http.StatusBadRequest, err.Error())
+}
+
+func (api *httpAPI) streamLogs(w http.ResponseWriter, r *http.Request) {
+	msg := &codec.ActionMsg{Action: codec.ActionLog}
+
+	err := api.logParams(r, msg)
+	if err == nil {
+		msg.Follow, err = queryBool(r, "follow")
+	}
+	if err == nil && r.URL.Query().Has("lines") {
+		msg.Lines, err = strconv.Atoi(r.URL.Query().Get("lines"))
+	}
+	if err != nil {
+		paramError(w, err)
+		return
+	}
+
+	api.stream(w, r, msg)
+}
+
+func (api *httpAPI) searchLogs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	msg := &codec.ActionMsg{
+		Action:  codec.ActionLogGrep,
+		Pattern: q.Get("pattern"),
+		Fields:  q["field"],
+	}
+
+	err := api.logParams(r, msg)
+	if err == nil {
+		msg.Until, err = queryTime(r, "until")
+	}
+	if err == nil {
+		msg.Fixed, err = queryBool(r, "fixed")
+	}
+	if err == nil {
+		msg.IgnoreCase, err = queryBool(r, "ignore_case")
+	}
+	if err == nil && q.Has("context") {
+		msg.Context, err = strconv.Atoi(q.Get("context"))
+	}
+	if err != nil {
+		paramError(w, err)
+		return
+	}
+
+	api.stream(w, r, msg)
+}
+
+func (api *httpAPI) streamEvents(w http.ResponseWriter, r *http.Request) {
+	project := r.URL.Query().Get("project")
+	if project != "" && api.sv.projectTable.Get(project) == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%v: project %s", errNotFound, project))
+		return
+	}
+
+	api.stream(w, r, &codec.ActionMsg{
+		Action:   codec.ActionEvents,
+		Projects: project,
+	})
+}
+
+// queryAudit 按查询参数 since、until、peer、action、target、failed 和 lines 查询审计日志
+func (api *httpAPI) queryAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	msg := &codec.ActionMsg{
+		Action:  codec.ActionAudit,
+		Peer:    q.Get("peer"),
+		Actions: q["action"],
+		Target:  q.Get("target"),
+	}
+
+	var err error
+	msg.Since, err = queryTime(r, "since")
+	if err == nil {
+		msg.Until, err = queryTime(r, "until")
+	}
+	if err == nil {
+		msg.Failed, err = queryBool(r, "failed")
+	}
+	if err == nil && q.Has("lines") {
+		msg.Lines, err = strconv.Atoi(q.Get("lines"))
+	}
+	if err != nil {
+		paramError(w, err)
+		return
+	}
+
+	res, _ := api.call(r, msg)
+	if res.Code != http.StatusOK {
+		writeResponse(w, res)
+		return
+	}
+
+	if res.Audits == nil {
+		res.Audits = make([]*codec.AuditEntry, 0)
+	}
+	writeJSON(w, http.StatusOK, res.Audits)
+}
+
+// run 处理 POST /run，请求内容是 {"work_dir": ..., "procfile": ..., "cmd": [...]}
+func (api *httpAPI) run(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		WorkDir  string   `json:"work_dir"`
+		Procfile string   `json:"procfile"`
+		Cmd      []string `json:"cmd"`
+	}
+
+	if err := decodeBody(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if body.WorkDir == "" || len(body.Cmd) == 0 {
+		writeError(w, http.StatusBadRequest, "work_dir and cmd are required")
+		return
+	}
+
+	api.reply(w, r, &codec.ActionMsg{
+		Action:   codec.ActionRun,
+		WorkDir:  body.WorkDir,
+		Procfile: body.Procfile,
+		CmdLine:  body.Cmd,
+	})
+}
+
+// simple 返回不需要参数的请求的处理函数
+func (api *httpAPI) simple(action codec.ActionCtl) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		api.reply(w, r, &codec.ActionMsg{Action: action})
+	}
+}
+
+// shutdown 停止所有进程并在返回响应之后退出 daemon，与 spm shutdown 的行为一致
+func (api *httpAPI) shutdown(w http.ResponseWriter, r *http.Request) {
+	res, result := api.call(r, &codec.ActionMsg{Action: codec.ActionShutdown})
+	writeResponse(w, res)
+
+	if result != codec.ResponseShutdown {
+		return
+	}
+
+	_ = http.NewResponseController(w).Flush()
+	api.sv.requestStop()
+}
+
+// httpResponder 接收 dispatch 发送的响应
+//
+// 普通请求的响应合并后保存在 res 中，由调用方以 JSON 返回；流式请求的响应以 SSE 发送，
+// 开始发送之前出错的响应同样保存在 res 中。
+type httpResponder struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	stream  bool
+	started bool // 已经开始发送 SSE
+	res     *codec.ResponseMsg
+}
+
+func (hr *httpResponder) WriteResponse(_ uint32, res *codec.ResponseMsg) error {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+
+	if !hr.stream || (!hr.started && res.Code >= 300) {
+		hr.merge(res)
+		return nil
+	}
+
+	if !hr.started {
+		h := hr.w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("X-Accel-Buffering", "no")
+		hr.w.WriteHeader(http.StatusOK)
+		hr.started = true
+	}
+
+	var err error
+	for _, line := range res.Logs {
+		err = errors.Join(err, hr.event("log", line))
+	}
+	for _, ev := range res.Events {
+		err = errors.Join(err, hr.event("event", ev))
+	}
+
+	if !res.More {
+		err = errors.Join(err, hr.event("end", &apiError{
+			Code:    res.Code,
+			Message: res.Message,
+		}))
+	} else if len(res.Logs) == 0 && len(res.Events) == 0 {
+		// 空的部分响应表示流已经开始，发送一条注释让客户端尽快收到响应头
+		_, err = io.WriteString(hr.w, ": ok\n\n")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return hr.rc.Flush()
+}
+
+// event 发送一个 SSE 事件
+func (hr *httpResponder) event(name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(hr.w, "event: %s\ndata: %s\n\n", name, data)
+	return err
+}
+
+// merge 把部分响应合并到之前收到的响应中
+func (hr *httpResponder) merge(res *codec.ResponseMsg) {
+	if hr.res == nil {
+		hr.res = res
+		return
+	}
+
+	hr.res.Code = res.Code
+	hr.res.Message = res.Message
+	hr.res.More = res.More
+	hr.res.Processes = append(hr.res.Processes, res.Processes...)
+	hr.res.Logs = append(hr.res.Logs, res.Logs...)
+	hr.res.Events = append(hr.res.Events, res.Events...)
+	hr.res.Audits = append(hr.res.Audits, res.Audits...)
+}
@@ -0,0 +1,242 @@
+// systemd 风格的套接字激活功能
+//
+// supervisor 预先绑定 ProcessOption.Listen 中配置的监听地址，
+// 通过 ExtraFiles 传给子进程，并设置 LISTEN_FDS/LISTEN_PID/LISTEN_FDNAMES。
+// 监听套接字在进程重启期间保持打开，客户端不会遇到连接被拒绝。
+
+package supervisor
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ActivateCommand 是 spm 内部用来设置 LISTEN_PID 和 WATCHDOG_PID 的隐藏子命令
+//
+// 这两个变量必须等于最终执行的程序的 PID，而 Go 无法在 fork 和 exec 之间
+// 修改环境变量，所以先启动 spm 自身，再由它通过 execve 替换成真正的命令。
+const ActivateCommand = "__activate"
+
+// listenFdsStart 是 sd_listen_fds 约定的第一个文件描述符
+const listenFdsStart = 3
+
+// parseListenAddr 解析 tcp://host:port、tcp4://、tcp6://、unix:///path 形式的地址
+func parseListenAddr(addr string) (string, string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid listen address %q: %w", addr, err)
+	}
+
+	switch u.Scheme {
+	case "tcp", "tcp4", "tcp6":
+		if u.Host == "" {
+			return "", "", fmt.Errorf("invalid listen address %q: missing host", addr)
+		}
+		return u.Scheme, u.Host, nil
+	case "unix":
+		path := u.Path
+		if path == "" {
+			path = u.Opaque
+		}
+		if path == "" {
+			return "", "", fmt.Errorf("invalid listen address %q: missing path", addr)
+		}
+		return u.Scheme, path, nil
+	default:
+		return "", "", fmt.Errorf("unsupported listen address %q", addr)
+	}
+}
+
+// openListeners 按顺序绑定所有监听地址，失败时关闭已经打开的套接字
+func openListeners(addrs []string) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(addrs))
+
+	for _, addr := range addrs {
+		network, address, err := parseListenAddr(addr)
+		if err == nil && network == "unix" {
+			// 清理上次异常退出遗留的套接字文件
+			if info, statErr := os.Stat(address); statErr == nil && info.Mode()&os.ModeSocket != 0 {
+				_ = os.Remove(address)
+			}
+		}
+
+		var ln net.Listener
+		if err == nil {
+			ln, err = net.Listen(network, address)
+		}
+
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+
+		listeners = append(listeners, ln)
+	}
+
+	return listeners, nil
+}
+
+func closeListeners(listeners []net.Listener) {
+	for _, ln := range listeners {
+		_ = ln.Close()
+	}
+}
+
+// listenerFiles 复制监听套接字的文件描述符，用于传给子进程
+func listenerFiles(listeners []net.Listener) ([]*os.File, error) {
+	files := make([]*os.File, 0, len(listeners))
+
+	for _, ln := range listeners {
+		var f *os.File
+		var err error
+
+		switch l := ln.(type) {
+		case *net.TCPListener:
+			f, err = l.File()
+		case *net.UnixListener:
+			f, err = l.File()
+		default:
+			err = fmt.Errorf("unsupported listener type %T", ln)
+		}
+
+		if err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+
+		files = append(files, f)
+	}
+
+	return files, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		_ = f.Close()
+	}
+}
+
+// activationEnv 返回套接字激活需要的环境变量，LISTEN_PID 由 ActivateCommand 设置
+func activationEnv(n int, name string) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = name
+	}
+
+	return []string{
+		"LISTEN_FDS=" + strconv.Itoa(n),
+		"LISTEN_FDNAMES=" + strings.Join(names, ":"),
+	}
+}
+
+// ExecActivated 是 ActivateCommand 的实现
+//
+// 功能：
+//  1. 把 LISTEN_PID 设置为当前进程的 PID
+//  2. 通过 execve 替换成 args 指定的命令，PID 和文件描述符保持不变
+//
+// 注意事项：
+//
+//	执行成功时不会返回
+func ExecActivated(args []string) error {
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		return errors.New("command is empty")
+	}
+
+	pid := strconv.Itoa(os.Getpid())
+	if os.Getenv("LISTEN_FDS") != "" {
+		if err := os.Setenv("LISTEN_PID", pid); err != nil {
+			return err
+		}
+	}
+
+	if os.Getenv("WATCHDOG_USEC") != "" {
+		if err := os.Setenv("WATCHDOG_PID", pid); err != nil {
+			return err
+		}
+	}
+
+	exe, err := exec.LookPath(args[0])
+	if err != nil {
+		return err
+	}
+
+	return syscall.Exec(exe, args, os.Environ())
+}
+
+// openListeners 在第一次启动进程时绑定监听地址，之后的重启复用同一组套接字
+func (p *Process) openListeners() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.opts.Listen) == 0 || p.listeners != nil {
+		return nil
+	}
+
+	listeners, err := openListeners(p.opts.Listen)
+	if err != nil {
+		return fmt.Errorf("cannot bind listen address: %w", err)
+	}
+
+	p.listeners = listeners
+	p.logger.Infof("Bound %d listen sockets for %s", len(listeners), p.Name)
+
+	return nil
+}
+
+// CloseListeners 关闭 supervisor 为进程持有的监听套接字
+//
+// 注意事项：
+//
+//	只在进程从进程表移除或 supervisor 关闭时调用，Stop 和 Restart 不会关闭套接字
+func (p *Process) CloseListeners() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	closeListeners(p.listeners)
+	p.listeners = nil
+}
+
+// activate 把监听套接字传给子进程，并在需要时改为通过 ActivateCommand 启动命令
+func (p *Process) activate(cmd *exec.Cmd) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	watchdog := p.notify != nil && p.notify.interval > 0
+
+	// 命令查找失败时交给 cmd.Start 报告错误
+	if (len(p.listeners) == 0 && !watchdog) || cmd.Err != nil {
+		return nil
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	if len(p.listeners) > 0 {
+		files, err := listenerFiles(p.listeners)
+		if err != nil {
+			return err
+		}
+
+		cmd.ExtraFiles = files
+		cmd.Env = append(cmd.Env, activationEnv(len(files), p.Name)...)
+	}
+
+	cmd.Args = append([]string{self, ActivateCommand, "--", cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = self
+
+	return nil
+}
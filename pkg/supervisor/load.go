@@ -1,6 +1,8 @@
 package supervisor
 
 import (
+	"cmp"
+
 	"spm/pkg/codec"
 	"spm/pkg/config"
 	"strings"
@@ -34,6 +36,8 @@ func (se *SpmSession) doLoad() (*codec.ResponseMsg, codec.ResponseCtl) {
 		metadata := struct {
 			WorkDir  string
 			Procfile string
+			BasePort int
+			PortStep int
 		}{}
 
 		if !strings.Contains(name, "::") {
@@ -52,6 +56,8 @@ func (se *SpmSession) doLoad() (*codec.ResponseMsg, codec.ResponseCtl) {
 			} else {
 				opt.WorkDir = metadata.WorkDir
 				opt.Procfile = metadata.Procfile
+				opt.BasePort = cmp.Or(metadata.BasePort, defaultBasePort)
+				opt.PortStep = cmp.Or(metadata.PortStep, defaultPortStep)
 				opt.Env = make([]string, 0)
 				opt.Processes = make(map[string]*ProcessOption)
 
@@ -75,6 +81,7 @@ func (se *SpmSession) doLoad() (*codec.ResponseMsg, codec.ResponseCtl) {
 					se.logger.Error(err)
 					se.errorResponse(err)
 				} else {
+					opt.assignPort(appOpt.BasePort + opt.Order*appOpt.PortStep)
 					appOpt.Processes[procName] = opt
 				}
 			}
@@ -0,0 +1,136 @@
+// 进程输出日志的格式化
+//
+// logFormat 支持三种格式：
+//   - raw：原样写入子进程的输出
+//   - timestamped：每行前面加上 RFC3339Nano 时间和输出流名称
+//   - json：每行一个 JSON 对象，包含时间、项目、进程、实例、输出流、PID 和内容
+
+package supervisor
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"spm/pkg/codec"
+)
+
+const (
+	logFormatRaw         = "raw"
+	logFormatTimestamped = "timestamped"
+	logFormatJSON        = "json"
+)
+
+// jsonLogRecord 是 json 格式日志中的一行
+type jsonLogRecord struct {
+	Time     time.Time `json:"time"`
+	Project  string    `json:"project"`
+	Process  string    `json:"process"`
+	Instance int       `json:"instance"`
+	Stream   string    `json:"stream"`
+	Pid      int       `json:"pid"`
+	Message  string    `json:"message"`
+}
+
+func isLogFormat(format string) bool {
+	switch format {
+	case logFormatRaw, logFormatTimestamped, logFormatJSON:
+		return true
+	}
+	return false
+}
+
+// formatLine 按 logFormat 把一行输出格式化为写入文件的内容，包含结尾的换行符
+//
+// raw 和 timestamped 格式写入子进程输出的原始字节，只有 json 格式使用替换过非法 UTF-8 的内容
+func (p *Process) formatLine(e logEntry) []byte {
+	line := e.line
+
+	switch p.opts.LogFormat {
+	case logFormatTimestamped:
+		prefix := line.Time.Format(time.RFC3339Nano) + " " + line.Stream + " "
+		buf := make([]byte, 0, len(prefix)+len(e.raw)+1)
+		buf = append(buf, prefix...)
+		buf = append(buf, e.raw...)
+		return append(buf, '\n')
+	case logFormatJSON:
+		return p.encodeJSON(line)
+	}
+
+	buf := make([]byte, 0, len(e.raw)+1)
+	buf = append(buf, e.raw...)
+	return append(buf, '\n')
+}
+
+// encodeJSON 把一行日志编码为一个 JSON 对象，以换行符结尾
+func (p *Process) encodeJSON(line *codec.LogLine) []byte {
+	data, _ := json.Marshal(&jsonLogRecord{
+		Time:     line.Time,
+		Project:  line.Project,
+		Process:  line.Process,
+		Instance: p.Instance,
+		Stream:   line.Stream,
+		Pid:      int(p.runPid.Load()),
+		Message:  line.Line,
+	})
+
+	return append(data, '\n')
+}
+
+// parseLine 把日志文件中的一行解析为 LogLine，无法解析时原样返回
+//
+// 参数：
+//
+//	row: 文件中的一行，不包含换行符
+//	t: 行内没有时间时使用的时间
+func (src *logSource) parseLine(row string, t time.Time) *codec.LogLine {
+	switch src.format {
+	case logFormatJSON:
+		var rec jsonLogRecord
+		if err := json.Unmarshal([]byte(row), &rec); err == nil && rec.Stream != "" {
+			line := src.newLogLine(rec.Time, rec.Message)
+			line.Stream = rec.Stream
+			return line
+		}
+	case logFormatTimestamped:
+		ts, rest, _ := strings.Cut(row, " ")
+		stream, msg, _ := strings.Cut(rest, " ")
+		if lt, err := time.Parse(time.RFC3339Nano, ts); err == nil && (stream == streamStdout || stream == streamStderr) {
+			line := src.newLogLine(lt, msg)
+			line.Stream = stream
+			return line
+		}
+	}
+
+	line := src.newLogLine(t, row)
+	if line.Stream == "" {
+		// 合并输出的 raw 日志无法区分输出流
+		line.Stream = streamStdout
+	}
+
+	return line
+}
+
+// sharedWriter 让 stdout 和 stderr 共用同一个日志写入器，全部关闭后才关闭文件
+type sharedWriter struct {
+	logWriter
+	mu   sync.Mutex
+	refs int
+}
+
+func shareWriter(w logWriter, refs int) *sharedWriter {
+	return &sharedWriter{logWriter: w, refs: refs}
+}
+
+func (w *sharedWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.refs--
+	if w.refs > 0 {
+		return nil
+	}
+
+	return w.logWriter.Close()
+}
@@ -0,0 +1,335 @@
+// 历史日志的搜索功能
+//
+// spm logs grep 在 daemon 中搜索进程当前的日志文件和所有轮转文件，
+// 包括 lumberjack 的 <name>-<时间>.log(.gz) 和 logrotate 的 <name>.log.N(.gz)，
+// 按文件从旧到新的顺序以流式响应返回匹配的行和上下文。
+
+package supervisor
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"spm/pkg/codec"
+)
+
+// grepCheckInterval 是搜索时检查客户端是否断开的行数间隔
+const grepCheckInterval = 4096
+
+// logMatcher 判断一行日志是否匹配搜索条件
+type logMatcher struct {
+	re     *regexp.Regexp
+	fields map[string]string
+	since  time.Time
+	until  time.Time
+}
+
+func newLogMatcher(msg *codec.ActionMsg) (*logMatcher, error) {
+	m := &logMatcher{
+		fields: make(map[string]string),
+		since:  msg.Since,
+		until:  msg.Until,
+	}
+
+	if msg.Pattern != "" {
+		pattern := msg.Pattern
+		if msg.Fixed {
+			pattern = regexp.QuoteMeta(pattern)
+		}
+		if msg.IgnoreCase {
+			pattern = "(?i)" + pattern
+		}
+
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
+		}
+		m.re = re
+	}
+
+	for _, f := range msg.Fields {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid field filter %q, expected key=value", f)
+		}
+		m.fields[k] = v
+	}
+
+	return m, nil
+}
+
+// inRange 判断带时间的日志行是否在 since 和 until 之间，没有时间的行总是满足
+//
+// raw 格式的文件中所有行都没有时间，doLogGrep 会拒绝对这样的文件按时间过滤
+func (m *logMatcher) inRange(line *codec.LogLine) bool {
+	if line.Time.IsZero() {
+		return true
+	}
+	if !m.since.IsZero() && line.Time.Before(m.since) {
+		return false
+	}
+	if !m.until.IsZero() && line.Time.After(m.until) {
+		return false
+	}
+	return true
+}
+
+// match 判断一行日志是否匹配，字段过滤只对 JSON 格式的行生效
+func (m *logMatcher) match(row string, line *codec.LogLine) bool {
+	if m.re != nil && !m.re.MatchString(line.Line) {
+		return false
+	}
+
+	if len(m.fields) == 0 {
+		return true
+	}
+
+	var obj map[string]any
+	if err := json.Unmarshal([]byte(row), &obj); err != nil {
+		return false
+	}
+
+	// 进程自己输出的 JSON 日志被包在 message 字段里，记录中没有的字段到 message 中查找
+	var inner map[string]any
+	if msg, ok := obj["message"].(string); ok {
+		_ = json.Unmarshal([]byte(msg), &inner)
+	}
+
+	for k, v := range m.fields {
+		value, ok := obj[k]
+		if !ok {
+			value, ok = inner[k]
+		}
+		if !ok || fmt.Sprint(value) != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// rotatedFiles 返回日志文件和它的轮转文件，按修改时间从旧到新排列，当前文件在最后
+func rotatedFiles(path string) []string {
+	base := strings.TrimSuffix(path, ".log")
+	patterns := []string{base + "-*.log", base + "-*.log.gz", path + ".*"}
+
+	type logFile struct {
+		path  string
+		mtime time.Time
+	}
+
+	seen := make(map[string]bool)
+	files := make([]logFile, 0)
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, m := range matches {
+			info, err := os.Stat(m)
+			if seen[m] || err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			seen[m] = true
+			files = append(files, logFile{m, info.ModTime()})
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].mtime.Before(files[j].mtime)
+	})
+
+	paths := make([]string, 0, len(files)+1)
+	for _, f := range files {
+		paths = append(paths, f.path)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		paths = append(paths, path)
+	}
+
+	return paths
+}
+
+// openLogFile 打开日志文件，gzip 压缩的文件自动解压
+func openLogFile(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, f}, nil
+}
+
+// grep 在一个日志文件中搜索，匹配的行和上下文通过 emit 输出
+//
+// 参数：
+//
+//	ctx: 客户端断开时取消
+//	path: 日志文件路径
+//	m: 搜索条件
+//	around: 匹配行前后输出的行数
+//	emit: 输出一行结果，返回错误时停止搜索
+func (src *logSource) grep(ctx context.Context, path string, m *logMatcher, around int, emit func(*codec.LogLine) error) error {
+	// 文件在 since 之后没有写入过，里面不会有需要的日志
+	if info, err := os.Stat(path); err != nil {
+		return err
+	} else if !m.since.IsZero() && info.ModTime().Before(m.since) {
+		return nil
+	}
+
+	r, err := openLogFile(path)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = r.Close()
+	}()
+
+	br := bufio.NewReaderSize(r, logReadChunk)
+	name := filepath.Base(path)
+
+	before := make([]*codec.LogLine, 0, around)
+	after := 0
+
+	for lineNo := 1; ; lineNo++ {
+		if lineNo%grepCheckInterval == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		row, readErr := br.ReadString('\n')
+		if row == "" && readErr != nil {
+			break
+		}
+		row = strings.TrimSuffix(row, "\n")
+
+		line := src.parseLine(row, time.Time{})
+		line.File = name
+		line.LineNo = lineNo
+
+		if !src.accept(line) {
+			continue
+		}
+
+		switch {
+		case m.inRange(line) && m.match(row, line):
+			for _, b := range before {
+				if err := emit(b); err != nil {
+					return err
+				}
+			}
+			before = before[:0]
+
+			if err := emit(line); err != nil {
+				return err
+			}
+			after = around
+		case after > 0:
+			line.Context = true
+			if err := emit(line); err != nil {
+				return err
+			}
+			after--
+		case around > 0:
+			line.Context = true
+			before = append(before, line)
+			if len(before) > around {
+				before = before[1:]
+			}
+		}
+
+		if readErr != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// doLogGrep 处理 ActionLogGrep 请求，以流式响应的方式发送搜索结果
+func (se *SpmSession) doLogGrep(msg *codec.ActionMsg) codec.ResponseCtl {
+	m, err := newLogMatcher(msg)
+	if err != nil {
+		return se.sendResponse(&codec.ResponseMsg{
+			Code:    400,
+			Message: err.Error(),
+		}, codec.ResponseMsgErr)
+	}
+
+	sources, err := se.logSources(msg)
+	if err != nil {
+		se.logger.Error(err)
+		return se.sendResponse(&codec.ResponseMsg{
+			Code:    404,
+			Message: err.Error(),
+		}, codec.ResponseMsgErr)
+	}
+
+	// raw 格式的日志行没有时间，无法按时间范围过滤
+	if !m.since.IsZero() || !m.until.IsZero() {
+		for _, src := range sources {
+			if cmp.Or(src.format, logFormatRaw) == logFormatRaw {
+				return se.sendResponse(&codec.ResponseMsg{
+					Code:    400,
+					Message: fmt.Sprintf("%s::%s writes raw logs without timestamps, --since and --until are not supported", src.project, src.process),
+				}, codec.ResponseMsgErr)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(se.ctx)
+	defer cancel()
+
+	batch := make([]*codec.LogLine, 0, logBatchSize)
+	emit := func(line *codec.LogLine) error {
+		batch = append(batch, line)
+		if len(batch) < logBatchSize {
+			return nil
+		}
+
+		err := se.sendLogs(batch, false)
+		if err != nil {
+			cancel()
+		}
+		batch = make([]*codec.LogLine, 0, logBatchSize)
+		return err
+	}
+
+	for _, src := range sources {
+		for _, path := range rotatedFiles(src.path) {
+			if err := src.grep(ctx, path, m, msg.Context, emit); err != nil {
+				if ctx.Err() != nil {
+					_ = se.sendLogs(nil, true)
+					return codec.ResponseNormal
+				}
+				se.logger.Warnf("search %s failed: %v", path, err)
+			}
+		}
+	}
+
+	_ = se.sendLogs(batch, true)
+
+	return codec.ResponseNormal
+}
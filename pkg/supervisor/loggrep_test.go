@@ -0,0 +1,263 @@
+package supervisor
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"spm/pkg/codec"
+)
+
+// writeLogFile 写入日志文件并设置修改时间，.gz 结尾的文件用 gzip 压缩
+func writeLogFile(t *testing.T, path string, lines []string, mtime time.Time) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := strings.Join(lines, "\n") + "\n"
+	if strings.HasSuffix(path, ".gz") {
+		gz := gzip.NewWriter(f)
+		_, err = gz.Write([]byte(content))
+		if err == nil {
+			err = gz.Close()
+		}
+	} else {
+		_, err = f.WriteString(content)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRotatedFiles(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	path := filepath.Join(dir, "web_output.log")
+
+	// 文件名中的时间和修改时间的顺序不同，结果按修改时间排列
+	files := []struct {
+		name string
+		age  time.Duration
+	}{
+		{"web_output-2026-01-02T00-00-00.000.log.gz", 5 * time.Hour},
+		{"web_output-2026-01-01T00-00-00.000.log", 4 * time.Hour},
+		{"web_output.log.2.gz", 3 * time.Hour},
+		{"web_output.log.1", 2 * time.Hour},
+		{"web_output-2026-01-03T00-00-00.000.log.gz", time.Hour},
+		{"web_output.log", 10 * time.Hour}, // 当前文件总是在最后
+		{"web_error.log", 0},
+		{"web_output2.log", 0},
+	}
+	for _, f := range files {
+		writeLogFile(t, filepath.Join(dir, f.name), []string{f.name}, now.Add(-f.age))
+	}
+	if err := os.Mkdir(filepath.Join(dir, "web_output.log.d"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	got := rotatedFiles(path)
+	want := []string{
+		filepath.Join(dir, "web_output-2026-01-02T00-00-00.000.log.gz"),
+		filepath.Join(dir, "web_output-2026-01-01T00-00-00.000.log"),
+		filepath.Join(dir, "web_output.log.2.gz"),
+		filepath.Join(dir, "web_output.log.1"),
+		filepath.Join(dir, "web_output-2026-01-03T00-00-00.000.log.gz"),
+		path,
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("rotatedFiles() = %q, want %q", got, want)
+	}
+
+	if got := rotatedFiles(filepath.Join(dir, "missing.log")); len(got) != 0 {
+		t.Fatalf("rotatedFiles() of a missing log = %q", got)
+	}
+}
+
+// grepLines 在文件中搜索，返回输出的行，上下文行以 "-" 开头
+func grepLines(t *testing.T, src *logSource, path string, m *logMatcher, around int) []string {
+	t.Helper()
+
+	got := make([]string, 0)
+	err := src.grep(context.Background(), path, m, around, func(line *codec.LogLine) error {
+		prefix := ""
+		if line.Context {
+			prefix = "-"
+		}
+		got = append(got, prefix+line.Line)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return got
+}
+
+func TestLogGrepContext(t *testing.T) {
+	dir := t.TempDir()
+	lines := []string{"a", "b", "c", "MATCH 1", "d", "e", "MATCH 2", "f", "MATCH 3", "g", "h", "i"}
+
+	for _, name := range []string{"web.log", "web.log.1.gz"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			writeLogFile(t, path, lines, time.Now())
+
+			m, err := newLogMatcher(&codec.ActionMsg{Pattern: "match", IgnoreCase: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			src := &logSource{project: "web", process: "api", stream: streamStdout}
+
+			tests := []struct {
+				around int
+				want   []string
+			}{
+				{around: 0, want: []string{"MATCH 1", "MATCH 2", "MATCH 3"}},
+				{around: 1, want: []string{"-c", "MATCH 1", "-d", "-e", "MATCH 2", "-f", "MATCH 3", "-g"}},
+				{around: 2, want: []string{"-b", "-c", "MATCH 1", "-d", "-e", "MATCH 2", "-f", "MATCH 3", "-g", "-h"}},
+			}
+
+			for _, tt := range tests {
+				if got := grepLines(t, src, path, m, tt.around); !slices.Equal(got, tt.want) {
+					t.Errorf("context %d: got %q, want %q", tt.around, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLogMatcherFields(t *testing.T) {
+	record := func(stream, message string) string {
+		p := newTestProcess()
+		line := &codec.LogLine{Time: time.Now(), Project: "web", Process: "api", Stream: stream, Line: message}
+		return strings.TrimSuffix(string(p.encodeJSON(line)), "\n")
+	}
+
+	rows := []string{
+		record(streamStdout, `{"level":"info","msg":"started","port":8080}`),
+		record(streamStderr, `{"level":"error","msg":"failed","port":8080}`),
+		record(streamStderr, "plain error text"),
+		`{"level":"error","msg":"not a record"}`,
+		"level=error",
+	}
+
+	tests := []struct {
+		fields  []string
+		pattern string
+		want    []int
+	}{
+		{fields: []string{"level=error"}, want: []int{1, 3}},
+		{fields: []string{"level=error", "stream=stderr"}, want: []int{1}},
+		{fields: []string{"stream=stderr"}, want: []int{1, 2}},
+		{fields: []string{"port=8080", "process=api"}, want: []int{0, 1}},
+		{fields: []string{"msg=started"}, want: []int{0}},
+		{fields: []string{"level=error"}, pattern: "failed", want: []int{1}},
+		{fields: []string{"missing=x"}, want: []int{}},
+	}
+
+	src := &logSource{project: "web", process: "api", format: logFormatJSON}
+	for _, tt := range tests {
+		m, err := newLogMatcher(&codec.ActionMsg{Pattern: tt.pattern, Fields: tt.fields})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := make([]int, 0)
+		for i, row := range rows {
+			if m.match(row, src.parseLine(row, time.Time{})) {
+				got = append(got, i)
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("fields %q pattern %q matched rows %v, want %v", tt.fields, tt.pattern, got, tt.want)
+		}
+	}
+
+	if _, err := newLogMatcher(&codec.ActionMsg{Fields: []string{"level"}}); err == nil {
+		t.Error("expected an error for a field filter without a value")
+	}
+}
+
+// responseRecorder 记录会话发送的响应
+type responseRecorder struct {
+	responses []*codec.ResponseMsg
+}
+
+func (r *responseRecorder) WriteResponse(id uint32, res *codec.ResponseMsg) error {
+	r.responses = append(r.responses, res)
+	return nil
+}
+
+// newGrepTestSession 创建只有一个进程 web::api 的会话，进程的日志写在 dir 中
+func newGrepTestSession(dir, format string) (*SpmSession, *responseRecorder) {
+	sv := newACLTestSupervisor(nil)
+
+	p := newTestProcess()
+	p.opts.LogFormat = format
+	p.OutLog = filepath.Join(dir, "api_output.log")
+	p.ErrLog = filepath.Join(dir, "api_error.log")
+
+	proj := &Project{Name: "web", procTable: NewProcTable(), running: make(map[string]bool)}
+	proj.procTable.Set(p.Name, p)
+	sv.projectTable.Set(proj.Name, proj)
+	sv.procList.Add(p.FullName)
+
+	rec := &responseRecorder{}
+	return &SpmSession{sv: sv, out: rec, ctx: context.Background(), logger: zap.NewNop().Sugar()}, rec
+}
+
+func TestLogGrepSince(t *testing.T) {
+	since := time.Now().Add(-time.Hour)
+
+	t.Run("raw", func(t *testing.T) {
+		dir := t.TempDir()
+		se, rec := newGrepTestSession(dir, logFormatRaw)
+		writeLogFile(t, filepath.Join(dir, "api_output.log"), []string{"old", "new"}, time.Now())
+
+		se.doLogGrep(&codec.ActionMsg{Action: codec.ActionLogGrep, Processes: "web::api", Since: since})
+
+		if len(rec.responses) != 1 || rec.responses[0].Code != 400 || !strings.Contains(rec.responses[0].Message, "raw") {
+			t.Fatalf("responses = %+v, want one 400 response about raw logs", rec.responses)
+		}
+	})
+
+	t.Run("timestamped", func(t *testing.T) {
+		dir := t.TempDir()
+		se, rec := newGrepTestSession(dir, logFormatTimestamped)
+		old := since.Add(-time.Minute).Format(time.RFC3339Nano)
+		recent := since.Add(time.Minute).Format(time.RFC3339Nano)
+		writeLogFile(t, filepath.Join(dir, "api_output.log"), []string{old + " stdout old", recent + " stdout new"}, time.Now())
+
+		se.doLogGrep(&codec.ActionMsg{Action: codec.ActionLogGrep, Processes: "web::api", Since: since})
+
+		got := make([]string, 0)
+		for _, res := range rec.responses {
+			if res.Code != 200 {
+				t.Fatalf("response = %+v", res)
+			}
+			for _, line := range res.Logs {
+				got = append(got, line.Line)
+			}
+		}
+		if !slices.Equal(got, []string{"new"}) {
+			t.Fatalf("lines = %q, want [new]", got)
+		}
+	})
+}
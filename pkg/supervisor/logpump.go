@@ -0,0 +1,320 @@
+// 进程输出的日志泵
+//
+// 每个输出流由三部分组成：
+//   - 读取 goroutine：不停地从管道读取数据块，保证子进程不会因为管道写满而阻塞
+//   - 组装器：把数据块拆分成行，超过 maxLogLine 的行会被切分，
+//     没有换行符的不完整行在 logPartialTimeout 之后单独输出
+//   - 输出端：日志文件、前台终端等，每个输出端有自己的队列。队列写满时丢弃日志并计数，
+//     丢弃的行数显示在 spm status 中，磁盘或者远程输出端再慢也不会阻塞读取，子进程不会因为写日志而停住
+
+package supervisor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"spm/pkg/codec"
+	"spm/pkg/config"
+)
+
+const (
+	maxLogLine        = 16 << 20               // 单行日志的最大长度，超过时切分
+	logPartialTimeout = 500 * time.Millisecond // 不完整的行等待换行符的时间
+	logDrainTimeout   = 200 * time.Millisecond // 停止进程后继续读取管道中剩余数据的时间
+	logChunkQueue     = 64                     // 读取 goroutine 和组装器之间的队列长度
+	logSinkQueue      = 4096                   // 每个输出端的队列长度
+)
+
+// logEntry 是日志泵输出的一行
+//
+// line 的内容已经替换了非法的 UTF-8，用于内存缓冲区、CBOR 和 JSON；
+// raw 是子进程输出的原始内容，写入 raw 和 timestamped 格式的日志文件；
+// seq 是这一行在内存缓冲区中的序号
+type logEntry struct {
+	line *codec.LogLine
+	raw  []byte
+	seq  uint64
+}
+
+// logSink 是日志行的一个输出端
+type logSink struct {
+	name    string
+	queue   chan logEntry
+	write   func(logEntry) error
+	dropped *atomic.Uint64
+	done    chan struct{}
+	onClose func() // 队列写完后调用，用于关闭连接
+}
+
+func newLogSink(name string, dropped *atomic.Uint64, write func(logEntry) error) *logSink {
+	return &logSink{
+		name:    name,
+		queue:   make(chan logEntry, logSinkQueue),
+		write:   write,
+		dropped: dropped,
+		done:    make(chan struct{}),
+	}
+}
+
+// offer 把一行日志放入队列，队列已满时丢弃并计数，不会阻塞
+func (s *logSink) offer(e logEntry) {
+	select {
+	case s.queue <- e:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// run 依次写出队列中的日志，队列关闭并写完后结束
+func (s *logSink) run(p *Process) {
+	defer close(s.done)
+
+	if s.onClose != nil {
+		defer s.onClose()
+	}
+
+	failed := false
+	for e := range s.queue {
+		if err := s.write(e); err != nil {
+			// 写入失败的行也计入丢弃的行数
+			s.dropped.Add(1)
+			// 同一个输出端连续失败时只记录一次
+			if !failed {
+				p.logger.Warnf("%s log write error: %v", s.name, err)
+			}
+			failed = true
+			continue
+		}
+		failed = false
+	}
+}
+
+// close 关闭队列并等待剩余的日志写完
+func (s *logSink) close() {
+	close(s.queue)
+	<-s.done
+}
+
+// setupStreams 设置标准输出和错误输出的管道，并启动日志泵
+//
+// 注意事项：
+//
+//	使用 os.Pipe 而不是 cmd.StdoutPipe，cmd.Wait 不会关闭读取端，
+//	子进程退出后管道中剩余的输出也能完整读出
+func (p *Process) setupStreams(cmd *exec.Cmd) error {
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		closeFiles([]*os.File{outR, outW})
+		return fmt.Errorf("failed to create stderr pipe: %w", err)
+	}
+
+	cmd.Stdout = outW
+	cmd.Stderr = errW
+
+	// 启动日志监控 goroutine
+	var streams sync.WaitGroup
+	drained := make(chan struct{})
+
+	p.wg.Add(2)
+	streams.Add(2)
+	go func() {
+		defer streams.Done()
+		p.watchLog(p.ctx, "STDOUT", outR)
+	}()
+	go func() {
+		defer streams.Done()
+		p.watchLog(p.ctx, "STDERR", errR)
+	}()
+	go func() {
+		streams.Wait()
+		close(drained)
+	}()
+
+	p.mu.Lock()
+	p.drained = drained
+	p.mu.Unlock()
+
+	return nil
+}
+
+// closePipeWriters 关闭父进程中管道的写入端，子进程退出后读取端才能读到 EOF
+func closePipeWriters(cmd *exec.Cmd) {
+	for _, w := range []any{cmd.Stdout, cmd.Stderr} {
+		if f, ok := w.(*os.File); ok {
+			_ = f.Close()
+		}
+	}
+}
+
+// logSinks 创建一个输出流的所有输出端
+//
+// 写入文件时持有输出流缓冲区的锁，spm logs -f 读取到的缓冲区内容和文件长度保持一致
+func (p *Process) logSinks(logtype string, dest logWriter, ring *logRing) []*logSink {
+	file := newLogSink(logtype+" file", &p.logDropped, func(e logEntry) error {
+		return ring.writeFile(e.seq, func() error {
+			_, err := dest.Write(p.formatLine(e))
+			return err
+		})
+	})
+
+	sinks := []*logSink{file}
+
+	if config.ForegroundFlag {
+		sinks = append(sinks, newLogSink(logtype+" console", &p.logDropped, func(e logEntry) error {
+			p.printLine(e.line)
+			return nil
+		}))
+	}
+
+	for _, opt := range p.opts.LogSinks {
+		remote, err := p.newRemoteSink(opt)
+		if err != nil {
+			p.logger.Error(err)
+			continue
+		}
+
+		sink := newLogSink(logtype+" "+opt.Type, &p.logDropped, func(e logEntry) error {
+			return remote.write(e.line)
+		})
+		sink.onClose = remote.close
+		sinks = append(sinks, sink)
+	}
+
+	return sinks
+}
+
+// watchLog 读取一个输出流，把每一行写入内存缓冲区和所有输出端
+func (p *Process) watchLog(ctx context.Context, logtype string, r *os.File) {
+	defer p.wg.Done()
+
+	dest := p.stdout
+	if logtype == "STDERR" {
+		dest = p.stderr
+	}
+
+	src := &logSource{
+		project: strings.Split(p.FullName, "::")[0],
+		process: p.Name,
+		stream:  strings.ToLower(logtype),
+	}
+	ring := p.ring(src.stream)
+
+	sinks := p.logSinks(logtype, dest, ring)
+	for _, s := range sinks {
+		go s.run(p)
+	}
+
+	defer func() {
+		for _, s := range sinks {
+			s.close()
+		}
+		if err := dest.Close(); err != nil {
+			p.logger.Warnf("%s log file close error: %v", logtype, err)
+		}
+		p.logger.Infof("%s logging finished", logtype)
+	}()
+
+	emit := func(data []byte) {
+		e := logEntry{
+			line: src.newLogLine(time.Now(), string(data)),
+			raw:  data,
+		}
+		e.seq = ring.Add(e.line)
+		p.checkTriggers(e.line)
+		for _, s := range sinks {
+			s.offer(e)
+		}
+	}
+
+	chunks := make(chan []byte, logChunkQueue)
+	go p.readPipe(ctx, logtype, r, chunks)
+
+	var partial []byte
+	timer := time.NewTimer(logPartialTimeout)
+	timer.Stop()
+
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				if len(partial) > 0 {
+					emit(partial)
+				}
+				return
+			}
+
+			// 只在新读到的数据里查找换行符，超长的行不会被反复扫描
+			scanned := len(partial)
+			partial = append(partial, chunk...)
+			for {
+				i := bytes.IndexByte(partial[scanned:], '\n')
+				if i < 0 {
+					break
+				}
+				i += scanned
+				emit(partial[:i])
+				partial = partial[i+1:]
+				scanned = 0
+			}
+
+			// 超长的行按 maxLogLine 切分
+			for len(partial) >= maxLogLine {
+				emit(partial[:maxLogLine])
+				partial = partial[maxLogLine:]
+			}
+
+			timer.Stop()
+			if len(partial) > 0 {
+				timer.Reset(logPartialTimeout)
+			}
+		case <-timer.C:
+			if len(partial) > 0 {
+				emit(partial)
+				partial = nil
+			}
+		}
+	}
+}
+
+// readPipe 不停地从管道读取数据，读到 EOF 或者 ctx 取消之后关闭 chunks
+func (p *Process) readPipe(ctx context.Context, logtype string, r *os.File, chunks chan<- []byte) {
+	defer close(chunks)
+	defer func() {
+		_ = r.Close()
+	}()
+
+	// 进程停止之后只再读取一小段时间，避免孙进程一直持有管道
+	stop := context.AfterFunc(ctx, func() {
+		_ = r.SetReadDeadline(time.Now().Add(logDrainTimeout))
+	})
+	defer stop()
+
+	buf := make([]byte, logReadChunk)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			chunks <- bytes.Clone(buf[:n])
+		}
+
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) && !errors.Is(err, os.ErrDeadlineExceeded) && !errors.Is(err, io.EOF) {
+				p.logger.Errorf("%s read error: %v", logtype, err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,509 @@
+// 进程日志查看功能
+//
+// spm logs 通过 daemon 读取进程的 <name>_output.log 和 <name>_error.log，
+// 先输出每个文件最后的若干行，follow 模式下再持续推送新写入的日志。
+// 没有指定 --since 时优先使用内存中的环形缓冲区，daemon 重启之前的输出才读取文件。
+
+package supervisor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"spm/pkg/codec"
+)
+
+const (
+	defaultLogLines  = 100                    // 默认输出的行数
+	logBatchSize     = 200                    // 每条响应消息最多包含的行数
+	logBatchBytes    = 8 << 20                // 每条响应消息中日志内容的最大字节数，小于 codec.MaxFrameSize
+	logFlushInterval = 200 * time.Millisecond // follow 模式下合并发送的时间间隔
+	logPollInterval  = 250 * time.Millisecond // follow 模式下检查文件变化的时间间隔
+	logReadChunk     = 32 * 1024
+)
+
+const (
+	streamStdout = "stdout"
+	streamStderr = "stderr"
+)
+
+// logSource 是一个需要读取的进程日志文件
+type logSource struct {
+	project string
+	process string
+	stream  string // 合并输出的文件为空
+	path    string
+	proc    *Process
+	format  string // 文件的 logFormat
+	filter  string // 只返回指定输出流的行，为空时全部返回
+}
+
+// accept 判断一行日志是否满足输出流的过滤条件
+func (src *logSource) accept(line *codec.LogLine) bool {
+	return src.filter == "" || line.Stream == src.filter
+}
+
+// newLogLine 创建一行日志，保证内容是合法的 UTF-8，避免 CBOR 解码失败
+func (src *logSource) newLogLine(t time.Time, line string) *codec.LogLine {
+	return &codec.LogLine{
+		Time:    t,
+		Project: src.project,
+		Process: src.process,
+		Stream:  src.stream,
+		Line:    strings.ToValidUTF8(line, "�"),
+	}
+}
+
+// findProc 根据完整进程名查找进程，项目或进程不存在时返回 false
+func (sv *Supervisor) findProc(fullName string) (*Process, bool) {
+	appName, procName, ok := strings.Cut(fullName, "::")
+	if !ok {
+		return nil, false
+	}
+
+	proj := sv.projectTable.Get(appName)
+	if proj == nil {
+		return nil, false
+	}
+
+	return proj.procTable.Get(procName)
+}
+
+// resolveProcs 把请求中的进程名列表解析为进程实例
+//
+// 规则与 doAction 一致：
+//   - "*" 表示当前工作目录对应项目的所有进程
+//   - "name" 表示当前项目中的进程
+//   - "project::name" 表示指定项目中的进程
+func (se *SpmSession) resolveProcs(msg *codec.ActionMsg) ([]*Process, error) {
+	names := msg.Processes
+	if names == "" {
+		names = "*"
+	}
+
+	var local *Project
+	localProject := func() (*Project, error) {
+		if local != nil {
+			return local, nil
+		}
+
+		opt, err := LoadProcfileOption(msg.WorkDir, msg.Procfile)
+		if err != nil {
+			return nil, err
+		}
+
+		local = se.sv.projectTable.Get(opt.AppName)
+		if local == nil {
+			return nil, fmt.Errorf("cannot find project %s", opt.AppName)
+		}
+
+		return local, nil
+	}
+
+	procs := make([]*Process, 0)
+	for _, n := range strings.Split(names, ";") {
+		switch {
+		case n == "*":
+			proj, err := localProject()
+			if err != nil {
+				return nil, err
+			}
+			procs = append(procs, proj.GetProcs()...)
+		case strings.Contains(n, "::"):
+			p, ok := se.sv.findProc(n)
+			if !ok {
+				return nil, fmt.Errorf("cannot find process %s", n)
+			}
+			procs = append(procs, p)
+		default:
+			proj, err := localProject()
+			if err != nil {
+				return nil, err
+			}
+			p, ok := proj.procTable.Get(n)
+			if !ok {
+				return nil, fmt.Errorf("cannot find process %s::%s", proj.Name, n)
+			}
+			procs = append(procs, p)
+		}
+	}
+
+	return procs, nil
+}
+
+// logSources 返回请求涉及的所有日志文件
+func (se *SpmSession) logSources(msg *codec.ActionMsg) ([]*logSource, error) {
+	procs, err := se.resolveProcs(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make([]*logSource, 0, len(procs)*2)
+	for _, p := range procs {
+		project := strings.Split(p.FullName, "::")[0]
+		source := func(stream, path string) *logSource {
+			return &logSource{
+				project: project,
+				process: p.Name,
+				stream:  stream,
+				path:    path,
+				proc:    p,
+				format:  p.opts.LogFormat,
+			}
+		}
+
+		// 合并输出的进程只有一个文件，按行里的输出流过滤
+		if p.opts.MergeOutput {
+			src := source("", p.OutLog)
+			src.filter = msg.Stream
+			sources = append(sources, src)
+			continue
+		}
+
+		if msg.Stream == "" || msg.Stream == streamStdout {
+			sources = append(sources, source(streamStdout, p.OutLog))
+		}
+		if msg.Stream == "" || msg.Stream == streamStderr {
+			sources = append(sources, source(streamStderr, p.ErrLog))
+		}
+	}
+
+	return sources, nil
+}
+
+// recent 从内存缓冲区读取最后 n 行，缓冲区为空时返回 false
+//
+// 返回的行和 follow 的起始位置在持有缓冲区锁时一起确定：只返回已经写入日志文件的行，
+// 同时读取文件的长度，follow 从这里继续读取时不会重复也不会遗漏。
+// 合并输出的文件由两个输出流共同写入，需要同时持有两个缓冲区的锁。
+func (src *logSource) recent(n int) ([]*codec.LogLine, int64, bool) {
+	p := src.proc
+
+	var lines []*codec.LogLine
+	if src.stream != "" {
+		ring := p.ring(src.stream)
+		ring.mu.Lock()
+		defer ring.mu.Unlock()
+
+		lines = ring.tailWritten(n)
+	} else {
+		p.outRing.mu.Lock()
+		defer p.outRing.mu.Unlock()
+		p.errRing.mu.Lock()
+		defer p.errRing.mu.Unlock()
+
+		switch src.filter {
+		case streamStdout:
+			lines = p.outRing.tailWritten(n)
+		case streamStderr:
+			lines = p.errRing.tailWritten(n)
+		default:
+			lines = mergeRecent(p.outRing.tailWritten(n), p.errRing.tailWritten(n), n)
+		}
+	}
+
+	if len(lines) == 0 {
+		return nil, 0, false
+	}
+
+	// follow 模式从文件当前的末尾继续读取
+	var size int64
+	if info, err := os.Stat(src.path); err == nil {
+		size = info.Size()
+	}
+
+	return lines, size, true
+}
+
+// tail 读取日志文件的最后 n 行
+//
+// 返回：
+//
+//	[]*codec.LogLine: 日志行，按文件中的顺序排列
+//	int64: 读取时文件的长度，follow 模式从这个位置继续读取
+//	error: 文件不存在以外的错误
+func (src *logSource) tail(n int, since time.Time) ([]*codec.LogLine, int64, error) {
+	f, err := os.Open(src.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	size := info.Size()
+	mtime := info.ModTime()
+
+	// 文件在 since 之后没有写入过，里面不会有需要的日志
+	if !since.IsZero() && mtime.Before(since) {
+		return nil, size, nil
+	}
+
+	// 从文件末尾向前读取，直到找到足够的换行符
+	var data []byte
+	pos := size
+	for pos > 0 && bytes.Count(data, []byte{'\n'}) <= n {
+		chunk := int64(logReadChunk)
+		if pos < chunk {
+			chunk = pos
+		}
+		pos -= chunk
+
+		buf := make([]byte, chunk)
+		if _, err := f.ReadAt(buf, pos); err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		data = append(buf, data...)
+	}
+
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		return nil, size, nil
+	}
+
+	rows := strings.Split(text, "\n")
+	if pos > 0 && len(rows) > 0 {
+		// 第一行可能是不完整的
+		rows = rows[1:]
+	}
+	if len(rows) > n {
+		rows = rows[len(rows)-n:]
+	}
+
+	lines := make([]*codec.LogLine, 0, len(rows))
+	for _, row := range rows {
+		line := src.parseLine(row, time.Time{})
+		if !src.accept(line) {
+			continue
+		}
+		// 带时间的格式可以精确过滤
+		if !since.IsZero() && !line.Time.IsZero() && line.Time.Before(since) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, size, nil
+}
+
+// follow 从 offset 位置开始持续读取日志文件中新写入的行
+//
+// 注意事项：
+//
+//	文件被截断或者被轮转替换时，从新文件的开头重新读取
+func (src *logSource) follow(ctx context.Context, offset int64, out chan<- *codec.LogLine) {
+	var f *os.File
+	var info os.FileInfo
+	var partial []byte
+
+	defer func() {
+		if f != nil {
+			_ = f.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(logPollInterval)
+	defer ticker.Stop()
+
+	buf := make([]byte, logReadChunk)
+
+	for {
+		if f == nil {
+			file, err := os.Open(src.path)
+			if err == nil {
+				f = file
+				info, _ = f.Stat()
+				if info == nil || info.Size() < offset {
+					offset = 0
+				}
+				_, _ = f.Seek(offset, io.SeekStart)
+			}
+		}
+
+		if f != nil {
+			for {
+				n, err := f.Read(buf)
+				if n > 0 {
+					partial = append(partial, buf[:n]...)
+					offset += int64(n)
+
+					for {
+						i := bytes.IndexByte(partial, '\n')
+						if i < 0 {
+							break
+						}
+
+						line := src.parseLine(string(partial[:i]), time.Now())
+						partial = partial[i+1:]
+						if !src.accept(line) {
+							continue
+						}
+
+						select {
+						case out <- line:
+						case <-ctx.Done():
+							return
+						}
+					}
+				}
+
+				if err != nil {
+					break
+				}
+			}
+
+			// 检查文件是否被轮转或者截断
+			latest, err := os.Stat(src.path)
+			if err != nil || !os.SameFile(info, latest) || latest.Size() < offset {
+				_ = f.Close()
+				f = nil
+				offset = 0
+				partial = partial[:0]
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// batchLen 返回一条响应消息能够包含的行数，行数和字节数都不超过限制，至少包含一行
+func batchLen(lines []*codec.LogLine) int {
+	size := 0
+	for i, line := range lines {
+		size += len(line.Line)
+		if i == logBatchSize || (i > 0 && size > logBatchBytes) {
+			return i
+		}
+	}
+
+	return len(lines)
+}
+
+// sendLogs 分批发送日志行，final 为 true 时最后一条消息的 More 为 false
+func (se *SpmSession) sendLogs(lines []*codec.LogLine, final bool) error {
+	for len(lines) > 0 || final {
+		n := batchLen(lines)
+		last := n == len(lines)
+
+		res := &codec.ResponseMsg{
+			Code:    200,
+			Message: codec.ActionResponse[codec.ActionLog],
+			Logs:    lines[:n],
+			More:    !(final && last),
+		}
+
+		if se.sendResponse(res, codec.ResponseNormal) == codec.ResponseMsgErr {
+			return errors.New("send logs failed")
+		}
+
+		lines = lines[n:]
+		if last {
+			break
+		}
+	}
+
+	return nil
+}
+
+// doLogs 处理 ActionLog 请求，以流式响应的方式发送日志
+func (se *SpmSession) doLogs(msg *codec.ActionMsg) codec.ResponseCtl {
+	sources, err := se.logSources(msg)
+	if err != nil {
+		se.logger.Error(err)
+		return se.sendResponse(&codec.ResponseMsg{
+			Code:    404,
+			Message: err.Error(),
+		}, codec.ResponseMsgErr)
+	}
+
+	n := msg.Lines
+	if n <= 0 {
+		n = defaultLogLines
+	}
+
+	lines := make([]*codec.LogLine, 0)
+	offsets := make([]int64, len(sources))
+	for i, src := range sources {
+		if msg.Since.IsZero() {
+			if tail, offset, ok := src.recent(n); ok {
+				lines = append(lines, tail...)
+				offsets[i] = offset
+				continue
+			}
+		}
+
+		tail, offset, err := src.tail(n, msg.Since)
+		if err != nil {
+			se.logger.Error(err)
+			continue
+		}
+
+		lines = append(lines, tail...)
+		offsets[i] = offset
+	}
+
+	// 有时间戳的日志按时间交错排列
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].Time.Before(lines[j].Time)
+	})
+
+	if err := se.sendLogs(lines, !msg.Follow); err != nil || !msg.Follow {
+		return codec.ResponseNormal
+	}
+
+	// 客户端取消请求或者断开连接时 se.ctx 被取消
+	ctx, cancel := context.WithCancel(se.ctx)
+	defer cancel()
+
+	out := make(chan *codec.LogLine, logBatchSize)
+	for i, src := range sources {
+		go src.follow(ctx, offsets[i], out)
+	}
+
+	ticker := time.NewTicker(logFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*codec.LogLine, 0, logBatchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			// 请求被取消时发送剩余的日志和结束响应，连接已经断开时发送会失败
+			_ = se.sendLogs(batch, true)
+			return codec.ResponseNormal
+		case line := <-out:
+			batch = append(batch, line)
+			if len(batch) < logBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		if err := se.sendLogs(batch, false); err != nil {
+			return codec.ResponseNormal
+		}
+		batch = make([]*codec.LogLine, 0, logBatchSize)
+	}
+}
@@ -0,0 +1,293 @@
+// 把进程输出转发到日志收集服务的输出端
+//
+// 支持的 logSinks 类型：
+//   - syslog：RFC5424 格式，地址可以是 unix 套接字、udp 或者 tcp（使用 RFC6587 octet counting 分帧）
+//   - journald：systemd-journald 的原生协议，默认地址是 /run/systemd/journal/socket
+//   - tcp：每行一个 JSON 对象的 TCP 流，格式与 logFormat: json 相同
+//
+// 每个输出流的每个输出端都有自己的队列和连接，收集服务不可用时日志被丢弃并计数，
+// 之后按 sinkRedialInterval 重新连接，不会阻塞子进程。
+
+package supervisor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"spm/pkg/codec"
+)
+
+const (
+	logSinkSyslog   = "syslog"
+	logSinkJournald = "journald"
+	logSinkTCP      = "tcp"
+)
+
+const (
+	defaultSyslogAddress  = "unix:///dev/log"
+	defaultJournalAddress = "unix:///run/systemd/journal/socket"
+	defaultSyslogFacility = "user"
+
+	sinkDialTimeout     = 3 * time.Second
+	sinkWriteTimeout    = 5 * time.Second
+	sinkRedialInterval  = time.Second
+	maxSyslogAppNameLen = 48
+)
+
+// syslogFacilities 是 RFC5424 中定义的 facility 编号
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// errSinkDown 表示输出端正在等待重新连接
+var errSinkDown = errors.New("log sink is disconnected")
+
+// LogSinkOption 是一个日志输出端的配置
+type LogSinkOption struct {
+	Type     string `yaml:"type"`
+	Address  string `yaml:"address,omitempty"`  // unix:///path、udp://host:port 或 tcp://host:port
+	Facility string `yaml:"facility,omitempty"` // syslog 的 facility，默认为 user
+	Tag      string `yaml:"tag,omitempty"`      // syslog 的 APP-NAME 和 journald 的 SYSLOG_IDENTIFIER，默认为进程全名
+}
+
+// validate 检查配置并填充默认地址
+func (o *LogSinkOption) validate() error {
+	switch o.Type {
+	case logSinkSyslog:
+		if o.Address == "" {
+			o.Address = defaultSyslogAddress
+		}
+		if o.Facility == "" {
+			o.Facility = defaultSyslogFacility
+		}
+		if _, ok := syslogFacilities[o.Facility]; !ok {
+			return fmt.Errorf("unknown syslog facility %q", o.Facility)
+		}
+	case logSinkJournald:
+		if o.Address == "" {
+			o.Address = defaultJournalAddress
+		}
+	case logSinkTCP:
+		if o.Address == "" {
+			return errors.New("tcp log sink requires an address")
+		}
+	default:
+		return fmt.Errorf("unsupported log sink type %q", o.Type)
+	}
+
+	_, _, err := parseSinkAddr(o.Address)
+	return err
+}
+
+// parseSinkAddr 解析输出端地址，返回 net.Dial 使用的网络类型和地址
+func parseSinkAddr(addr string) (string, string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid log sink address %q: %w", addr, err)
+	}
+
+	switch u.Scheme {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
+		if u.Host == "" {
+			return "", "", fmt.Errorf("invalid log sink address %q: missing host", addr)
+		}
+		return u.Scheme, u.Host, nil
+	case "unix", "unixgram":
+		path := u.Path
+		if path == "" {
+			path = u.Opaque
+		}
+		if path == "" {
+			return "", "", fmt.Errorf("invalid log sink address %q: missing path", addr)
+		}
+		return u.Scheme, path, nil
+	default:
+		return "", "", fmt.Errorf("unsupported log sink address %q", addr)
+	}
+}
+
+// isStream 判断网络类型是否是面向流的，流式连接需要分帧
+func isStream(network string) bool {
+	return strings.HasPrefix(network, "tcp") || network == "unix"
+}
+
+// remoteSink 是一个连接到日志收集服务的写入器，连接断开后自动重连
+type remoteSink struct {
+	network  string
+	address  string
+	encode   func(line *codec.LogLine, stream bool) []byte
+	conn     net.Conn
+	nextDial time.Time
+}
+
+func (r *remoteSink) dial() error {
+	if time.Now().Before(r.nextDial) {
+		return errSinkDown
+	}
+
+	networks := []string{r.network}
+	if r.network == "unix" {
+		// /dev/log 和 journald 的套接字都是数据报套接字
+		networks = []string{"unixgram", "unix"}
+	}
+
+	var err error
+	for _, network := range networks {
+		var conn net.Conn
+		conn, err = net.DialTimeout(network, r.address, sinkDialTimeout)
+		if err == nil {
+			r.conn = conn
+			r.network = network
+			return nil
+		}
+	}
+
+	r.nextDial = time.Now().Add(sinkRedialInterval)
+	return err
+}
+
+func (r *remoteSink) write(line *codec.LogLine) error {
+	if r.conn == nil {
+		if err := r.dial(); err != nil {
+			return err
+		}
+	}
+
+	_ = r.conn.SetWriteDeadline(time.Now().Add(sinkWriteTimeout))
+	if _, err := r.conn.Write(r.encode(line, isStream(r.network))); err != nil {
+		r.close()
+		r.nextDial = time.Now().Add(sinkRedialInterval)
+		return err
+	}
+
+	return nil
+}
+
+func (r *remoteSink) close() {
+	if r.conn != nil {
+		_ = r.conn.Close()
+		r.conn = nil
+	}
+}
+
+// newRemoteSink 根据配置创建输出端的写入器
+func (p *Process) newRemoteSink(opt *LogSinkOption) (*remoteSink, error) {
+	network, address, err := parseSinkAddr(opt.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	tag := opt.Tag
+	if tag == "" {
+		tag = p.FullName
+	}
+
+	r := &remoteSink{network: network, address: address}
+
+	switch opt.Type {
+	case logSinkSyslog:
+		hostname, _ := os.Hostname()
+		facility := syslogFacilities[opt.Facility]
+		r.encode = func(line *codec.LogLine, stream bool) []byte {
+			return p.encodeSyslog(line, facility, hostname, tag, stream)
+		}
+	case logSinkJournald:
+		r.encode = func(line *codec.LogLine, _ bool) []byte {
+			return p.encodeJournal(line, tag)
+		}
+	case logSinkTCP:
+		r.encode = func(line *codec.LogLine, _ bool) []byte {
+			return p.encodeJSON(line)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported log sink type %q", opt.Type)
+	}
+
+	return r, nil
+}
+
+// severity 返回输出流对应的 syslog 级别，stderr 为 err，stdout 为 info
+func severity(stream string) int {
+	if stream == streamStderr {
+		return 3
+	}
+	return 6
+}
+
+// syslogName 把字符串转换为 RFC5424 允许的 PRINTUSASCII 字段
+func syslogName(s string, limit int) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r <= 32 || r >= 127 {
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+
+	name := b.String()
+	if name == "" {
+		return "-"
+	}
+	if len(name) > limit {
+		name = name[:limit]
+	}
+
+	return name
+}
+
+// encodeSyslog 按 RFC5424 编码一行日志，流式连接使用 octet counting 分帧
+func (p *Process) encodeSyslog(line *codec.LogLine, facility int, hostname, tag string, stream bool) []byte {
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d %s - %s",
+		facility*8+severity(line.Stream),
+		line.Time.Format(time.RFC3339Nano),
+		syslogName(hostname, 255),
+		syslogName(tag, maxSyslogAppNameLen),
+		p.runPid.Load(),
+		syslogName(line.Stream, 32),
+		line.Line,
+	)
+
+	if stream {
+		return []byte(strconv.Itoa(len(msg)) + " " + msg)
+	}
+
+	return []byte(msg)
+}
+
+// encodeJournal 按 journald 原生协议编码一行日志
+func (p *Process) encodeJournal(line *codec.LogLine, tag string) []byte {
+	var buf bytes.Buffer
+
+	field := func(key, value string) {
+		if !strings.Contains(value, "\n") {
+			buf.WriteString(key + "=" + value + "\n")
+			return
+		}
+
+		// 包含换行符的值使用二进制格式：名称、换行、64 位小端长度、值、换行
+		buf.WriteString(key + "\n")
+		_ = binary.Write(&buf, binary.LittleEndian, uint64(len(value)))
+		buf.WriteString(value + "\n")
+	}
+
+	field("MESSAGE", line.Line)
+	field("PRIORITY", strconv.Itoa(severity(line.Stream)))
+	field("SYSLOG_IDENTIFIER", tag)
+	field("SYSLOG_PID", strconv.Itoa(int(p.runPid.Load())))
+	field("SPM_PROJECT", line.Project)
+	field("SPM_PROCESS", line.Process)
+	field("SPM_STREAM", line.Stream)
+
+	return buf.Bytes()
+}
@@ -0,0 +1,272 @@
+package supervisor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"spm/pkg/codec"
+)
+
+func newTestProcess() *Process {
+	p := &Process{
+		Name:     "api",
+		FullName: "web::api",
+		Instance: 1,
+		opts:     &ProcessOption{},
+		logger:   zap.NewNop().Sugar(),
+	}
+	p.runPid.Store(4321)
+
+	return p
+}
+
+func testLogLine(stream, text string) *codec.LogLine {
+	return &codec.LogLine{
+		Time:    time.Date(2026, 1, 2, 3, 4, 5, 600, time.UTC),
+		Project: "web",
+		Process: "api",
+		Stream:  stream,
+		Line:    text,
+	}
+}
+
+// newTestSink 按配置创建输出端，配置不合法时测试失败
+func newTestSink(t *testing.T, p *Process, opt *LogSinkOption) *remoteSink {
+	t.Helper()
+
+	if err := opt.validate(); err != nil {
+		t.Fatal(err)
+	}
+	sink, err := p.newRemoteSink(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sink.close)
+
+	return sink
+}
+
+// accept 接受一个连接，超时时测试失败
+func accept(t *testing.T, ln net.Listener) net.Conn {
+	t.Helper()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		conn, err := ln.Accept()
+		ch <- result{conn, err}
+	}()
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		_ = r.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		t.Cleanup(func() { _ = r.conn.Close() })
+		return r.conn
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the log sink to connect")
+		return nil
+	}
+}
+
+// readOctetCounted 按 RFC6587 octet counting 读取一帧：长度、空格、消息
+func readOctetCounted(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+
+	size, err := r.ReadString(' ')
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(size, " "))
+	if err != nil {
+		t.Fatalf("invalid frame length %q", size)
+	}
+
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	return string(msg)
+}
+
+func TestSyslogTCPFraming(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	p := newTestProcess()
+	sink := newTestSink(t, p, &LogSinkOption{
+		Type:     logSinkSyslog,
+		Address:  "tcp://" + ln.Addr().String(),
+		Facility: "local0",
+		Tag:      "myapp",
+	})
+
+	// 消息里的空格和数字不能影响分帧
+	lines := []*codec.LogLine{
+		testLogLine(streamStdout, "listening on 0.0.0.0:8080"),
+		testLogLine(streamStderr, "12 34 error: 数据库连接失败"),
+	}
+	for _, line := range lines {
+		if err := sink.write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := bufio.NewReader(accept(t, ln))
+	want := []string{
+		"<134>1 2026-01-02T03:04:05.0000006Z ",
+		"<131>1 2026-01-02T03:04:05.0000006Z ",
+	}
+	for i, line := range lines {
+		msg := readOctetCounted(t, r)
+		if !strings.HasPrefix(msg, want[i]) {
+			t.Fatalf("frame %d = %q, want prefix %q", i, msg, want[i])
+		}
+		if suffix := fmt.Sprintf(" myapp 4321 %s - %s", line.Stream, line.Line); !strings.HasSuffix(msg, suffix) {
+			t.Fatalf("frame %d = %q, want suffix %q", i, msg, suffix)
+		}
+	}
+}
+
+func TestSyslogDatagramNotFramed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	p := newTestProcess()
+	sink := newTestSink(t, p, &LogSinkOption{Type: logSinkSyslog, Address: "unix://" + path})
+	if err := sink.write(testLogLine(streamStdout, "hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<14>1 ") || !strings.HasSuffix(msg, " web::api 4321 stdout - hello") {
+		t.Fatalf("datagram = %q", msg)
+	}
+}
+
+func TestTCPSinkNDJSON(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	p := newTestProcess()
+	sink := newTestSink(t, p, &LogSinkOption{Type: logSinkTCP, Address: "tcp://" + ln.Addr().String()})
+
+	lines := []*codec.LogLine{
+		testLogLine(streamStdout, "first"),
+		testLogLine(streamStderr, "second\twith \"quotes\""),
+	}
+	for _, line := range lines {
+		if err := sink.write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scanner := bufio.NewScanner(accept(t, ln))
+	for i, line := range lines {
+		if !scanner.Scan() {
+			t.Fatalf("missing line %d: %v", i, scanner.Err())
+		}
+
+		var rec jsonLogRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("line %d is not JSON: %q", i, scanner.Text())
+		}
+		if rec.Message != line.Line || rec.Stream != line.Stream || rec.Project != "web" ||
+			rec.Process != "api" || rec.Instance != 1 || rec.Pid != 4321 || !rec.Time.Equal(line.Time) {
+			t.Fatalf("line %d = %+v", i, rec)
+		}
+	}
+}
+
+func TestTCPSinkRedial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+
+	p := newTestProcess()
+	sink := newTestSink(t, p, &LogSinkOption{Type: logSinkTCP, Address: "tcp://" + addr})
+
+	if err := sink.write(testLogLine(streamStdout, "before")); err != nil {
+		t.Fatal(err)
+	}
+	conn := accept(t, ln)
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || !strings.Contains(line, `"before"`) {
+		t.Fatalf("read %q: %v", line, err)
+	}
+
+	// 收集服务重启：关闭连接和监听
+	_ = conn.Close()
+	_ = ln.Close()
+
+	// 对端关闭之后的第一次写入可能成功，之后的写入会失败
+	var writeErr error
+	for i := 0; i < 50 && writeErr == nil; i++ {
+		writeErr = sink.write(testLogLine(streamStdout, "lost"))
+		time.Sleep(10 * time.Millisecond)
+	}
+	if writeErr == nil {
+		t.Fatal("writes kept succeeding after the collector went away")
+	}
+
+	// 重连间隔之内不会重新连接
+	if err := sink.write(testLogLine(streamStdout, "lost")); err != errSinkDown {
+		t.Fatalf("write during redial interval = %v, want errSinkDown", err)
+	}
+
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("cannot listen on %s again: %v", addr, err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	deadline := time.Now().Add(sinkRedialInterval + 3*time.Second)
+	for {
+		err := sink.write(testLogLine(streamStdout, "after"))
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("log sink did not reconnect: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if line, err := bufio.NewReader(accept(t, ln)).ReadString('\n'); err != nil || !strings.Contains(line, `"after"`) {
+		t.Fatalf("read %q after reconnect: %v", line, err)
+	}
+}
@@ -0,0 +1,183 @@
+// 根据进程输出触发动作的功能
+//
+// logTriggers 中的每一项包含一个正则表达式和一个动作，日志泵每组装出一行就检查一次：
+//   - ready：把进程标记为就绪，例如匹配 "Listening on"
+//   - restart：重启进程，例如匹配 "FATAL: database is locked"
+//   - hook：执行一条 shell 命令，匹配的行通过 SPM_TRIGGER_LINE 传入
+//   - event：发布一个 log_matched 事件，spm events 可以收到
+//
+// 同一个触发器在 cooldown 时间内只会执行一次，输出刷屏时不会造成重启风暴。
+
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sync"
+	"time"
+
+	"spm/pkg/codec"
+)
+
+const (
+	triggerReady   = "ready"
+	triggerRestart = "restart"
+	triggerHook    = "hook"
+	triggerEvent   = "event"
+)
+
+const (
+	defaultTriggerCooldown = 10 * time.Second
+	triggerHookTimeout     = 30 * time.Second
+)
+
+// LogTriggerOption 是一个日志触发器的配置
+type LogTriggerOption struct {
+	Pattern  string        `yaml:"pattern"`
+	Stream   string        `yaml:"stream,omitempty"`   // stdout 或 stderr，为空时两个都检查
+	Action   string        `yaml:"action"`             // ready、restart、hook 或 event
+	Command  string        `yaml:"command,omitempty"`  // hook 动作执行的 shell 命令
+	Cooldown time.Duration `yaml:"cooldown,omitempty"` // 两次触发之间的最短间隔，默认 10s
+}
+
+// validate 检查触发器的配置
+func (o *LogTriggerOption) validate() error {
+	if _, err := regexp.Compile(o.Pattern); err != nil {
+		return fmt.Errorf("invalid log trigger pattern %q: %w", o.Pattern, err)
+	}
+
+	switch o.Stream {
+	case "", streamStdout, streamStderr:
+	default:
+		return fmt.Errorf("invalid log trigger stream %q", o.Stream)
+	}
+
+	switch o.Action {
+	case triggerReady, triggerRestart, triggerEvent:
+	case triggerHook:
+		if o.Command == "" {
+			return fmt.Errorf("log trigger %q requires a command", o.Pattern)
+		}
+	default:
+		return fmt.Errorf("unsupported log trigger action %q", o.Action)
+	}
+
+	if o.Cooldown <= 0 {
+		o.Cooldown = defaultTriggerCooldown
+	}
+
+	return nil
+}
+
+// logTrigger 是编译后的日志触发器
+type logTrigger struct {
+	opt *LogTriggerOption
+	re  *regexp.Regexp
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+// newLogTriggers 编译进程的日志触发器，配置在加载时已经检查过
+func newLogTriggers(opts []*LogTriggerOption) []*logTrigger {
+	triggers := make([]*logTrigger, 0, len(opts))
+	for _, opt := range opts {
+		re, err := regexp.Compile(opt.Pattern)
+		if err != nil {
+			continue
+		}
+		triggers = append(triggers, &logTrigger{opt: opt, re: re})
+	}
+
+	return triggers
+}
+
+// allow 判断触发器是否已经过了冷却时间，允许时记录本次触发的时间
+func (t *logTrigger) allow(now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.last.IsZero() && now.Sub(t.last) < t.opt.Cooldown {
+		return false
+	}
+
+	t.last = now
+	return true
+}
+
+// waitReadyTrigger 判断进程是否要等 ready 触发器匹配之后才算就绪
+func (p *Process) waitReadyTrigger() bool {
+	for _, t := range p.triggers {
+		if t.opt.Action == triggerReady {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTriggers 用一行日志检查所有触发器，由日志泵调用，动作在后台执行
+func (p *Process) checkTriggers(line *codec.LogLine) {
+	for _, t := range p.triggers {
+		if t.opt.Stream != "" && t.opt.Stream != line.Stream {
+			continue
+		}
+		if !t.re.MatchString(line.Line) || !t.allow(line.Time) {
+			continue
+		}
+
+		go p.fireTrigger(t, line)
+	}
+}
+
+// fireTrigger 执行触发器的动作
+func (p *Process) fireTrigger(t *logTrigger, line *codec.LogLine) {
+	p.logger.Infof("Log trigger %q matched for %s. Action: %s", t.opt.Pattern, p.Name, t.opt.Action)
+
+	switch t.opt.Action {
+	case triggerReady:
+		p.mu.Lock()
+		ready := p.Ready
+		p.Ready = true
+		p.mu.Unlock()
+
+		if !ready {
+			p.logger.Infof("Process %s is ready", p.Name)
+			p.publishType(codec.EventReady)
+		}
+	case triggerRestart:
+		if p.IsRunning() {
+			p.requestRestart()
+		}
+	case triggerHook:
+		p.runTriggerHook(t, line)
+	case triggerEvent:
+		p.publish(&codec.Event{Type: codec.EventLogMatched, Message: line.Line})
+	}
+}
+
+// runTriggerHook 在进程的工作目录中执行 hook 命令
+func (p *Process) runTriggerHook(t *logTrigger, line *codec.LogLine) {
+	ctx, cancel := context.WithTimeout(context.Background(), triggerHookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, defaultShell, "-c", t.opt.Command)
+	cmd.Dir = p.opts.Root
+	cmd.Env = append(os.Environ(), p.opts.Env...)
+	cmd.Env = append(cmd.Env, p.metadataEnv()...)
+	cmd.Env = append(cmd.Env,
+		"SPM_TRIGGER_PATTERN="+t.opt.Pattern,
+		"SPM_TRIGGER_STREAM="+line.Stream,
+		"SPM_TRIGGER_LINE="+line.Line,
+	)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		p.logger.Warnf("Log trigger hook for %s failed: %v: %s", p.Name, err, out)
+		return
+	}
+
+	p.logger.Debugf("Log trigger hook for %s finished: %s", p.Name, out)
+}
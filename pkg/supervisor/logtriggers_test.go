@@ -0,0 +1,140 @@
+package supervisor
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"spm/pkg/codec"
+)
+
+// newTriggerTestProcess 创建一个带有日志触发器的进程，进程指向测试进程自己，IsRunning 返回 true
+func newTriggerTestProcess(t *testing.T, opts ...*LogTriggerOption) *Process {
+	t.Helper()
+
+	for _, opt := range opts {
+		if err := opt.validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	self, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestProcess()
+	p.triggers = newLogTriggers(opts)
+	p.sysproc = self
+	p.Pid = os.Getpid()
+
+	return p
+}
+
+func triggerLine(stream, text string, at time.Time) *codec.LogLine {
+	line := testLogLine(stream, text)
+	line.Time = at
+	return line
+}
+
+func TestLogTriggerRestartCooldown(t *testing.T) {
+	p := newTriggerTestProcess(t, &LogTriggerOption{
+		Pattern:  `FATAL: database is locked`,
+		Stream:   streamStderr,
+		Action:   triggerRestart,
+		Cooldown: time.Minute,
+	})
+
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	lines := []*codec.LogLine{
+		triggerLine(streamStderr, "FATAL: database is locked", start),
+		triggerLine(streamStderr, "FATAL: database is locked", start.Add(time.Second)),
+		triggerLine(streamStderr, "retrying", start.Add(2*time.Second)),
+		triggerLine(streamStdout, "FATAL: database is locked", start.Add(2*time.Minute)),
+		triggerLine(streamStderr, "FATAL: database is locked", start.Add(59*time.Second)),
+	}
+	for _, line := range lines {
+		p.checkTriggers(line)
+	}
+
+	// 冷却时间内的匹配和其他输出流的行都不会触发，重启请求通过 processRestarts 交给 daemon
+	expectRestarts(t, p, 1)
+
+	p.checkTriggers(triggerLine(streamStderr, "FATAL: database is locked", start.Add(time.Minute)))
+	expectRestarts(t, p, 1)
+}
+
+// expectRestarts 从 processRestarts 接收 n 个重启请求，之后不能再有更多的请求
+func expectRestarts(t *testing.T, p *Process, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		select {
+		case got := <-processRestarts:
+			if got != p {
+				t.Fatalf("restart requested for %s, want %s", got.FullName, p.FullName)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d restart requests, want %d", i, n)
+		}
+	}
+
+	select {
+	case got := <-processRestarts:
+		t.Fatalf("unexpected restart request for %s", got.FullName)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestLogTriggerNotRunning(t *testing.T) {
+	p := newTriggerTestProcess(t, &LogTriggerOption{Pattern: "panic", Action: triggerRestart})
+	p.sysproc = nil
+
+	p.checkTriggers(triggerLine(streamStdout, "panic: oops", time.Now()))
+	expectRestarts(t, p, 0)
+}
+
+func TestLogTriggerEvent(t *testing.T) {
+	p := newTriggerTestProcess(t,
+		&LogTriggerOption{Pattern: `error (\d+)`, Action: triggerEvent},
+		&LogTriggerOption{Pattern: `listening`, Action: triggerReady, Stream: streamStdout},
+	)
+
+	ch := eventBus.Sub(processTopic(p.FullName))
+	defer func() {
+		go eventBus.Unsub(ch)
+		for range ch {
+		}
+	}()
+
+	start := time.Now()
+	p.checkTriggers(triggerLine(streamStdout, "error 1", start))
+	p.checkTriggers(triggerLine(streamStderr, "error 2", start.Add(time.Second)))
+	p.checkTriggers(triggerLine(streamStdout, "listening on :8080", start))
+	p.checkTriggers(triggerLine(streamStdout, "error 3", start.Add(defaultTriggerCooldown)))
+
+	got := make(map[codec.EventType][]string)
+	timeout := time.After(5 * time.Second)
+	for len(got[codec.EventLogMatched]) < 2 || len(got[codec.EventReady]) < 1 {
+		select {
+		case ev := <-ch:
+			got[ev.Type] = append(got[ev.Type], ev.Message)
+		case <-timeout:
+			t.Fatalf("events = %v", got)
+		}
+	}
+
+	select {
+	case ev := <-ch:
+		t.Fatalf("unexpected event %s %q", ev.Type, ev.Message)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	matched := got[codec.EventLogMatched]
+	if !(matched[0] == "error 1" && matched[1] == "error 3") && !(matched[0] == "error 3" && matched[1] == "error 1") {
+		t.Fatalf("log_matched events = %q, want error 1 and error 3", matched)
+	}
+	if !p.Ready {
+		t.Fatal("ready trigger did not mark the process ready")
+	}
+}
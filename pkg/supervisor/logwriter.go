@@ -0,0 +1,229 @@
+// 进程输出日志文件的写入和轮转
+//
+// 没有配置 logRotate 时日志以 O_APPEND 方式写入，收到 SIGUSR1 时重新打开，
+// 方便配合外部的 logrotate 使用。配置了 logRotate 时由 lumberjack 按大小、
+// 天数轮转，每次写入一整行，轮转不会把一行拆到两个文件中。
+
+package supervisor
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// LogRotateOption 是进程输出日志的轮转配置
+type LogRotateOption struct {
+	MaxSize    int  `yaml:"maxSize,omitempty"`    // 单个文件的最大大小，单位 MB，默认 100
+	MaxAge     int  `yaml:"maxAge,omitempty"`     // 轮转文件保留的天数，0 表示不按时间清理
+	MaxBackups int  `yaml:"maxBackups,omitempty"` // 保留的轮转文件个数，0 表示全部保留
+	Compress   bool `yaml:"compress,omitempty"`   // 是否用 gzip 压缩轮转文件
+	Daily      bool `yaml:"daily,omitempty"`      // 是否在每天第一次写入时轮转
+}
+
+// enabled 判断是否配置了任何轮转规则
+func (o *LogRotateOption) enabled() bool {
+	return o != nil && (o.MaxSize > 0 || o.MaxAge > 0 || o.MaxBackups > 0 || o.Compress || o.Daily)
+}
+
+// logWriter 是进程输出日志的写入器
+type logWriter interface {
+	io.WriteCloser
+	// Reopen 关闭并重新打开日志文件，用于外部轮转之后
+	Reopen() error
+}
+
+// newLogWriter 根据轮转配置创建日志写入器
+func newLogWriter(path string, rotate *LogRotateOption) (logWriter, error) {
+	if !rotate.enabled() {
+		w := &fileWriter{path: path}
+		if err := w.open(); err != nil {
+			return nil, err
+		}
+		return w, nil
+	}
+
+	w := &rotateWriter{
+		daily: rotate.Daily,
+		day:   fileDay(path),
+		lj: &lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    rotate.MaxSize,
+			MaxAge:     rotate.MaxAge,
+			MaxBackups: rotate.MaxBackups,
+			Compress:   rotate.Compress,
+		},
+	}
+
+	// 提前创建文件，和不轮转时的行为保持一致
+	if _, err := w.lj.Write(nil); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func today() string {
+	return time.Now().Format(time.DateOnly)
+}
+
+// fileDay 返回已有日志文件最后写入的日期，文件不存在时返回今天
+//
+// 守护进程重启之后，前一天写入的文件在今天第一次写入时仍然需要轮转
+func fileDay(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return today()
+	}
+
+	return info.ModTime().Format(time.DateOnly)
+}
+
+// fileWriter 以追加方式写入文件，可以重新打开
+type fileWriter struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	closed bool
+}
+
+func (w *fileWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	w.file = f
+	return nil
+}
+
+func (w *fileWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	return w.file.Write(b)
+}
+
+func (w *fileWriter) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+
+	_ = w.file.Close()
+	return w.open()
+}
+
+func (w *fileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+
+	w.closed = true
+	return w.file.Close()
+}
+
+// rotateWriter 使用 lumberjack 轮转日志文件，并支持按天轮转
+type rotateWriter struct {
+	mu     sync.Mutex
+	lj     *lumberjack.Logger
+	daily  bool
+	day    string
+	closed bool
+}
+
+func (w *rotateWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	if w.daily {
+		if day := today(); day != w.day {
+			w.day = day
+			if err := w.lj.Rotate(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return w.lj.Write(b)
+}
+
+func (w *rotateWriter) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+
+	// lumberjack 在下一次写入时重新打开文件
+	return w.lj.Close()
+}
+
+func (w *rotateWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+
+	w.closed = true
+	return w.lj.Close()
+}
+
+// ReopenLogs 重新打开进程当前的输出日志文件
+func (p *Process) ReopenLogs() {
+	p.mu.Lock()
+	writers := []logWriter{p.stdout, p.stderr}
+	p.mu.Unlock()
+
+	for _, w := range writers {
+		if w == nil {
+			continue
+		}
+		if err := w.Reopen(); err != nil {
+			p.logger.Error(err)
+		}
+	}
+}
+
+// watchReopen 在收到 SIGUSR1 时重新打开所有进程的输出日志文件
+func (sv *Supervisor) watchReopen() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+
+	for range ch {
+		sv.logger.Info("Received SIGUSR1. Reopening process logs")
+
+		if sv.audit != nil {
+			if err := sv.audit.reopen(); err != nil {
+				sv.logger.Errorf("Cannot reopen audit log: %v", err)
+			}
+		}
+
+		for _, proj := range sv.projectTable.Iter() {
+			for _, p := range proj.GetProcs() {
+				p.ReopenLogs()
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package supervisor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestDailyRotateOldFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		age     time.Duration
+		rotate  *LogRotateOption
+		rotated bool
+	}{
+		{name: "written yesterday", age: 48 * time.Hour, rotate: &LogRotateOption{Daily: true}, rotated: true},
+		{name: "written today", age: 0, rotate: &LogRotateOption{Daily: true}, rotated: false},
+		{name: "not daily", age: 48 * time.Hour, rotate: &LogRotateOption{MaxBackups: 3}, rotated: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "web_output.log")
+
+			// daemon 重启之前写入的文件
+			if err := os.WriteFile(path, []byte("old line\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			mtime := time.Now().Add(-tt.age)
+			if err := os.Chtimes(path, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+
+			w, err := newLogWriter(path, tt.rotate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = w.Close()
+			}()
+
+			if _, err := w.Write([]byte("new line\n")); err != nil {
+				t.Fatal(err)
+			}
+
+			backups, err := filepath.Glob(filepath.Join(dir, "web_output-*.log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !tt.rotated {
+				if len(backups) != 0 {
+					t.Fatalf("unexpected rotated files %q", backups)
+				}
+				if got := readFile(t, path); got != "old line\nnew line\n" {
+					t.Fatalf("log = %q, want both lines", got)
+				}
+				return
+			}
+
+			if len(backups) != 1 {
+				t.Fatalf("rotated files = %q, want one", backups)
+			}
+			if got := readFile(t, backups[0]); got != "old line\n" {
+				t.Fatalf("rotated file = %q, want the old line", got)
+			}
+			if got := readFile(t, path); got != "new line\n" {
+				t.Fatalf("log = %q, want only the new line", got)
+			}
+
+			// 同一天再次写入不会继续轮转
+			if _, err := w.Write([]byte("another line\n")); err != nil {
+				t.Fatal(err)
+			}
+			if again, _ := filepath.Glob(filepath.Join(dir, "web_output-*.log")); len(again) != 1 {
+				t.Fatalf("rotated files = %q after a second write, want one", again)
+			}
+		})
+	}
+}
@@ -0,0 +1,368 @@
+// Prometheus 文本格式的指标
+//
+// 进程指标带有 project、process 和 instance 标签，CPU、内存和文件描述符从
+// /proc/<pid> 读取，只统计进程本身，不包含它的子进程。daemon 指标包括每种请求的
+// 处理时间、丢弃的通知数量和 goroutine 数量。
+//
+// 指标接口在全局配置的 metrics 部分开启，可以挂在 HTTP API 的监听地址上，
+// 也可以使用单独的监听地址供 Prometheus 远程抓取。
+
+package supervisor
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"spm/pkg/codec"
+	"spm/pkg/config"
+)
+
+// clockTicks 是 /proc/<pid>/stat 中 CPU 时间的单位，Linux 上固定为 100
+const clockTicks = 100
+
+// requestBuckets 是请求处理时间直方图的上界，单位为秒
+var requestBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// processStates 是 spm_process_state 输出的所有状态
+var processStates = []codec.ProcessState{
+	codec.ProcessStandby,
+	codec.ProcessStarted,
+	codec.ProcessRunning,
+	codec.ProcessStopping,
+	codec.ProcessStopped,
+	codec.ProcessFailed,
+	codec.ProcessUnknown,
+}
+
+// metricActionNames 是与动作名称不同的指标标签，指标标签发布之后保持不变，不跟随动作名称修改
+var metricActionNames = map[codec.ActionCtl]string{
+	codec.ActionLogGrep: "logs_grep",
+}
+
+// metricActionName 返回请求类型在指标标签中的名称
+func metricActionName(action codec.ActionCtl) string {
+	if name, ok := metricActionNames[action]; ok {
+		return name
+	}
+
+	return action.String()
+}
+
+// histogram 是一个请求类型的处理时间分布，counts 是落在每个区间的次数，不累加
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+var (
+	requestMu    sync.Mutex
+	requestStats = make(map[codec.ActionCtl]*histogram)
+)
+
+// observeRequest 记录一次请求的处理时间
+func observeRequest(action codec.ActionCtl, start time.Time) {
+	seconds := time.Since(start).Seconds()
+
+	requestMu.Lock()
+	defer requestMu.Unlock()
+
+	h, ok := requestStats[action]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(requestBuckets))}
+		requestStats[action] = h
+	}
+
+	for i, le := range requestBuckets {
+		if seconds <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// procStat 是从 /proc 读取的进程资源使用情况
+type procStat struct {
+	cpu float64 // 用户态和内核态 CPU 时间，单位为秒
+	rss uint64  // 常驻内存字节数
+	fds int     // 打开的文件描述符数量
+}
+
+// readProcStat 读取进程的 CPU 时间、常驻内存和打开的文件描述符数量
+func readProcStat(pid int) (*procStat, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	// 进程名可能包含空格和括号，从最后一个右括号之后开始解析，第一个字段是 state
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return nil, fmt.Errorf("invalid stat of pid %d", pid)
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("invalid stat of pid %d", pid)
+	}
+
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	rss, _ := strconv.ParseUint(fields[21], 10, 64)
+
+	stat := &procStat{
+		cpu: float64(utime+stime) / clockTicks,
+		rss: rss * uint64(os.Getpagesize()),
+	}
+
+	if fds, err := os.ReadDir(fmt.Sprintf("/proc/%d/fd", pid)); err == nil {
+		stat.fds = len(fds)
+	}
+
+	return stat, nil
+}
+
+// metricsWriter 按 Prometheus 文本格式输出指标
+type metricsWriter struct {
+	buf bytes.Buffer
+}
+
+func (mw *metricsWriter) header(name, typ, help string) {
+	fmt.Fprintf(&mw.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample 输出一个样本，labels 是成对的标签名和标签值
+func (mw *metricsWriter) sample(name string, value float64, labels ...string) {
+	mw.buf.WriteString(name)
+
+	if len(labels) > 0 {
+		mw.buf.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				mw.buf.WriteByte(',')
+			}
+			fmt.Fprintf(&mw.buf, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		mw.buf.WriteByte('}')
+	}
+
+	mw.buf.WriteByte(' ')
+	mw.buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	mw.buf.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// processSample 是一个进程在一次抓取中的状态
+type processSample struct {
+	labels   []string
+	info     *codec.ProcInfo
+	restarts int
+	health   uint64
+	stat     *procStat
+}
+
+// processSamples 收集所有进程的状态
+func (sv *Supervisor) processSamples() []*processSample {
+	samples := make([]*processSample, 0)
+
+	for _, name := range sv.procList.All() {
+		p, ok := sv.findProc(name)
+		if !ok {
+			continue
+		}
+
+		project, _, _ := strings.Cut(p.FullName, "::")
+		s := &processSample{
+			labels:   []string{"project", project, "process", p.Name, "instance", strconv.Itoa(p.Instance)},
+			info:     sv.procInfo(p, project),
+			restarts: p.Restarts,
+			health:   p.healthFailures.Load(),
+		}
+
+		if s.info.Status == codec.ProcessRunning && s.info.Pid > 0 {
+			if stat, err := readProcStat(s.info.Pid); err == nil {
+				s.stat = stat
+			}
+		}
+
+		samples = append(samples, s)
+	}
+
+	return samples
+}
+
+// writeProcessMetrics 输出所有进程的指标
+func (sv *Supervisor) writeProcessMetrics(mw *metricsWriter) {
+	samples := sv.processSamples()
+	now := time.Now()
+
+	mw.header("spm_process_up", "gauge", "Whether the process is running.")
+	for _, s := range samples {
+		mw.sample("spm_process_up", boolValue(s.info.Status == codec.ProcessRunning), s.labels...)
+	}
+
+	mw.header("spm_process_state", "gauge", "Current state of the process, 1 for the current state and 0 for the others.")
+	for _, s := range samples {
+		for _, state := range processStates {
+			mw.sample("spm_process_state", boolValue(s.info.Status == state), append(s.labels, "state", string(state))...)
+		}
+	}
+
+	mw.header("spm_process_restarts_total", "counter", "Number of times the process has been restarted.")
+	for _, s := range samples {
+		mw.sample("spm_process_restarts_total", float64(s.restarts), s.labels...)
+	}
+
+	mw.header("spm_process_last_exit_code", "gauge", "Exit code of the last exit of the process, 128+signal when it was killed by a signal.")
+	for _, s := range samples {
+		if n := len(s.info.History); n > 0 {
+			mw.sample("spm_process_last_exit_code", float64(s.info.History[n-1].ExitCode), s.labels...)
+		}
+	}
+
+	mw.header("spm_process_uptime_seconds", "gauge", "Seconds since the process was started, 0 when it is not running.")
+	for _, s := range samples {
+		uptime := 0.0
+		if s.info.Status == codec.ProcessRunning && !s.info.StartAt.IsZero() {
+			uptime = now.Sub(s.info.StartAt).Seconds()
+		}
+		mw.sample("spm_process_uptime_seconds", uptime, s.labels...)
+	}
+
+	mw.header("spm_process_cpu_seconds_total", "counter", "User and system CPU time spent by the running process in seconds.")
+	for _, s := range samples {
+		if s.stat != nil {
+			mw.sample("spm_process_cpu_seconds_total", s.stat.cpu, s.labels...)
+		}
+	}
+
+	mw.header("spm_process_resident_memory_bytes", "gauge", "Resident memory size of the running process in bytes.")
+	for _, s := range samples {
+		if s.stat != nil {
+			mw.sample("spm_process_resident_memory_bytes", float64(s.stat.rss), s.labels...)
+		}
+	}
+
+	mw.header("spm_process_open_fds", "gauge", "Number of open file descriptors of the running process.")
+	for _, s := range samples {
+		if s.stat != nil {
+			mw.sample("spm_process_open_fds", float64(s.stat.fds), s.labels...)
+		}
+	}
+
+	mw.header("spm_process_ready", "gauge", "Whether the process passed its readiness check.")
+	for _, s := range samples {
+		mw.sample("spm_process_ready", boolValue(s.info.Ready), s.labels...)
+	}
+
+	mw.header("spm_process_health_failures_total", "counter", "Number of watchdog timeouts of the process.")
+	for _, s := range samples {
+		mw.sample("spm_process_health_failures_total", float64(s.health), s.labels...)
+	}
+}
+
+// writeDaemonMetrics 输出 daemon 自身的指标
+func (sv *Supervisor) writeDaemonMetrics(mw *metricsWriter) {
+	mw.header("spm_request_duration_seconds", "histogram", "Time spent handling control requests by action.")
+
+	requestMu.Lock()
+	for action := codec.ActionRun; action <= codec.ActionAudit; action++ {
+		h, ok := requestStats[action]
+		if !ok {
+			continue
+		}
+
+		name := metricActionName(action)
+		var cumulative uint64
+		for i, le := range requestBuckets {
+			cumulative += h.counts[i]
+			mw.sample("spm_request_duration_seconds_bucket", float64(cumulative), "action", name, "le", strconv.FormatFloat(le, 'g', -1, 64))
+		}
+		mw.sample("spm_request_duration_seconds_bucket", float64(h.count), "action", name, "le", "+Inf")
+		mw.sample("spm_request_duration_seconds_sum", h.sum, "action", name)
+		mw.sample("spm_request_duration_seconds_count", float64(h.count), "action", name)
+	}
+	requestMu.Unlock()
+
+	mw.header("spm_daemon_start_time_seconds", "gauge", "Start time of the spm daemon since unix epoch in seconds.")
+	mw.sample("spm_daemon_start_time_seconds", float64(sv.StartedAt.UnixNano())/1e9)
+
+	mw.header("spm_notifications_dropped_total", "counter", "Notifications dropped because the notification queue was full.")
+	mw.sample("spm_notifications_dropped_total", float64(notifyDropped.Load()))
+
+	mw.header("go_goroutines", "gauge", "Number of goroutines that currently exist.")
+	mw.sample("go_goroutines", float64(runtime.NumGoroutine()))
+}
+
+// serveMetrics 以 Prometheus 文本格式返回所有指标
+func (sv *Supervisor) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	mw := &metricsWriter{}
+	sv.writeProcessMetrics(mw)
+	sv.writeDaemonMetrics(mw)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(mw.buf.Bytes())
+}
+
+// metricsPath 返回指标接口的路径
+func metricsPath() string {
+	path := config.GetConfig().Metrics.Path
+	if path == "" {
+		return "/metrics"
+	}
+	if !strings.HasPrefix(path, "/") {
+		return "/" + path
+	}
+
+	return path
+}
+
+// startMetrics 在配置了单独的监听地址时启动指标接口，返回的监听器在 daemon 退出时关闭
+func (sv *Supervisor) startMetrics() net.Listener {
+	cfg := config.GetConfig()
+	if !cfg.Metrics.Enabled {
+		return nil
+	}
+
+	if cfg.Metrics.Listen == "" {
+		if !cfg.HTTP.Enabled {
+			sv.logger.Warn("Metrics need the HTTP API or a metrics listen address")
+		}
+		return nil
+	}
+
+	ln, err := listenHTTP(cfg.Metrics.Listen, false)
+	if err != nil {
+		sv.logger.Errorf("Cannot start metrics: %v", err)
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+metricsPath(), sv.serveMetrics)
+
+	sv.serveHTTP(ln, mux)
+	sv.logger.Infof("Metrics are listening on %s%s", cfg.Metrics.Listen, metricsPath())
+
+	return ln
+}
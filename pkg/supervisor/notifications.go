@@ -0,0 +1,243 @@
+// 进程事件的通知功能
+//
+// 全局配置的 notifications 部分定义了事件发生时的通知方式：
+//   - webhooks：以 POST 请求发送 JSON，内容可以用 text/template 模板定制，失败时按指数退避重试
+//   - commands：执行 shell 命令，事件以 JSON 格式写入命令的标准输入
+//
+// 两种通知都可以按事件类型和项目过滤。通知放入队列后由 maxNotifyInflight 个 goroutine 在后台发送，
+// 不会阻塞事件的发布；队列写满时丢弃最早的通知并计数，短时间内大量的事件不会让最新的 crashed
+// 和 crashloop 通知丢失。
+
+package supervisor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"spm/pkg/codec"
+	"spm/pkg/config"
+)
+
+const (
+	maxNotifyInflight        = 32
+	notifyQueueSize          = 1024
+	defaultWebhookTimeout    = 10 * time.Second
+	defaultWebhookRetries    = 3
+	defaultWebhookRetryDelay = time.Second
+	defaultCommandTimeout    = 30 * time.Second
+)
+
+var (
+	// notifyQueue 是等待发送的通知
+	notifyQueue = make(chan func(), notifyQueueSize)
+	// notifyDropped 是队列写满时丢弃的通知数量
+	notifyDropped atomic.Uint64
+	// notifyWorkers 保证发送通知的 goroutine 只启动一次
+	notifyWorkers sync.Once
+)
+
+// errRetryable 表示 webhook 请求失败后可以重试
+var errRetryable = errors.New("retryable webhook error")
+
+// notifyMatch 判断事件是否满足通知的过滤条件
+func notifyMatch(f *config.NotifyFilter, ev *codec.Event) bool {
+	if len(f.Events) > 0 && !slices.Contains(f.Events, string(ev.Type)) {
+		return false
+	}
+	if len(f.Projects) > 0 && !slices.Contains(f.Projects, ev.Project) {
+		return false
+	}
+	return true
+}
+
+// watchNotifications 订阅所有事件并发送通知，通知配置在每个事件发生时读取，reload 之后立即生效
+func (sv *Supervisor) watchNotifications() {
+	sv.startNotifyWorkers()
+	ch := eventBus.Sub(topicAll)
+
+	for ev := range ch {
+		cfg := config.GetConfig().Notifications
+
+		for _, hook := range cfg.Webhooks {
+			if notifyMatch(&hook.NotifyFilter, ev) {
+				sv.notify(func() { sv.sendWebhook(hook, ev) })
+			}
+		}
+
+		for _, hook := range cfg.Commands {
+			if notifyMatch(&hook.NotifyFilter, ev) {
+				sv.notify(func() { sv.runCommandHook(hook, ev) })
+			}
+		}
+	}
+}
+
+// startNotifyWorkers 启动 maxNotifyInflight 个发送通知的 goroutine
+func (sv *Supervisor) startNotifyWorkers() {
+	notifyWorkers.Do(func() {
+		for range maxNotifyInflight {
+			go func() {
+				for fn := range notifyQueue {
+					fn()
+				}
+			}()
+		}
+	})
+}
+
+// notify 把一次通知放入队列，队列已满时丢弃最早的通知，不会阻塞
+func (sv *Supervisor) notify(fn func()) {
+	for {
+		select {
+		case notifyQueue <- fn:
+			return
+		default:
+		}
+
+		// 其他 goroutine 可能同时取走了队列中的通知，取不到时重新尝试放入
+		select {
+		case <-notifyQueue:
+			n := notifyDropped.Add(1)
+			sv.logger.Warnf("Notification queue is full. Dropped the oldest notification, %d dropped in total", n)
+		default:
+		}
+	}
+}
+
+// webhookBody 渲染 webhook 的请求内容，没有模板时发送事件本身
+func webhookBody(hook *config.Webhook, ev *codec.Event) ([]byte, error) {
+	if hook.Body == "" {
+		return json.Marshal(ev)
+	}
+
+	tmpl, err := template.New("body").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(hook.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook body template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, ev); err != nil {
+		return nil, fmt.Errorf("render webhook body: %w", err)
+	}
+
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("webhook body is not valid JSON: %s", buf.String())
+	}
+
+	return buf.Bytes(), nil
+}
+
+// sendWebhook 发送 webhook 请求，网络错误、429 和 5xx 响应按指数退避重试
+func (sv *Supervisor) sendWebhook(hook *config.Webhook, ev *codec.Event) {
+	body, err := webhookBody(hook, ev)
+	if err != nil {
+		sv.logger.Errorf("Webhook %s: %v", hook.URL, err)
+		return
+	}
+
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	retries := hook.Retries
+	if retries <= 0 {
+		retries = defaultWebhookRetries
+	}
+	delay := hook.RetryDelay
+	if delay <= 0 {
+		delay = defaultWebhookRetryDelay
+	}
+
+	client := &http.Client{Timeout: timeout}
+
+	for attempt := 0; ; attempt++ {
+		err = postWebhook(client, hook, body)
+		if err == nil {
+			sv.logger.Debugf("Webhook %s delivered %s event of %s::%s", hook.URL, ev.Type, ev.Project, ev.Process)
+			return
+		}
+
+		if !errors.Is(err, errRetryable) || attempt >= retries {
+			sv.logger.Errorf("Webhook %s failed for %s event of %s::%s: %v", hook.URL, ev.Type, ev.Project, ev.Process, err)
+			return
+		}
+
+		sv.logger.Warnf("Webhook %s failed: %v. Retrying in %s", hook.URL, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// postWebhook 发送一次 webhook 请求
+func postWebhook(client *http.Client, hook *config.Webhook, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "spm")
+	for k, v := range hook.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errRetryable, err)
+	}
+	_ = resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("%w: %s", errRetryable, resp.Status)
+	default:
+		return errors.New(resp.Status)
+	}
+}
+
+// runCommandHook 执行命令通知，事件 JSON 写入命令的标准输入
+func (sv *Supervisor) runCommandHook(hook *config.CommandHook, ev *codec.Event) {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = defaultCommandTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	data, _ := json.Marshal(ev)
+
+	cmd := exec.CommandContext(ctx, defaultShell, "-c", hook.Command)
+	cmd.Stdin = bytes.NewReader(append(data, '\n'))
+	cmd.Env = append(os.Environ(),
+		"SPM_EVENT_TYPE="+string(ev.Type),
+		"SPM_EVENT_PROJECT="+ev.Project,
+		"SPM_EVENT_PROCESS="+ev.Process,
+	)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		sv.logger.Warnf("Notification command %q failed: %v: %s", hook.Command, err, out)
+		return
+	}
+
+	sv.logger.Debugf("Notification command %q finished: %s", hook.Command, out)
+}
@@ -0,0 +1,207 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"spm/pkg/codec"
+	"spm/pkg/config"
+)
+
+func newTestSupervisor() *Supervisor {
+	return &Supervisor{logger: zap.NewNop().Sugar()}
+}
+
+func testEvent() *codec.Event {
+	return &codec.Event{
+		Time:     time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Type:     codec.EventCrashed,
+		Project:  "web",
+		Process:  "api",
+		Instance: 1,
+		ExitCode: 2,
+		Message:  `exited with "code" 2`,
+	}
+}
+
+func TestWebhookBody(t *testing.T) {
+	ev := testEvent()
+
+	t.Run("event", func(t *testing.T) {
+		body, err := webhookBody(&config.Webhook{}, ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got codec.Event
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Type != ev.Type || got.Project != ev.Project || got.Process != ev.Process || got.ExitCode != ev.ExitCode {
+			t.Fatalf("body = %s", body)
+		}
+	})
+
+	t.Run("template", func(t *testing.T) {
+		hook := &config.Webhook{Body: `{"text": "{{.Project}}::{{.Process}} {{.Type}}", "message": {{json .Message}}}`}
+		body, err := webhookBody(hook, ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got map[string]string
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got["text"] != "web::api crashed" || got["message"] != ev.Message {
+			t.Fatalf("body = %s", body)
+		}
+	})
+
+	t.Run("invalid json", func(t *testing.T) {
+		hook := &config.Webhook{Body: `{"text": "{{.Message}}"}`}
+		if _, err := webhookBody(hook, ev); err == nil {
+			t.Fatal("expected an error for a body that is not JSON")
+		}
+	})
+
+	t.Run("invalid template", func(t *testing.T) {
+		hook := &config.Webhook{Body: `{"text": "{{.Project"}`}
+		if _, err := webhookBody(hook, ev); err == nil {
+			t.Fatal("expected an error for an invalid template")
+		}
+	})
+}
+
+func TestSendWebhookRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int // 依次返回的状态码，用完之后一直返回最后一个
+		retries  int
+		hits     int
+	}{
+		{name: "success", statuses: []int{200}, hits: 1},
+		{name: "retry 5xx", statuses: []int{503, 502, 200}, retries: 3, hits: 3},
+		{name: "retry 429", statuses: []int{429, 204}, retries: 3, hits: 2},
+		{name: "give up", statuses: []int{500}, retries: 2, hits: 3},
+		{name: "no retry 4xx", statuses: []int{400}, retries: 3, hits: 1},
+		{name: "no retry 404", statuses: []int{404}, retries: 3, hits: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				hits   int
+				bodies [][]byte
+			)
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+
+				mu.Lock()
+				status := tt.statuses[min(hits, len(tt.statuses)-1)]
+				hits++
+				bodies = append(bodies, body)
+				mu.Unlock()
+
+				if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("X-Token") != "secret" {
+					t.Errorf("unexpected request %s %s", r.Method, r.Header)
+				}
+				w.WriteHeader(status)
+			}))
+			defer srv.Close()
+
+			hook := &config.Webhook{
+				URL:        srv.URL,
+				Headers:    map[string]string{"X-Token": "secret"},
+				Retries:    tt.retries,
+				RetryDelay: time.Millisecond,
+			}
+			newTestSupervisor().sendWebhook(hook, testEvent())
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if hits != tt.hits {
+				t.Fatalf("hits = %d, want %d", hits, tt.hits)
+			}
+			for _, body := range bodies {
+				if string(body) != string(bodies[0]) {
+					t.Fatalf("retried body %s differs from %s", body, bodies[0])
+				}
+			}
+		})
+	}
+}
+
+func TestSendWebhookNetworkError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	start := time.Now()
+	hook := &config.Webhook{URL: url, Retries: 2, RetryDelay: 10 * time.Millisecond}
+	newTestSupervisor().sendWebhook(hook, testEvent())
+
+	// 两次重试分别等待 10ms 和 20ms
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Fatalf("network errors were not retried, finished in %s", elapsed)
+	}
+}
+
+func TestNotifyMatch(t *testing.T) {
+	ev := testEvent()
+
+	tests := []struct {
+		name   string
+		filter config.NotifyFilter
+		want   bool
+	}{
+		{name: "empty", filter: config.NotifyFilter{}, want: true},
+		{name: "event", filter: config.NotifyFilter{Events: []string{"crashed", "crashloop"}}, want: true},
+		{name: "other event", filter: config.NotifyFilter{Events: []string{"ready"}}, want: false},
+		{name: "project", filter: config.NotifyFilter{Projects: []string{"web"}}, want: true},
+		{name: "other project", filter: config.NotifyFilter{Projects: []string{"db"}}, want: false},
+		{name: "both", filter: config.NotifyFilter{Events: []string{"crashed"}, Projects: []string{"web"}}, want: true},
+		{name: "event only", filter: config.NotifyFilter{Events: []string{"crashed"}, Projects: []string{"db"}}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := notifyMatch(&tt.filter, ev); got != tt.want {
+				t.Fatalf("notifyMatch = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotifyDropsOldest(t *testing.T) {
+	sv := newTestSupervisor()
+	dropped := notifyDropped.Load()
+
+	// 测试中没有启动发送通知的 goroutine，队列不会被消费
+	var order []int
+	for i := range notifyQueueSize + 2 {
+		sv.notify(func() { order = append(order, i) })
+	}
+
+	if n := notifyDropped.Load() - dropped; n != 2 {
+		t.Fatalf("dropped %d notifications, want 2", n)
+	}
+
+	for len(notifyQueue) > 0 {
+		(<-notifyQueue)()
+	}
+
+	if len(order) != notifyQueueSize || order[0] != 2 || order[len(order)-1] != notifyQueueSize+1 {
+		t.Fatalf("queue kept %d notifications from %d to %d", len(order), order[0], order[len(order)-1])
+	}
+}
@@ -40,6 +40,7 @@ func (sv *Supervisor) Status(p *Process) *Process {
 //
 //	appName: 项目名称，"*" 表示所有项目
 //	operation: 对单个进程执行的操作函数
+//	progress: 操作成功后对每个进程调用一次，可以为 nil
 //
 // 返回：
 //
@@ -48,9 +49,16 @@ func (sv *Supervisor) Status(p *Process) *Process {
 // 说明：
 //
 //	提取公共的进程迭代逻辑，避免代码重复
-func (sv *Supervisor) forEachProcess(appName string, operation func(*Process) *Process) []*Process {
+func (sv *Supervisor) forEachProcess(appName string, operation func(*Process) *Process, progress func(*Process)) []*Process {
 	procs := make([]*Process, 0)
 
+	done := func(p *Process) {
+		procs = append(procs, p)
+		if progress != nil {
+			progress(p)
+		}
+	}
+
 	if appName != "*" {
 		proj := sv.projectTable.Get(appName)
 		if proj == nil {
@@ -59,14 +67,14 @@ func (sv *Supervisor) forEachProcess(appName string, operation func(*Process) *P
 
 		for _, proc := range proj.GetProcs() {
 			if p := operation(proc); p != nil {
-				procs = append(procs, p)
+				done(p)
 			}
 		}
 	} else {
 		for _, name := range sv.procList.All() {
 			proc := sv.GetProcByName(name)
 			if p := operation(proc); p != nil {
-				procs = append(procs, p)
+				done(p)
 			}
 		}
 	}
@@ -79,6 +87,7 @@ func (sv *Supervisor) forEachProcess(appName string, operation func(*Process) *P
 // 参数：
 //
 //	appName: 项目名称，"*" 表示所有项目
+//	progress: 每处理完一个进程调用一次，可以为 nil
 //
 // 返回：
 //
@@ -86,10 +95,10 @@ func (sv *Supervisor) forEachProcess(appName string, operation func(*Process) *P
 //
 // 示例：
 //
-//	procs := sv.StatusAll("myapp")
-//	procs := sv.StatusAll("*")  // 所有进程
-func (sv *Supervisor) StatusAll(appName string) []*Process {
-	return sv.forEachProcess(appName, sv.Status)
+//	procs := sv.StatusAll("myapp", nil)
+//	procs := sv.StatusAll("*", nil)  // 所有进程
+func (sv *Supervisor) StatusAll(appName string, progress func(*Process)) []*Process {
+	return sv.forEachProcess(appName, sv.Status, progress)
 }
 
 // Start 启动单个进程
@@ -145,6 +154,8 @@ func (sv *Supervisor) Start(p *Process) *Process {
 	if state {
 		return p
 	} else {
+		p.reportFailure(1)
+
 		return &Process{
 			Pid:      p.Pid,
 			FullName: p.FullName,
@@ -160,6 +171,7 @@ func (sv *Supervisor) Start(p *Process) *Process {
 // 参数：
 //
 //	appName: 项目名称，"*" 表示所有项目
+//	progress: 每处理完一个进程调用一次，可以为 nil
 //
 // 返回：
 //
@@ -167,10 +179,10 @@ func (sv *Supervisor) Start(p *Process) *Process {
 //
 // 示例：
 //
-//	procs := sv.StartAll("myapp")
+//	procs := sv.StartAll("myapp", nil)
 //	fmt.Printf("启动了 %d 个进程\n", len(procs))
-func (sv *Supervisor) StartAll(appName string) []*Process {
-	return sv.forEachProcess(appName, sv.Start)
+func (sv *Supervisor) StartAll(appName string, progress func(*Process)) []*Process {
+	return sv.forEachProcess(appName, sv.Start, progress)
 }
 
 // Stop 停止单个进程
@@ -233,6 +245,7 @@ func (sv *Supervisor) Stop(p *Process) *Process {
 // 参数：
 //
 //	appName: 项目名称，"*" 表示所有项目
+//	progress: 每处理完一个进程调用一次，可以为 nil
 //
 // 返回：
 //
@@ -244,9 +257,9 @@ func (sv *Supervisor) Stop(p *Process) *Process {
 //
 // 示例：
 //
-//	procs := sv.StopAll("myapp")
+//	procs := sv.StopAll("myapp", nil)
 //	fmt.Printf("停止了 %d 个进程\n", len(procs))
-func (sv *Supervisor) StopAll(appName string) []*Process {
+func (sv *Supervisor) StopAll(appName string, progress func(*Process)) []*Process {
 	// 对于特定项目，需要检查进程状态，只停止运行中的进程
 	if appName != "*" {
 		proj := sv.projectTable.Get(appName)
@@ -260,7 +273,7 @@ func (sv *Supervisor) StopAll(appName string) []*Process {
 			}
 
 			return nil
-		})
+		}, progress)
 	} else {
 		// 对于所有项目，直接调用 Stop
 		return sv.forEachProcess(appName, func(p *Process) *Process {
@@ -268,7 +281,7 @@ func (sv *Supervisor) StopAll(appName string) []*Process {
 				return sv.Stop(p)
 			}
 			return nil
-		})
+		}, progress)
 	}
 }
 
@@ -290,7 +303,14 @@ func (sv *Supervisor) StopAll(appName string) []*Process {
 //
 //	proc := sv.Restart("myapp::web-server")
 func (sv *Supervisor) Restart(p *Process) *Process {
+	p.publishType(codec.EventRestarting)
+
 	sv.Stop(p)
+
+	p.mu.Lock()
+	p.Restarts++
+	p.mu.Unlock()
+
 	return sv.Start(p)
 }
 
@@ -299,6 +319,7 @@ func (sv *Supervisor) Restart(p *Process) *Process {
 // 参数：
 //
 //	appName: 项目名称，"*" 表示所有项目
+//	progress: 每个进程重新启动后调用一次，可以为 nil
 //
 // 返回：
 //
@@ -306,12 +327,48 @@ func (sv *Supervisor) Restart(p *Process) *Process {
 //
 // 实现：
 //
-//	先调用 StopAll 停止所有进程，再调用 StartAll 启动所有进程
+//	先调用 StopAll 停止所有进程，再逐个启动。与 Restart 一样发布 restarting 事件并增加重启次数
 //
 // 示例：
 //
-//	procs := sv.RestartAll("myapp")
-func (sv *Supervisor) RestartAll(appName string) []*Process {
-	sv.StopAll(appName)
-	return sv.StartAll(appName)
+//	procs := sv.RestartAll("myapp", nil)
+func (sv *Supervisor) RestartAll(appName string, progress func(*Process)) []*Process {
+	sv.forEachProcess(appName, func(p *Process) *Process {
+		if p != nil {
+			p.publishType(codec.EventRestarting)
+		}
+		return nil
+	}, nil)
+
+	sv.StopAll(appName, nil)
+
+	return sv.forEachProcess(appName, func(p *Process) *Process {
+		p.mu.Lock()
+		p.Restarts++
+		p.mu.Unlock()
+
+		return sv.Start(p)
+	}, progress)
+}
+
+// processRestarts 是进程自己发起的重启，例如看门狗超时和日志触发器，由 daemon 交给 Restart 处理
+var processRestarts = make(chan *Process)
+
+// requestRestart 请求 daemon 重启进程，不会阻塞
+//
+// 注意事项：
+//
+//	进程没有 Supervisor 的引用，通过 processRestarts 交给 daemon，
+//	与客户端的 restart 请求一样经过 Supervisor.Restart，项目表中的状态保持一致
+func (p *Process) requestRestart() {
+	go func() {
+		processRestarts <- p
+	}()
+}
+
+// watchRestarts 处理进程自己发起的重启
+func (sv *Supervisor) watchRestarts() {
+	for p := range processRestarts {
+		go sv.Restart(p)
+	}
 }
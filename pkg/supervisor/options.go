@@ -3,8 +3,8 @@ package supervisor
 import (
 	"errors"
 	"fmt"
-	"log"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -13,6 +13,11 @@ import (
 	"github.com/spf13/viper"
 )
 
+const (
+	defaultBasePort = 5000
+	defaultPortStep = 100
+)
+
 // procfileViperMutex 保护 Procfile 配置加载时的 viper 全局状态操作
 var procfileViperMutex sync.Mutex
 
@@ -22,12 +27,24 @@ type ProcfileOption struct {
 	Procfile string
 	Env      []string `yaml:",omitempty"`
 
+	// BasePort 是项目分配 PORT 的起始端口，PortStep 是每种进程之间的端口间隔
+	BasePort int `yaml:"basePort,omitempty"`
+	PortStep int `yaml:"portStep,omitempty"`
+
+	// DisableSpmEnv 为 true 时不向子进程注入 SPM_* 元数据环境变量
+	DisableSpmEnv bool `yaml:"disableSpmEnv,omitempty"`
+
+	// LogSinks 是项目中所有进程默认使用的日志输出端
+	LogSinks []*LogSinkOption `yaml:"logSinks,omitempty"`
+
 	Processes map[string]*ProcessOption `yaml:"processes,omitempty"`
 }
 
 type ProcessOption struct {
 	Cmd []string `yaml:"-"` // 配置文件里面不需要包含Cmd这个字段
-	Env []string `yaml:",omitempty"`
+	// CmdLine 是 Procfile 中的命令行，每次启动时按进程的环境变量展开，为空时直接执行 Cmd
+	CmdLine string   `yaml:"-"`
+	Env     []string `yaml:",omitempty"`
 
 	Root       string `yaml:",omitempty"`
 	PidRoot    string `yaml:"pidRoot,omitempty"`
@@ -35,6 +52,39 @@ type ProcessOption struct {
 	StopSignal string `yaml:"stopSignal,omitempty"`
 	NumProcs   int    `yaml:"numProcs,omitempty"`
 
+	// Port 覆盖自动分配的端口，为 0 时按 BasePort + 顺序 * PortStep 分配
+	Port int `yaml:"port,omitempty"`
+	// port 是进程实际使用的端口，不写入配置和 dump 文件，自动分配的端口在加载时重新计算
+	port int
+
+	DisableSpmEnv bool `yaml:"disableSpmEnv,omitempty"`
+
+	// Shell 控制命令是否通过 shell 执行：留空时自动判断，
+	// true 使用 sh，false 从不使用 shell，也可以指定 shell 的路径
+	Shell string `yaml:"shell,omitempty"`
+
+	// Listen 是由 supervisor 预先绑定并传给子进程的监听地址，
+	// 例如 tcp://0.0.0.0:8080 或 unix:///run/app.sock
+	Listen []string `yaml:"listen,omitempty"`
+
+	// Type 为 notify 时进程通过 NOTIFY_SOCKET 报告就绪状态，默认为 simple
+	Type string `yaml:"type,omitempty"`
+	// WatchdogSec 是 notify 类型进程的看门狗超时时间，超时没有收到 WATCHDOG=1 就重启进程
+	WatchdogSec int `yaml:"watchdogSec,omitempty"`
+
+	// LogBuffer 是每个输出流在内存中保留的行数，默认 200 行
+	LogBuffer int `yaml:"logBuffer,omitempty"`
+	// LogRotate 是输出日志的轮转配置，没有配置时日志文件一直追加
+	LogRotate *LogRotateOption `yaml:"logRotate,omitempty"`
+	// LogFormat 是输出日志的格式：raw、timestamped 或 json，默认为 raw
+	LogFormat string `yaml:"logFormat,omitempty"`
+	// MergeOutput 为 true 时 stdout 和 stderr 都写入 <name>_output.log
+	MergeOutput bool `yaml:"mergeOutput,omitempty"`
+	// LogSinks 是额外的日志输出端，没有配置时使用项目的 logSinks
+	LogSinks []*LogSinkOption `yaml:"logSinks,omitempty"`
+	// LogTriggers 是根据输出内容执行动作的触发器
+	LogTriggers []*LogTriggerOption `yaml:"logTriggers,omitempty"`
+
 	Order int `yaml:"-"`
 }
 
@@ -57,21 +107,19 @@ func LoadProcfileOption(cwd string, procfile string) (*ProcfileOption, error) {
 		viper.AddConfigPath("etc")
 		viper.AddConfigPath("../etc")
 	} else if err != nil {
-		log.Fatal(err)
+		return nil, err
 	} else {
 		viper.SetConfigFile(optsFile)
 	}
 
 	err = viper.ReadInConfig()
 	if err != nil && !errors.As(err, &viper.ConfigFileNotFoundError{}) {
-		log.Fatalf("Error getting config file, %s", err)
-		return nil, err
+		return nil, fmt.Errorf("error getting config file, %w", err)
 	}
 
 	err = viper.Unmarshal(&procOpts)
 	if err != nil {
-		fmt.Println("Unable to decode into struct, ", err)
-		return nil, err
+		return nil, fmt.Errorf("unable to decode %s, %w", optsFile, err)
 	}
 
 	procFileCfg, err := LoadProcfile(procOpts.Procfile)
@@ -101,6 +149,13 @@ func LoadProcfileOption(cwd string, procfile string) (*ProcfileOption, error) {
 		}
 	}
 
+	if procOpts.BasePort <= 0 {
+		procOpts.BasePort = defaultBasePort
+	}
+	if procOpts.PortStep <= 0 {
+		procOpts.PortStep = defaultPortStep
+	}
+
 	order := 0
 	for name, cmd := range procFileCfg.FromOldest() {
 		opt, ok := procOpts.Processes[name]
@@ -118,18 +173,54 @@ func LoadProcfileOption(cwd string, procfile string) (*ProcfileOption, error) {
 			opt.NumProcs = maxCpus
 		}
 
-		if opt.PidRoot == "" {
-			opt.PidRoot = config.GetRuntimeDir(cwd)
-		}
-
-		if opt.LogRoot == "" {
-			opt.LogRoot = config.GetRuntimeDir(cwd)
+		if opt.PidRoot == "" || opt.LogRoot == "" {
+			runtimeDir, err := config.RuntimeDir(cwd)
+			if err != nil {
+				return nil, err
+			}
+			if opt.PidRoot == "" {
+				opt.PidRoot = runtimeDir
+			}
+			if opt.LogRoot == "" {
+				opt.LogRoot = runtimeDir
+			}
 		}
 
 		if opt.StopSignal == "" {
 			opt.StopSignal = "INT"
 		}
 
+		if procOpts.DisableSpmEnv {
+			opt.DisableSpmEnv = true
+		}
+
+		if opt.Type == "" {
+			opt.Type = processTypeSimple
+		} else if opt.Type != processTypeSimple && opt.Type != processTypeNotify {
+			return nil, fmt.Errorf("process %s: unsupported type %q", name, opt.Type)
+		}
+
+		if opt.LogFormat == "" {
+			opt.LogFormat = logFormatRaw
+		} else if !isLogFormat(opt.LogFormat) {
+			return nil, fmt.Errorf("process %s: unsupported log format %q", name, opt.LogFormat)
+		}
+
+		if opt.LogSinks == nil {
+			opt.LogSinks = procOpts.LogSinks
+		}
+		for _, sink := range opt.LogSinks {
+			if err := sink.validate(); err != nil {
+				return nil, fmt.Errorf("process %s: %w", name, err)
+			}
+		}
+
+		for _, trigger := range opt.LogTriggers {
+			if err := trigger.validate(); err != nil {
+				return nil, fmt.Errorf("process %s: %w", name, err)
+			}
+		}
+
 		parentEnv := append(config.GetConfig().Env, procOpts.Env...)
 		if opt.Env == nil {
 			_ = copy(opt.Env, procOpts.Env)
@@ -137,14 +228,14 @@ func LoadProcfileOption(cwd string, procfile string) (*ProcfileOption, error) {
 
 		opt.Env = append(parentEnv, opt.Env...)
 
-		var args []string
-		if strings.Contains(cmd, `"`) || strings.Contains(cmd, `'`) {
-			args = []string{"sh", "-c", cmd}
-		} else {
-			args = strings.Split(cmd, " ")
+		opt.assignPort(procOpts.BasePort + order*procOpts.PortStep)
+
+		// 命令行在启动时才展开，$PORT 和其他变量的值取决于实例，这里只检查语法
+		if _, err := buildCmdLine(cmd, opt.Shell, nil); err != nil {
+			return nil, fmt.Errorf("process %s: %w", name, err)
 		}
 
-		opt.Cmd = append(opt.Cmd, args...)
+		opt.CmdLine = cmd
 		opt.Order = order
 
 		order++
@@ -154,3 +245,39 @@ func LoadProcfileOption(cwd string, procfile string) (*ProcfileOption, error) {
 
 	return procOpts, nil
 }
+
+// assignPort 确定进程实际使用的端口
+//
+// 配置的 port 优先，其次是环境变量中的 PORT，都没有时使用自动分配的端口 auto
+func (opt *ProcessOption) assignPort(auto int) {
+	if opt.Port > 0 {
+		opt.port = opt.Port
+		return
+	}
+
+	if port, ok := lookupPort(opt.Env); ok {
+		opt.port = port
+		return
+	}
+
+	opt.port = auto
+}
+
+// lookupPort 在进程自己的环境变量列表中查找 PORT，后出现的值优先
+func lookupPort(env []string) (int, bool) {
+	for i := len(env) - 1; i >= 0; i-- {
+		k, v, ok := strings.Cut(env[i], "=")
+		if !ok || k != "PORT" {
+			continue
+		}
+
+		port, err := strconv.Atoi(v)
+		if err != nil || port <= 0 {
+			return 0, false
+		}
+
+		return port, true
+	}
+
+	return 0, false
+}
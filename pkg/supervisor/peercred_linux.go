@@ -0,0 +1,31 @@
+package supervisor
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// readPeerCred 通过 SO_PEERCRED 读取 unix socket 对端进程的 uid、gid 和 pid，其他类型的连接返回 nil
+func readPeerCred(conn net.Conn) (*peerCred, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, nil
+	}
+
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err = errors.Join(err, credErr); err != nil {
+		return nil, err
+	}
+
+	return newPeerCred(int(cred.Uid), int(cred.Gid), int(cred.Pid)), nil
+}
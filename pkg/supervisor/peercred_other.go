@@ -0,0 +1,10 @@
+//go:build !linux
+
+package supervisor
+
+import "net"
+
+// readPeerCred 在不支持 SO_PEERCRED 的系统上无法识别对端身份，总是返回 nil
+func readPeerCred(conn net.Conn) (*peerCred, error) {
+	return nil, nil
+}
@@ -2,16 +2,18 @@
 package supervisor
 
 import (
-	"bufio"
 	"context"
 	"errors"
 	"fmt"
-	"io"
+	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -23,6 +25,9 @@ import (
 	"go.uber.org/zap"
 )
 
+// stopTimeout 是发送停止信号后等待进程退出的时间，超时后强制杀死进程组
+const stopTimeout = 3 * time.Second
+
 var sigTable = map[string]syscall.Signal{
 	"INT":   syscall.SIGINT,
 	"TERM":  syscall.SIGTERM,
@@ -31,17 +36,60 @@ var sigTable = map[string]syscall.Signal{
 	"ABORT": syscall.SIGABRT,
 }
 
+// signalTable 是 spm signal 可以发送的信号，包含 sigTable 之外常用于通知进程的信号
+var signalTable = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"ABRT":  syscall.SIGABRT,
+	"ABORT": syscall.SIGABRT,
+	"KILL":  syscall.SIGKILL,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"ALRM":  syscall.SIGALRM,
+	"TERM":  syscall.SIGTERM,
+	"CONT":  syscall.SIGCONT,
+	"STOP":  syscall.SIGSTOP,
+	"TSTP":  syscall.SIGTSTP,
+	"TTIN":  syscall.SIGTTIN,
+	"TTOU":  syscall.SIGTTOU,
+	"WINCH": syscall.SIGWINCH,
+}
+
+// parseSignal 把信号名解析为信号，信号名不区分大小写，可以带 SIG 前缀，也可以是信号编号
+func parseSignal(name string) (syscall.Signal, error) {
+	if num, err := strconv.Atoi(name); err == nil && num > 0 && num < 65 {
+		return syscall.Signal(num), nil
+	}
+
+	upper := strings.TrimPrefix(strings.ToUpper(name), "SIG")
+	if sig, ok := signalTable[upper]; ok {
+		return sig, nil
+	}
+
+	return 0, fmt.Errorf("unknown signal %q", name)
+}
+
 type Process struct {
+	ID       int
 	Pid      int
 	Name     string
 	FullName string
 	PidPath  string
 	OutLog   string
 	ErrLog   string
+	Instance int
+	Port     int
+	Restarts int
 	StartAt  time.Time
 	StopAt   time.Time
 	State    codec.ProcessState
 
+	// Ready 表示进程已就绪，notify 类型的进程在收到 READY=1 之后才就绪
+	Ready bool
+	// StatusText 是进程通过 sd_notify 发送的 STATUS= 文本
+	StatusText string
+
 	// 进程的配置参数，不对外暴露
 	opts *ProcessOption
 
@@ -54,11 +102,35 @@ type Process struct {
 	logger  *zap.SugaredLogger
 	signal  syscall.Signal
 	sysproc *os.Process
-	stdout  io.ReadWriteCloser
-	stderr  io.ReadWriteCloser
+	runPid  atomic.Int32 // 当前运行的进程 PID，日志 goroutine 不持有锁读取
+
+	// logDropped 是日志文件和其他输出端的队列写满或者写入失败时丢弃的日志行数
+	logDropped atomic.Uint64
+	exited     chan struct{} // 进程退出并被回收后关闭
+	stdout     logWriter
+	stderr     logWriter
+
+	// healthFailures 是看门狗超时的次数
+	healthFailures atomic.Uint64
+
+	// supervisor 持有的监听套接字，重启时保持打开
+	listeners []net.Listener
+	// sd_notify 套接字和看门狗状态，每次启动时重新创建
+	notify *notifyState
+
+	// 最近的输出和退出记录，在重启之间保留
+	outRing *logRing
+	errRing *logRing
+	history []*codec.ExitInfo
+	crashes []time.Time // 最近崩溃的时间，用于判断 crash loop
+
+	// 编译后的日志触发器
+	triggers []*logTrigger
+	drained  chan struct{} // 本次运行的日志 goroutine 都结束后关闭
 }
 
-func NewProcess(fullName string, opts *ProcessOption) *Process {
+// NewProcess 创建进程的第 instance 个实例，实例的端口在分配给进程的端口上按实例编号偏移
+func NewProcess(fullName string, opts *ProcessOption, instance int) *Process {
 	stopSignal, ok := sigTable[opts.StopSignal]
 	if !ok {
 		// 默认用SIGINT信号关闭子进程，可以平滑退出
@@ -74,22 +146,37 @@ func NewProcess(fullName string, opts *ProcessOption) *Process {
 
 	outputLogPath := fmt.Sprintf("%s/%s_output.log", logDir, name)
 	errorLogPath := fmt.Sprintf("%s/%s_error.log", logDir, name)
+	if opts.MergeOutput {
+		errorLogPath = outputLogPath
+	}
 
-	return &Process{
+	p := &Process{
 		Pid:      0,
 		Name:     name,
 		FullName: fullName,
 		OutLog:   outputLogPath,
 		ErrLog:   errorLogPath,
+		Instance: instance,
+		Port:     instancePort(opts.port, instance),
 
 		StartAt: time.Time{},
 		StopAt:  time.Time{},
 		State:   codec.ProcessStandby,
 
-		opts:   opts,
-		signal: stopSignal,
-		logger: logger.Logging(fullName),
+		opts:    opts,
+		signal:  stopSignal,
+		logger:  logger.Logging(fullName),
+		outRing: newLogRing(opts.LogBuffer),
+		errRing: newLogRing(opts.LogBuffer),
+
+		triggers: newLogTriggers(opts.LogTriggers),
+	}
+
+	if config.ForegroundFlag {
+		foreground.register(p.consoleLabel())
 	}
+
+	return p
 }
 
 func (p *Process) SetPidPath() {
@@ -158,14 +245,22 @@ func (p *Process) prepareEnvironment() error {
 		return fmt.Errorf("cannot change to working directory %s: %w", p.opts.Root, err)
 	}
 
-	outLog, err := os.OpenFile(p.OutLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	outLog, err := newLogWriter(p.OutLog, p.opts.LogRotate)
 	if err != nil {
 		p.logger.Error(err)
 		return fmt.Errorf("cannot open log files: %v", err)
 	}
 
+	// 合并输出时 stdout 和 stderr 写入同一个文件
+	if p.opts.MergeOutput {
+		shared := shareWriter(outLog, 2)
+		p.stdout = shared
+		p.stderr = shared
+		return nil
+	}
+
 	// 每次启动都打开日志文件描述符
-	errLog, err := os.OpenFile(p.ErrLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	errLog, err := newLogWriter(p.ErrLog, p.opts.LogRotate)
 	if err != nil {
 		_ = outLog.Close() // 第一个文件已打开，需要关闭防止资源泄漏
 		p.logger.Error(err)
@@ -180,7 +275,23 @@ func (p *Process) prepareEnvironment() error {
 
 // buildCommand 构建要执行的命令
 func (p *Process) buildCommand() (*exec.Cmd, error) {
+	// 子进程的环境变量，Procfile 命令行中的变量也从这里展开
+	env := slices.Clone(p.opts.Env)
+	if p.Port > 0 {
+		env = append(env, fmt.Sprintf("PORT=%d", p.Port))
+	}
+	if !p.opts.DisableSpmEnv {
+		env = append(env, p.metadataEnv()...)
+	}
+
 	task := p.opts.Cmd
+	if p.opts.CmdLine != "" {
+		var err error
+		task, err = buildCmdLine(p.opts.CmdLine, p.opts.Shell, env)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if len(task) == 0 {
 		return nil, fmt.Errorf("command is empty")
 	}
@@ -192,6 +303,11 @@ func (p *Process) buildCommand() (*exec.Cmd, error) {
 		args = task[1:]
 	}
 
+	// 结束上一次运行遗留的日志 goroutine，进程自己退出时不会调用 Stop
+	if p.cancel != nil {
+		p.cancel()
+	}
+
 	// 创建带取消功能的上下文
 	ctx, cancel := context.WithCancel(context.Background())
 	p.ctx = ctx
@@ -199,7 +315,22 @@ func (p *Process) buildCommand() (*exec.Cmd, error) {
 
 	// 构建命令
 	cmd := exec.CommandContext(p.ctx, exe, args...)
-	cmd.Env = append(cmd.Env, p.opts.Env...)
+	cmd.Env = env
+
+	notifyEnv, err := p.openNotify()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	cmd.Env = append(cmd.Env, notifyEnv...)
+
+	if err := p.activate(cmd); err != nil {
+		cancel()
+		p.mu.Lock()
+		p.closeNotify()
+		p.mu.Unlock()
+		return nil, fmt.Errorf("cannot pass listen sockets: %w", err)
+	}
 
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: true,
@@ -208,33 +339,33 @@ func (p *Process) buildCommand() (*exec.Cmd, error) {
 	return cmd, nil
 }
 
-// setupStreams 设置标准输出和错误输出的管道，并启动日志监控
-func (p *Process) setupStreams(cmd *exec.Cmd) error {
-	// 创建标准输出管道
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stdout pipe: %w", err)
-	}
+// metadataEnv 返回注入到子进程中的 SPM_* 元数据环境变量
+func (p *Process) metadataEnv() []string {
+	project := strings.Split(p.FullName, "::")[0]
 
-	// 创建标准错误管道
-	stderrPipe, err := cmd.StderrPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stderr pipe: %w", err)
+	return []string{
+		"SPM_PROJECT=" + project,
+		"SPM_PROCESS=" + p.Name,
+		"SPM_FULL_NAME=" + p.FullName,
+		"SPM_INSTANCE=" + strconv.Itoa(p.Instance),
+		"SPM_ID=" + strconv.Itoa(p.ID),
+		"SPM_LOG_DIR=" + filepath.Dir(p.OutLog),
+		"SPM_SOCKET=" + config.GetConfig().Socket,
+		"SPM_RESTART_COUNT=" + strconv.Itoa(p.Restarts),
 	}
-
-	// 启动日志监控 goroutine
-	p.wg.Add(2)
-	go p.watchLog("STDOUT", stdoutPipe)
-	go p.watchLog("STDERR", stderrPipe)
-
-	return nil
 }
 
 // launchProcess 启动进程并记录状态
 func (p *Process) launchProcess(cmd *exec.Cmd) error {
-	// 启动进程
-	if err := cmd.Start(); err != nil {
+	// 启动进程，传给子进程的监听套接字副本在父进程中不再需要
+	err := cmd.Start()
+	closeFiles(cmd.ExtraFiles)
+	closePipeWriters(cmd)
+	if err != nil {
+		p.mu.Lock()
+		p.closeNotify()
 		p.State = codec.ProcessFailed
+		p.mu.Unlock()
 		return fmt.Errorf("failed to start process: %w", err)
 	}
 
@@ -243,7 +374,9 @@ func (p *Process) launchProcess(cmd *exec.Cmd) error {
 	defer p.mu.Unlock()
 
 	p.Pid = cmd.Process.Pid
+	p.runPid.Store(int32(p.Pid))
 	p.sysproc = cmd.Process
+	p.exited = make(chan struct{})
 	p.StartAt = time.Now()
 	p.StopAt = time.Time{}
 	p.State = codec.ProcessRunning
@@ -257,27 +390,66 @@ func (p *Process) launchProcess(cmd *exec.Cmd) error {
 }
 
 // monitorProcess 在goroutine中监控进程，等待其结束并处理退出状态
-func (p *Process) monitorProcess(cmd *exec.Cmd) {
+func (p *Process) monitorProcess(cmd *exec.Cmd, exited, drained chan struct{}, startAt time.Time) {
 	err := cmd.Wait()
+
+	var status *syscall.WaitStatus
 	if err != nil {
 		var exitErr *exec.ExitError
 		if !errors.As(err, &exitErr) {
 			p.logger.Error(err)
 		} else {
 			ws := exitErr.Sys().(syscall.WaitStatus)
+			status = &ws
 			if ws.Signaled() {
-				p.logger.Infof("%v process %s ", p.signal, p.Name)
+				p.logger.Infof("%v process %s ", ws.Signal(), p.Name)
 			} else {
 				p.logger.Infof("process %s exited with code=%d", p.Name, ws.ExitStatus())
 			}
 		}
 	}
 
+	// 在关闭 exited 之前发布事件，Stop 返回时退出事件已经发布
+	code, signal := exitStatus(status)
+	p.publish(&codec.Event{
+		Type:     codec.EventExited,
+		Pid:      cmd.Process.Pid,
+		ExitCode: code,
+		Signal:   signal,
+	})
+	close(exited)
+
+	// 等待管道中剩余的输出写入缓冲区，退出记录里才有最后几行
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+	}
+
 	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info := p.recordExit(cmd.Process.Pid, startAt, status)
+
+	// 进程已经被 Stop 处理过，或者已经启动了新的进程实例
+	if p.sysproc != cmd.Process || p.State == codec.ProcessStopped {
+		return
+	}
+
+	// 通过 MAINPID 或 PID 文件切换了主进程，主进程仍然存活时继续跟踪它
+	if p.Pid > 0 && p.Pid != cmd.Process.Pid && syscall.Kill(p.Pid, 0) == nil {
+		p.logger.Infof("Main process %d of %s is still alive", p.Pid, p.Name)
+		return
+	}
+
 	p.onStop()
 	p.StopAt = time.Now()
 	p.State = codec.ProcessStopped
-	p.mu.Unlock()
+
+	// 自己以非 0 退出码退出或者被信号杀死才算崩溃
+	if info.ExitCode != 0 {
+		p.recordCrash(info)
+	}
+	p.reportFailure(info.ExitCode)
 }
 
 func (p *Process) Start() bool {
@@ -291,36 +463,66 @@ func (p *Process) Start() bool {
 		return false
 	}
 
-	// 准备环境（日志文件和工作目录）
-	if err := p.prepareEnvironment(); err != nil {
+	p.publishType(codec.EventStarting)
+
+	if err := p.launch(); err != nil {
 		p.logger.Error(err)
+		p.publish(&codec.Event{Type: codec.EventFailed, Message: err.Error()})
 		return false
 	}
 
+	p.logger.Infof("Process %s is started", p.Name)
+	p.publishType(codec.EventRunning)
+
+	p.mu.Lock()
+	ready := p.Ready
+	p.mu.Unlock()
+
+	if ready {
+		p.publishType(codec.EventReady)
+	}
+
+	return true
+}
+
+// launch 准备运行环境并启动进程，启动成功后在后台监控进程
+func (p *Process) launch() error {
+	// 检查分配给这个实例的端口是否被其他程序占用，使用套接字激活时端口由 supervisor 持有
+	if len(p.opts.Listen) == 0 {
+		if err := checkPort(p.Port); err != nil {
+			p.State = codec.ProcessFailed
+			return err
+		}
+	} else if err := p.openListeners(); err != nil {
+		p.State = codec.ProcessFailed
+		return err
+	}
+
+	// 准备环境（日志文件和工作目录）
+	if err := p.prepareEnvironment(); err != nil {
+		return err
+	}
+
 	// 构建命令
 	cmd, err := p.buildCommand()
 	if err != nil {
-		p.logger.Error(err)
-		return false
+		return err
 	}
 
 	// 设置输出流管道
 	if err := p.setupStreams(cmd); err != nil {
-		p.logger.Error(err)
-		return false
+		return err
 	}
 
 	// 启动进程
 	if err := p.launchProcess(cmd); err != nil {
-		p.logger.Error(err)
-		return false
+		return err
 	}
 
 	// 在后台监控进程
-	go p.monitorProcess(cmd)
+	go p.monitorProcess(cmd, p.exited, p.drained, p.StartAt)
 
-	p.logger.Infof("Process %s is started", p.Name)
-	return true
+	return nil
 }
 
 func (p *Process) Stop() bool {
@@ -334,41 +536,37 @@ func (p *Process) Stop() bool {
 	switch p.State {
 	case codec.ProcessRunning:
 		{
-			if p.cancel != nil {
-				p.cancel()
-
-				p.wg.Wait()
-			}
-
 			p.State = codec.ProcessStopping
-
-			timer := time.NewTimer(3 * time.Second)
-			select {
-			case <-p.ctx.Done():
-				p.logger.Infof("Process %s exited gracefully", p.Name)
-			case <-timer.C:
-				p.logger.Warnf("Process %s exited timeout", p.Name)
+			p.publish(&codec.Event{Type: codec.EventStopping, Pid: p.Pid})
+
+			// 先发送配置的停止信号，给进程组优雅退出的机会
+			p.logger.Infof("Sending %s to PID %d", p.opts.StopSignal, p.Pid)
+			err := syscall.Kill(-p.Pid, p.signal)
+			if errors.Is(err, syscall.ESRCH) {
+				// MAINPID 指向的进程不一定是进程组的组长
+				err = syscall.Kill(p.Pid, p.signal)
 			}
-
-			err := p.ctx.Err()
-			if err != nil && !errors.Is(err, context.Canceled) {
+			if err != nil && !errors.Is(err, syscall.ESRCH) {
 				p.logger.Error(err)
-			} else {
-				p.logger.Infof("Sending %s to PID %d", p.opts.StopSignal, p.Pid)
-				err = syscall.Kill(-p.Pid, p.signal)
-				if err != nil && !errors.Is(err, os.ErrProcessDone) {
-					p.logger.Error(err)
-				} else {
-					err = nil
-				}
 			}
 
-			if err != nil {
-				p.logger.Warnf("Force kill process %s", p.Name)
+			if p.waitExit(stopTimeout) {
+				p.logger.Infof("Process %s exited gracefully", p.Name)
+			} else {
+				p.logger.Warnf("Process %s exited timeout. Force kill process", p.Name)
 				_ = syscall.Kill(-p.Pid, syscall.SIGKILL)
+				_ = syscall.Kill(p.Pid, syscall.SIGKILL)
+				_ = p.waitExit(stopTimeout)
+			}
+
+			// 进程退出后结束日志监控 goroutine
+			if p.cancel != nil {
+				p.cancel()
+				p.wg.Wait()
 			}
 
 			p.State = codec.ProcessStopped
+			p.StopAt = time.Now()
 			p.onStop()
 		}
 	case codec.ProcessStopped:
@@ -380,7 +578,43 @@ func (p *Process) Stop() bool {
 	return p.State == codec.ProcessStopped
 }
 
+// waitExit 等待进程退出并被回收，超时返回 false
+func (p *Process) waitExit(timeout time.Duration) bool {
+	if p.exited == nil {
+		return true
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-p.exited:
+	case <-timer.C:
+		return false
+	}
+
+	// 主进程不是直接启动的子进程时，只能轮询它是否还存活
+	if p.sysproc == nil || p.Pid == p.sysproc.Pid {
+		return true
+	}
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for syscall.Kill(p.Pid, 0) == nil {
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			return false
+		}
+	}
+
+	return true
+}
+
 func (p *Process) Restart() bool {
+	p.publishType(codec.EventRestarting)
+
 	_ = p.updatePid()
 	if p.IsRunning() {
 		_ = p.Stop()
@@ -393,11 +627,32 @@ func (p *Process) Restart() bool {
 
 	p.mu.Lock()
 	p.Pid = 0
+	p.sysproc = nil
+	p.Restarts++
 	p.mu.Unlock()
 
 	return p.Start()
 }
 
+// Signal 向运行中的进程组发送信号，进程没有运行时返回错误
+func (p *Process) Signal(sig syscall.Signal) error {
+	if !p.IsRunning() {
+		return fmt.Errorf("process %s is not running", p.FullName)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.logger.Infof("Sending %s to PID %d", sig, p.Pid)
+	err := syscall.Kill(-p.Pid, sig)
+	if errors.Is(err, syscall.ESRCH) {
+		// MAINPID 指向的进程不一定是进程组的组长
+		err = syscall.Kill(p.Pid, sig)
+	}
+
+	return err
+}
+
 func (p *Process) updatePid() bool {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -428,6 +683,8 @@ func (p *Process) updatePid() bool {
 
 func (p *Process) onStop() {
 	p.StartAt = time.Time{}
+	p.Ready = false
+	p.closeNotify()
 
 	err := os.Remove(p.PidPath)
 	if err != nil {
@@ -439,35 +696,25 @@ func (p *Process) onStop() {
 	}
 }
 
-func (p *Process) watchLog(logtype string, r io.ReadCloser) {
-	defer p.wg.Done()
-
-	tty := os.Stdout
-	dest := p.stdout
-	if logtype == "STDERR" {
-		dest = p.stderr
-		tty = os.Stderr
+// instancePort 计算进程第 instance 个实例使用的端口，base 为 0 表示不分配端口
+func instancePort(base int, instance int) int {
+	if base <= 0 {
+		return 0
 	}
 
-	defer func() {
-		if err := dest.Close(); err != nil {
-			p.logger.Warnf("%s log file close error: %v", logtype, err)
-		}
-		p.logger.Infof("%s logging finished", logtype)
-	}()
-
-	tee := io.TeeReader(r, dest)
+	return base + instance - 1
+}
 
-	scanner := bufio.NewScanner(tee)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if config.ForegroundFlag {
-			_, _ = fmt.Fprintf(tty, "%s\n", line)
-		}
+// checkPort 检查端口是否可用
+func checkPort(port int) error {
+	if port <= 0 {
+		return nil
 	}
 
-	err := scanner.Err()
-	if err != nil && !errors.Is(err, os.ErrClosed) {
-		p.logger.Error(err)
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("port %d is not available: %w", port, err)
 	}
+
+	return ln.Close()
 }
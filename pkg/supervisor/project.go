@@ -16,6 +16,8 @@ type Project struct {
 	Name      string
 	WorkDir   string
 	Procfile  string
+	BasePort  int
+	PortStep  int
 	procTable *ProcTable
 
 	running map[string]bool
@@ -27,7 +29,8 @@ func (p *Project) Register(name string, opt *ProcessOption) *Process {
 	}
 
 	fullName := fmt.Sprintf("%s::%s", p.Name, name)
-	proc := NewProcess(fullName, opt)
+	// 每个 Procfile 条目运行一个实例
+	proc := NewProcess(fullName, opt, 1)
 	proc.SetPidPath()
 
 	p.procTable.Set(name, proc)
@@ -105,6 +108,8 @@ func CreateProject(opt *ProcfileOption) *Project {
 		Name:     opt.AppName,
 		WorkDir:  opt.WorkDir,
 		Procfile: opt.Procfile,
+		BasePort: opt.BasePort,
+		PortStep: opt.PortStep,
 		running:  runningTab,
 
 		procTable: NewProcTable(),
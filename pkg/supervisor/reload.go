@@ -41,6 +41,7 @@ func (sv *Supervisor) Reload(changed []*Process) []*codec.ProcInfo {
 
 	if len(changed) > 0 {
 		for _, p := range changed {
+			p.publishType(codec.EventReloaded)
 			pInfo = append(pInfo, &codec.ProcInfo{
 				Pid:     p.Pid,
 				Name:    p.FullName,
@@ -0,0 +1,176 @@
+// 使用双向 TLS 认证的远程控制
+//
+// daemon 在全局配置的 remote 部分开启后监听一个 TCP 地址，连接上使用与控制 socket 相同的
+// 握手和帧协议。daemon 只接受配置的 CA 签发的客户端证书，客户端证书的 CN 作为对端身份
+// 按 acl 规则检查请求；客户端同样用 CA 验证 daemon 的证书。没有在 acl 的 certs 中列出证书时不开启远程控制。
+//
+// 客户端通过 --host 参数或者环境变量 SPM_HOST 指定远程 daemon 的地址。
+
+package supervisor
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"time"
+
+	"spm/pkg/codec"
+	"spm/pkg/config"
+)
+
+// remoteHandshakeTimeout 是 TLS 握手的超时时间
+const remoteHandshakeTimeout = 10 * time.Second
+
+// remoteTLSConfig 根据 remote 配置创建 TLS 配置
+//
+// 参数：
+//
+//	server: 为 true 时创建 daemon 使用的配置，要求并验证客户端证书；
+//	  否则创建客户端使用的配置，验证 daemon 的证书
+//	host: 客户端连接的地址，用于确定验证服务端证书时的名称
+//
+// 返回：
+//
+//	*tls.Config: TLS 配置
+//	error: 证书文件无法读取或者没有配置时返回错误
+func remoteTLSConfig(server bool, host string) (*tls.Config, error) {
+	cfg := config.GetConfig().Remote
+	if cfg.CA == "" || cfg.Cert == "" || cfg.Key == "" {
+		return nil, errors.New("remote control requires remote.ca, remote.cert and remote.key in config")
+	}
+
+	ca, err := os.ReadFile(cfg.CA)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in %s", cfg.CA)
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	tc := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if server {
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+		return tc, nil
+	}
+
+	tc.RootCAs = pool
+	tc.ServerName = cfg.ServerName
+	if tc.ServerName == "" {
+		name, _, err := net.SplitHostPort(host)
+		if err != nil {
+			return nil, err
+		}
+		tc.ServerName = name
+	}
+
+	return tc, nil
+}
+
+// dialRemote 使用客户端证书连接远程 daemon
+func dialRemote(host string) (net.Conn, error) {
+	tc, err := remoteTLSConfig(false, host)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: remoteHandshakeTimeout},
+		Config:    tc,
+	}
+
+	return dialer.Dial("tcp", host)
+}
+
+// startRemote 在配置开启时监听远程控制的 TCP 地址，返回的监听器在 daemon 退出时关闭
+func (sv *Supervisor) startRemote() net.Listener {
+	cfg := config.GetConfig().Remote
+	if !cfg.Enabled {
+		return nil
+	}
+
+	// 没有 certs 规则时远程连接不能执行任何请求，提前报错而不是接受无用的连接
+	if !slices.ContainsFunc(config.GetConfig().ACL, func(rule *config.ACLRule) bool {
+		return len(rule.Certs) > 0
+	}) {
+		sv.logger.Errorf("Cannot start remote control: no acl rule lists client certificates in certs")
+		return nil
+	}
+
+	tc, err := remoteTLSConfig(true, "")
+	if err != nil {
+		sv.logger.Errorf("Cannot start remote control: %v", err)
+		return nil
+	}
+
+	ln, err := tls.Listen("tcp", cfg.Listen, tc)
+	if err != nil {
+		sv.logger.Errorf("Cannot start remote control: %v", err)
+		return nil
+	}
+
+	go sv.serveRemote(ln)
+	sv.logger.Infof("Remote control is listening on %s", cfg.Listen)
+
+	return ln
+}
+
+// serveRemote 接受远程连接，直到监听器被关闭
+func (sv *Supervisor) serveRemote(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				sv.logger.Error(err)
+			}
+			return
+		}
+
+		go sv.handleRemote(conn.(*tls.Conn))
+	}
+}
+
+// handleRemote 完成 TLS 握手后以客户端证书的 CN 作为身份处理连接上的请求
+//
+// 注意事项：
+//
+//	远程客户端无法向 daemon 发送信号，收到 shutdown 请求后由 daemon 自己退出
+func (sv *Supervisor) handleRemote(conn *tls.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteHandshakeTimeout)
+	err := conn.HandshakeContext(ctx)
+	cancel()
+	if err != nil {
+		sv.logger.Warnf("TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return
+	}
+
+	// 握手时已经验证了客户端证书，第一个证书就是客户端自己的证书
+	cn := conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	if cn == "" {
+		sv.logger.Warnf("Client certificate from %s has no common name", conn.RemoteAddr())
+		_ = conn.Close()
+		return
+	}
+
+	peer := newCertPeer(cn, conn.RemoteAddr().String())
+	if result := NewSession(sv, conn, peer).Handle(); result == codec.ResponseShutdown {
+		sv.requestStop()
+	}
+}
@@ -0,0 +1,199 @@
+// 进程输出的内存环形缓冲区
+//
+// 每个进程在内存中保留 stdout 和 stderr 最近的若干行输出，
+// spm logs 不需要再读取日志文件，进程退出时也能直接拿到最后的输出。
+
+package supervisor
+
+import (
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"spm/pkg/codec"
+)
+
+const (
+	defaultLogBuffer = 200 // 每个输出流默认保留的行数
+	exitTailLines    = 20  // 退出记录中保存的输出行数
+	maxExitHistory   = 10  // 每个进程保留的退出记录数
+)
+
+// logRing 是固定容量的日志行环形缓冲区，写满之后覆盖最旧的行
+//
+// 每行按加入的顺序从 1 开始编号，added 是最新一行的序号，written 是最后交给日志文件的行的序号。
+// 日志文件的写入在持有 mu 时进行，持有 mu 时序号不超过 written 的行都已经写入或者被丢弃，
+// spm logs -f 据此让内存中的行和文件的读取位置保持一致。
+type logRing struct {
+	mu      sync.Mutex
+	lines   []*codec.LogLine
+	next    int
+	full    bool
+	added   uint64
+	written uint64
+}
+
+func newLogRing(size int) *logRing {
+	if size <= 0 {
+		size = defaultLogBuffer
+	}
+
+	return &logRing{lines: make([]*codec.LogLine, size)}
+}
+
+// Add 追加一行日志，返回这一行的序号
+func (r *logRing) Add(line *codec.LogLine) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+	r.added++
+
+	return r.added
+}
+
+// writeFile 在持有锁时把序号为 seq 的行写入日志文件，写入失败的行同样计入 written
+//
+// 日志文件的队列写满时丢弃的行没有写入，之后的行写入时一起计入 written
+func (r *logRing) writeFile(seq uint64, write func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.written = seq
+	return write()
+}
+
+// Len 返回缓冲区中的行数
+func (r *logRing) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.full {
+		return len(r.lines)
+	}
+	return r.next
+}
+
+// Tail 按写入顺序返回最后 n 行，n <= 0 时返回全部
+func (r *logRing) Tail(n int) []*codec.LogLine {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.tail(n, 0)
+}
+
+// tailWritten 返回已经写入日志文件的最后 n 行，调用方需要持有 r.mu
+func (r *logRing) tailWritten(n int) []*codec.LogLine {
+	return r.tail(n, int(r.added-r.written))
+}
+
+// tail 跳过最新的 skip 行之后返回最后 n 行，调用方需要持有 r.mu
+func (r *logRing) tail(n int, skip int) []*codec.LogLine {
+	size := r.next
+	if r.full {
+		size = len(r.lines)
+	}
+	size -= min(skip, size)
+	if n <= 0 || n > size {
+		n = size
+	}
+
+	out := make([]*codec.LogLine, 0, n)
+	for i := size - n; i < size; i++ {
+		idx := i
+		if r.full {
+			idx = (r.next + i) % len(r.lines)
+		}
+		out = append(out, r.lines[idx])
+	}
+
+	return out
+}
+
+// ring 返回指定输出流的环形缓冲区
+func (p *Process) ring(stream string) *logRing {
+	if stream == streamStderr {
+		return p.errRing
+	}
+	return p.outRing
+}
+
+// recentOutput 返回 stdout 和 stderr 合并后按时间排序的最后 n 行
+func (p *Process) recentOutput(n int) []*codec.LogLine {
+	return mergeRecent(p.outRing.Tail(n), p.errRing.Tail(n), n)
+}
+
+// mergeRecent 把两个输出流的行按时间排序后返回最后 n 行
+func mergeRecent(out, err []*codec.LogLine, n int) []*codec.LogLine {
+	lines := append(out, err...)
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].Time.Before(lines[j].Time)
+	})
+
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	return lines
+}
+
+// recordExit 记录一次进程退出，调用方需要持有 p.mu
+func (p *Process) recordExit(pid int, startAt time.Time, ws *syscall.WaitStatus) *codec.ExitInfo {
+	info := &codec.ExitInfo{
+		Pid:     pid,
+		StartAt: startAt,
+		StopAt:  time.Now(),
+	}
+
+	info.ExitCode, info.Signal = exitStatus(ws)
+
+	for _, line := range p.recentOutput(exitTailLines) {
+		info.Tail = append(info.Tail, line.Line)
+	}
+
+	p.history = append(p.history, info)
+	if len(p.history) > maxExitHistory {
+		p.history = p.history[len(p.history)-maxExitHistory:]
+	}
+
+	return info
+}
+
+// exitStatus 返回进程的退出码和杀死进程的信号，被信号杀死时退出码为 128 + 信号编号
+func exitStatus(ws *syscall.WaitStatus) (int, string) {
+	if ws == nil {
+		return 0, ""
+	}
+
+	if ws.Signaled() {
+		return 128 + int(ws.Signal()), ws.Signal().String()
+	}
+
+	return ws.ExitStatus(), ""
+}
+
+// exitHistory 返回退出记录的副本，最新的记录在最后，只有最新的记录带有输出
+func (p *Process) exitHistory() []*codec.ExitInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.history) == 0 {
+		return nil
+	}
+
+	history := make([]*codec.ExitInfo, len(p.history))
+	for i, h := range p.history {
+		item := *h
+		if i < len(p.history)-1 {
+			item.Tail = nil
+		}
+		history[i] = &item
+	}
+
+	return history
+}
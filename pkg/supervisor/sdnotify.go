@@ -0,0 +1,278 @@
+// systemd sd_notify 协议的支持
+//
+// type 为 notify 的进程会得到一个由 supervisor 持有的 unix 数据报套接字，
+// 路径通过 NOTIFY_SOCKET 传给子进程。支持的消息：
+//   - READY=1：标记进程已就绪
+//   - STATUS=...：进程自定义的状态文本，显示在 spm status 中
+//   - WATCHDOG=1：看门狗心跳，超过 watchdogSec 没有收到时重启进程
+//   - WATCHDOG=trigger：进程主动要求触发看门狗
+//   - MAINPID=...：更新 supervisor 跟踪的主进程 PID，只接受进程自己的进程组或者后代进程
+
+package supervisor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"spm/pkg/codec"
+	"spm/pkg/utils/constants"
+)
+
+const (
+	processTypeSimple = "simple"
+	processTypeNotify = "notify"
+)
+
+// maxNotifyMessage 是单个 sd_notify 数据报的最大长度
+const maxNotifyMessage = 4096
+
+// maxUnixPath 是 sockaddr_un 中路径的最大长度
+const maxUnixPath = 107
+
+// notifyState 保存单次运行期间 sd_notify 相关的状态
+type notifyState struct {
+	conn     *net.UnixConn
+	path     string
+	interval time.Duration
+	lastBeat time.Time
+	done     chan struct{}
+}
+
+// notifySocketPath 返回进程 NOTIFY_SOCKET 的路径，过长时使用进程名的哈希值
+func notifySocketPath(fullName string) string {
+	dir := filepath.Join(constants.SpmHome, "notify")
+	name := strings.NewReplacer("::", "-", "/", "_").Replace(fullName)
+
+	path := filepath.Join(dir, name+".sock")
+	if len(path) > maxUnixPath {
+		h := sha256.Sum256([]byte(fullName))
+		path = filepath.Join(dir, hex.EncodeToString(h[:8])+".sock")
+	}
+
+	return path
+}
+
+// openNotify 为 notify 类型的进程创建 NOTIFY_SOCKET 并启动看门狗
+//
+// 返回：
+//
+//	[]string: 需要传给子进程的环境变量
+//	error: 创建套接字失败时返回错误
+func (p *Process) openNotify() ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.opts.Type != processTypeNotify {
+		p.Ready = !p.waitReadyTrigger()
+		return nil, nil
+	}
+
+	path := notifySocketPath(p.FullName)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	_ = os.Remove(path)
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("cannot create notify socket: %w", err)
+	}
+
+	ns := &notifyState{
+		conn:     conn,
+		path:     path,
+		interval: time.Duration(p.opts.WatchdogSec) * time.Second,
+		lastBeat: time.Now(),
+		done:     make(chan struct{}),
+	}
+
+	p.notify = ns
+	p.Ready = false
+	p.StatusText = ""
+
+	go p.readNotify(ns)
+
+	env := []string{"NOTIFY_SOCKET=" + path}
+	if ns.interval > 0 {
+		env = append(env, "WATCHDOG_USEC="+strconv.FormatInt(ns.interval.Microseconds(), 10))
+		go p.watchdog(ns)
+	}
+
+	return env, nil
+}
+
+// closeNotify 关闭 NOTIFY_SOCKET 并停止看门狗，调用方需要持有 p.mu
+func (p *Process) closeNotify() {
+	if p.notify == nil {
+		return
+	}
+
+	close(p.notify.done)
+	_ = p.notify.conn.Close()
+	_ = os.Remove(p.notify.path)
+	p.notify = nil
+}
+
+// readNotify 接收并处理子进程发来的 sd_notify 消息
+func (p *Process) readNotify(ns *notifyState) {
+	buf := make([]byte, maxNotifyMessage)
+
+	for {
+		n, _, err := ns.conn.ReadFromUnix(buf)
+		if err != nil {
+			select {
+			case <-ns.done:
+			default:
+				p.logger.Error(err)
+			}
+			return
+		}
+
+		p.handleNotify(ns, string(buf[:n]))
+	}
+}
+
+func (p *Process) handleNotify(ns *notifyState, msg string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// 套接字已经属于新的一次运行，忽略旧消息
+	if p.notify != ns {
+		return
+	}
+
+	for line := range strings.SplitSeq(msg, "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "READY":
+			if value == "1" && !p.Ready {
+				p.Ready = true
+				p.logger.Infof("Process %s is ready", p.Name)
+				p.publishType(codec.EventReady)
+			}
+		case "STATUS":
+			p.StatusText = value
+		case "STOPPING":
+			p.logger.Infof("Process %s is stopping", p.Name)
+		case "WATCHDOG":
+			switch value {
+			case "1":
+				ns.lastBeat = time.Now()
+			case "trigger":
+				ns.lastBeat = time.Time{}
+			}
+		case "WATCHDOG_USEC":
+			if usec, err := strconv.ParseInt(value, 10, 64); err == nil && usec > 0 {
+				ns.interval = time.Duration(usec) * time.Microsecond
+			}
+		case "MAINPID":
+			pid, err := strconv.Atoi(value)
+			if err != nil || pid <= 0 || pid == p.Pid {
+				continue
+			}
+			if p.sysproc == nil || !isMainPidOf(pid, p.sysproc.Pid) {
+				p.logger.Warnf("Process %s sent MAINPID=%d which is not one of its processes. Ignored", p.Name, pid)
+				continue
+			}
+
+			p.Pid = pid
+			if err := os.WriteFile(p.PidPath, []byte(value), 0644); err != nil {
+				p.logger.Error(err)
+			}
+			p.logger.Debugf("MAINPID changed. Updated pid to %d", p.Pid)
+		}
+	}
+}
+
+// maxMainPidDepth 是沿父进程向上查找 MAINPID 时最多检查的层数
+const maxMainPidDepth = 64
+
+// isMainPidOf 判断 pid 是否可以作为 root 启动的进程的主进程
+//
+// 进程启动时使用了 Setpgid，pid 必须在 root 的进程组中或者是 root 的后代进程，
+// 不接受 init 和 daemon 自己，避免进程把 supervisor 的信号引向无关的进程
+func isMainPidOf(pid, root int) bool {
+	if pid <= 1 || pid == os.Getpid() {
+		return false
+	}
+
+	for range maxMainPidDepth {
+		ppid, pgrp, err := readProcParent(pid)
+		if err != nil {
+			return false
+		}
+		if pgrp == root || ppid == root {
+			return true
+		}
+		if ppid <= 1 {
+			return false
+		}
+		pid = ppid
+	}
+
+	return false
+}
+
+// readProcParent 从 /proc/<pid>/stat 读取进程的父进程和进程组
+func readProcParent(pid int) (ppid, pgrp int, err error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// 进程名可能包含空格和括号，从最后一个右括号之后开始解析，依次是 state、ppid、pgrp
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return 0, 0, fmt.Errorf("invalid stat of pid %d", pid)
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 3 {
+		return 0, 0, fmt.Errorf("invalid stat of pid %d", pid)
+	}
+
+	if ppid, err = strconv.Atoi(fields[1]); err != nil {
+		return 0, 0, err
+	}
+	if pgrp, err = strconv.Atoi(fields[2]); err != nil {
+		return 0, 0, err
+	}
+
+	return ppid, pgrp, nil
+}
+
+// watchdog 定期检查心跳，超时后重启进程
+func (p *Process) watchdog(ns *notifyState) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ns.done:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			expired := p.notify == ns && ns.interval > 0 && time.Since(ns.lastBeat) > ns.interval
+			p.mu.Unlock()
+
+			if expired {
+				p.logger.Warnf("Watchdog timeout for process %s. Restarting it", p.Name)
+				p.healthFailures.Add(1)
+				p.publish(&codec.Event{Type: codec.EventHealthFailed, Message: "watchdog timeout"})
+				p.requestRestart()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,320 @@
+// Procfile 命令行的分词和变量展开功能
+
+package supervisor
+
+import (
+	"errors"
+	"strings"
+)
+
+var errUnterminatedQuote = errors.New("unterminated quote in command")
+
+// shellMode 表示命令是否需要交给 shell 执行
+type shellMode int
+
+const (
+	shellAuto   shellMode = iota // 只有出现管道、重定向等 shell 语法时才使用 shell
+	shellNever                   // 从不使用 shell，特殊字符按字面量处理
+	shellAlways                  // 总是使用 shell 执行
+)
+
+const defaultShell = "sh"
+
+// parseShellOption 解析 Procfile.options 中的 shell 字段
+//
+// 支持的取值：
+//   - 空值或 auto：自动判断
+//   - true/yes/on/1：使用默认的 sh
+//   - false/no/off/0：从不使用 shell
+//   - 其他值：作为 shell 的路径，例如 /bin/bash
+func parseShellOption(value string) (shellMode, string) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "auto":
+		return shellAuto, defaultShell
+	case "true", "yes", "on", "1":
+		return shellAlways, defaultShell
+	case "false", "no", "off", "0":
+		return shellNever, ""
+	default:
+		return shellAlways, strings.TrimSpace(value)
+	}
+}
+
+// buildCmdLine 根据 shell 选项把 Procfile 中的命令转换成参数列表
+//
+// 参数：
+//
+//	line: Procfile 中的命令行
+//	shell: Procfile.options 中的 shell 字段
+//	env: 子进程实际得到的环境变量，用于展开 $VAR 和 ${VAR}
+//
+// 返回：
+//
+//	[]string: exec 使用的参数列表
+//	error: 命令为空或者引号不匹配时返回错误
+func buildCmdLine(line string, shell string, env []string) ([]string, error) {
+	mode, sh := parseShellOption(shell)
+	if mode == shellAlways {
+		return []string{sh, "-c", line}, nil
+	}
+
+	args, needShell, err := splitCommand(line, envLookup(env), mode == shellNever)
+	if err != nil {
+		return nil, err
+	}
+
+	if needShell {
+		return []string{sh, "-c", line}, nil
+	}
+
+	if len(args) == 0 {
+		return nil, errors.New("command is empty")
+	}
+
+	return args, nil
+}
+
+// envLookup 返回一个在 env 列表中查找变量的函数
+// 后出现的同名变量覆盖先出现的
+//
+// 注意事项：
+//
+//	env 是传给子进程的环境变量，包括配置中的变量、PORT 和 SPM_* 元数据，
+//	不回退到 supervisor 自身的环境变量，展开的结果与子进程看到的值一致
+func envLookup(env []string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		for i := len(env) - 1; i >= 0; i-- {
+			k, v, ok := strings.Cut(env[i], "=")
+			if ok && k == name {
+				return v, true
+			}
+		}
+
+		return "", false
+	}
+}
+
+// splitCommand 按照 POSIX shell 的分词规则拆分命令行
+//
+// 支持单引号、双引号、反斜杠转义，以及 $NAME、${NAME}、${NAME:-default}
+// 形式的变量展开。未加引号的变量展开结果会按空白字符再次分词。
+//
+// 当 literal 为 false 时，遇到管道、重定向、命令替换、通配符等
+// 无法在这里正确处理的语法，返回 needShell = true，由调用方改用 shell 执行。
+// 当 literal 为 true 时，这些字符都按字面量处理。
+func splitCommand(line string, lookup func(string) (string, bool), literal bool) (args []string, needShell bool, err error) {
+	var word strings.Builder
+	inWord := false
+
+	flush := func() {
+		if inWord {
+			args = append(args, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			flush()
+
+		case c == '\\':
+			if i+1 < len(runes) {
+				i++
+				if runes[i] != '\n' {
+					word.WriteRune(runes[i])
+					inWord = true
+				}
+			}
+
+		case c == '\'':
+			end := indexRune(runes, i+1, '\'')
+			if end < 0 {
+				return nil, false, errUnterminatedQuote
+			}
+			word.WriteString(string(runes[i+1 : end]))
+			inWord = true
+			i = end
+
+		case c == '"':
+			inWord = true
+			closed := false
+			for i++; i < len(runes); i++ {
+				c = runes[i]
+				if c == '"' {
+					closed = true
+					break
+				}
+
+				if c == '\\' && i+1 < len(runes) && strings.ContainsRune("$`\"\\\n", runes[i+1]) {
+					i++
+					if runes[i] != '\n' {
+						word.WriteRune(runes[i])
+					}
+					continue
+				}
+
+				if c == '`' && !literal {
+					return nil, true, nil
+				}
+
+				if c == '$' {
+					value, next, ok := expandVar(runes, i, lookup)
+					if !ok {
+						if !literal {
+							return nil, true, nil
+						}
+						word.WriteRune(c)
+						continue
+					}
+					word.WriteString(value)
+					i = next - 1
+					continue
+				}
+
+				word.WriteRune(c)
+			}
+
+			if !closed {
+				return nil, false, errUnterminatedQuote
+			}
+
+		case c == '$':
+			value, next, ok := expandVar(runes, i, lookup)
+			if !ok {
+				if !literal {
+					return nil, true, nil
+				}
+				word.WriteRune(c)
+				inWord = true
+				continue
+			}
+			i = next - 1
+
+			// 未加引号的展开结果按空白字符分词，空值不产生新的参数
+			fields := strings.Fields(value)
+			if len(fields) == 0 {
+				continue
+			}
+			if value[0] == ' ' || value[0] == '\t' || value[0] == '\n' {
+				flush()
+			}
+			for j, f := range fields {
+				if j > 0 {
+					flush()
+				}
+				word.WriteString(f)
+				inWord = true
+			}
+			if last := value[len(value)-1]; last == ' ' || last == '\t' || last == '\n' {
+				flush()
+			}
+
+		case strings.ContainsRune("|&;<>()`*?[", c) && !literal:
+			return nil, true, nil
+
+		case (c == '~' || c == '#') && !inWord && !literal:
+			return nil, true, nil
+
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+
+	flush()
+
+	return args, false, nil
+}
+
+// expandVar 展开 runes[start] 位置开始的变量引用
+//
+// 返回展开后的值、变量引用之后的下一个位置，以及是否能够处理这种写法。
+// 对于 $(...)、$1、$@ 这类需要 shell 才能处理的写法返回 ok = false。
+func expandVar(runes []rune, start int, lookup func(string) (string, bool)) (value string, next int, ok bool) {
+	i := start + 1
+	if i >= len(runes) {
+		// 行尾单独的 $ 按字面量处理
+		return "$", i, true
+	}
+
+	if runes[i] == '{' {
+		end := indexRune(runes, i+1, '}')
+		if end < 0 {
+			return "", 0, false
+		}
+
+		expr := string(runes[i+1 : end])
+		name, def, hasDef := expr, "", false
+		useDefaultOnEmpty := false
+
+		if n, d, found := strings.Cut(expr, ":-"); found {
+			name, def, hasDef, useDefaultOnEmpty = n, d, true, true
+		} else if n, d, found := strings.Cut(expr, "-"); found {
+			name, def, hasDef = n, d, true
+		}
+
+		if !isVarName(name) {
+			return "", 0, false
+		}
+
+		v, present := lookup(name)
+		if hasDef && (!present || (useDefaultOnEmpty && v == "")) {
+			v = def
+		}
+
+		return v, end + 1, true
+	}
+
+	if !isVarStart(runes[i]) {
+		return "", 0, false
+	}
+
+	j := i
+	for j < len(runes) && isVarChar(runes[j]) {
+		j++
+	}
+
+	v, _ := lookup(string(runes[i:j]))
+
+	return v, j, true
+}
+
+func indexRune(runes []rune, from int, r rune) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func isVarStart(c rune) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isVarChar(c rune) bool {
+	return isVarStart(c) || (c >= '0' && c <= '9')
+}
+
+func isVarName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, c := range name {
+		if i == 0 && !isVarStart(c) {
+			return false
+		}
+		if !isVarChar(c) {
+			return false
+		}
+	}
+
+	return true
+}
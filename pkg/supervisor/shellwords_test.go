@@ -0,0 +1,169 @@
+package supervisor
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+var testEnv = []string{
+	"PORT=5000",
+	"WORKERS=4",
+	"EMPTY=",
+	"FLAGS=-v  --debug",
+	"NAME=old",
+	"NAME=new world",
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want []string
+	}{
+		{name: "words", line: "bin/web --port 80", want: []string{"bin/web", "--port", "80"}},
+		{name: "double spaces", line: "bin/web  --port \t 80  ", want: []string{"bin/web", "--port", "80"}},
+		{name: "single quotes", line: `echo 'a  b' '$PORT' '\n'`, want: []string{"echo", "a  b", "$PORT", `\n`}},
+		{name: "double quotes", line: `echo "a  b" "$PORT" "x\"y" "\$PORT" "a\b"`, want: []string{"echo", "a  b", "5000", `x"y`, "$PORT", `a\b`}},
+		{name: "empty quotes", line: `echo "" ''`, want: []string{"echo", "", ""}},
+		{name: "adjacent quotes", line: `echo a"b c"'d'`, want: []string{"echo", "ab cd"}},
+		{name: "backslash", line: `echo a\ b \"c\" \$PORT`, want: []string{"echo", "a b", `"c"`, "$PORT"}},
+		{name: "line continuation", line: "echo a\\\nb", want: []string{"echo", "ab"}},
+		{name: "variables", line: "web -p $PORT -w ${WORKERS}", want: []string{"web", "-p", "5000", "-w", "4"}},
+		{name: "variable in word", line: "web --bind=0.0.0.0:$PORT", want: []string{"web", "--bind=0.0.0.0:5000"}},
+		{name: "last value wins", line: `echo "$NAME"`, want: []string{"echo", "new world"}},
+		{name: "unquoted split", line: "web $FLAGS end", want: []string{"web", "-v", "--debug", "end"}},
+		{name: "unquoted empty", line: "web $EMPTY $MISSING end", want: []string{"web", "end"}},
+		{name: "quoted empty", line: `web "$MISSING" end`, want: []string{"web", "", "end"}},
+		{name: "default when unset", line: "web ${MISSING:-3000} ${MISSING-x}", want: []string{"web", "3000", "x"}},
+		{name: "default when empty", line: "web ${EMPTY:-3000} ${EMPTY-x}.", want: []string{"web", "3000", "."}},
+		{name: "default when set", line: "web ${PORT:-3000}", want: []string{"web", "5000"}},
+		{name: "lone dollar", line: "echo cost $", want: []string{"echo", "cost", "$"}},
+		{name: "empty line", line: "   ", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, needShell, err := splitCommand(tt.line, envLookup(testEnv), false)
+			if err != nil || needShell {
+				t.Fatalf("splitCommand(%q) needShell = %v, err = %v", tt.line, needShell, err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("splitCommand(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitCommandNeedShell(t *testing.T) {
+	lines := []string{
+		"web | tee out.log",
+		"web > out.log",
+		"web && worker",
+		"web; worker",
+		"web &",
+		"echo $(date)",
+		"echo `date`",
+		`echo "$(date)"`,
+		"echo \"`date`\"",
+		"echo $1",
+		"echo ${1}",
+		"ls *.log",
+		"ls file?",
+		"cd ~",
+		"web # comment",
+		"(web)",
+	}
+
+	for _, line := range lines {
+		if _, needShell, err := splitCommand(line, envLookup(testEnv), false); err != nil || !needShell {
+			t.Errorf("splitCommand(%q) needShell = %v, err = %v, want shell", line, needShell, err)
+		}
+	}
+}
+
+func TestSplitCommandLiteral(t *testing.T) {
+	got, needShell, err := splitCommand("web a|b *.log ~ #x $(date) $PORT", envLookup(testEnv), true)
+	if err != nil || needShell {
+		t.Fatalf("needShell = %v, err = %v", needShell, err)
+	}
+
+	want := []string{"web", "a|b", "*.log", "~", "#x", "$(date)", "5000"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestSplitCommandUnterminated(t *testing.T) {
+	for _, line := range []string{`echo "abc`, `echo 'abc`, `echo "a'b`} {
+		if _, _, err := splitCommand(line, envLookup(nil), false); !errors.Is(err, errUnterminatedQuote) {
+			t.Errorf("splitCommand(%q) err = %v, want errUnterminatedQuote", line, err)
+		}
+	}
+}
+
+func TestExpandVar(t *testing.T) {
+	tests := []struct {
+		expr  string
+		value string
+		next  int
+		ok    bool
+	}{
+		{expr: "$PORT", value: "5000", next: 5, ok: true},
+		{expr: "$PORT/x", value: "5000", next: 5, ok: true},
+		{expr: "${PORT}x", value: "5000", next: 7, ok: true},
+		{expr: "$MISSING", value: "", next: 8, ok: true},
+		{expr: "${MISSING:-a b}", value: "a b", next: 15, ok: true},
+		{expr: "${EMPTY:-d}", value: "d", next: 11, ok: true},
+		{expr: "${EMPTY-d}", value: "", next: 10, ok: true},
+		{expr: "$", value: "$", next: 1, ok: true},
+		{expr: "${PORT", ok: false},
+		{expr: "${}", ok: false},
+		{expr: "${1}", ok: false},
+		{expr: "$1", ok: false},
+		{expr: "$@", ok: false},
+		{expr: "$(date)", ok: false},
+	}
+
+	for _, tt := range tests {
+		value, next, ok := expandVar([]rune(tt.expr), 0, envLookup(testEnv))
+		if ok != tt.ok {
+			t.Errorf("expandVar(%q) ok = %v, want %v", tt.expr, ok, tt.ok)
+			continue
+		}
+		if ok && (value != tt.value || next != tt.next) {
+			t.Errorf("expandVar(%q) = %q, %d, want %q, %d", tt.expr, value, next, tt.value, tt.next)
+		}
+	}
+}
+
+func TestBuildCmdLine(t *testing.T) {
+	tests := []struct {
+		name  string
+		line  string
+		shell string
+		want  []string
+	}{
+		{name: "auto", line: "web -p $PORT", want: []string{"web", "-p", "5000"}},
+		{name: "auto fallback", line: "web | tee log", want: []string{"sh", "-c", "web | tee log"}},
+		{name: "always", line: "web -p $PORT", shell: "true", want: []string{"sh", "-c", "web -p $PORT"}},
+		{name: "custom shell", line: "web", shell: "/bin/bash", want: []string{"/bin/bash", "-c", "web"}},
+		{name: "never", line: "web a|b", shell: "false", want: []string{"web", "a|b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildCmdLine(tt.line, tt.shell, testEnv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("buildCmdLine(%q, %q) = %q, want %q", tt.line, tt.shell, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := buildCmdLine("  ", "", testEnv); err == nil {
+		t.Fatal("expected an error for an empty command")
+	}
+}
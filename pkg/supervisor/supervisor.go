@@ -71,6 +71,7 @@ var maxCpus = runtime.NumCPU()
 //	AfterStart: 启动后回调函数（仅前台模式）
 //	StartedAt: Supervisor 启动时间
 //	Pid: Supervisor 进程 PID
+//	ExitCode: 前台模式下因进程失败退出时，失败进程的退出码
 //	mu: 读写锁，保护内部状态
 //	logger: 日志记录器
 //	projectTable: 项目表，管理所有项目
@@ -79,11 +80,15 @@ type Supervisor struct {
 	AfterStart func()    // 启动后回调函数
 	StartedAt  time.Time // 启动时间
 	Pid        int       // Supervisor 进程 PID
+	ExitCode   int       // 前台模式下 spm 的退出码
 
 	mu           sync.RWMutex       // 读写锁
 	logger       *zap.SugaredLogger // 日志记录器
 	projectTable *ProjectTable      // 项目表
 	procList     *ProcList          // 进程列表，存放进程的顺序ID
+	stopOnce     sync.Once          // 保证 stopping 只关闭一次
+	stopping     chan struct{}      // 请求 daemon 退出时关闭
+	audit        *auditLog          // 审计日志，没有开启时为 nil
 }
 
 // NewSupervisor 创建新的 Supervisor 实例
@@ -127,9 +132,21 @@ func NewSupervisor() *Supervisor {
 			table: make(map[string]*Project),
 		},
 		procList: NewProcList(),
+		stopping: make(chan struct{}),
 	}
 }
 
+// requestStop 请求 daemon 退出，用于 HTTP API 和远程控制的 shutdown 请求，可以重复调用
+//
+// 注意事项：
+//
+//	daemon 退出时会关闭 utils.StopChan，不能向它发送信号，否则重复的 shutdown 请求会导致 panic
+func (sv *Supervisor) requestStop() {
+	sv.stopOnce.Do(func() {
+		close(sv.stopping)
+	})
+}
+
 func (sv *Supervisor) GetProcByName(fullName string) *Process {
 	namePair := strings.Split(fullName, "::")
 	appName := namePair[0]
@@ -0,0 +1,307 @@
+// spm 的 web 界面，只使用 /api/v1 下的 HTTP API
+"use strict";
+
+const API = "/api/v1";
+const MAX_LOG_LINES = 2000;
+const TOKEN_KEY = "spm-token";
+
+const openHistory = new Set(); // 展开了退出记录的进程
+let refreshTimer = null;
+let logSource = null;
+
+function $(sel) {
+  return document.querySelector(sel);
+}
+
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs || {})) {
+    if (k === "class") node.className = v;
+    else if (k.startsWith("on")) node.addEventListener(k.slice(2), v);
+    else node.setAttribute(k, v);
+  }
+  for (const c of children) {
+    if (c !== null && c !== undefined) node.append(c);
+  }
+  return node;
+}
+
+function toast(text, isError) {
+  const t = $("#toast");
+  t.textContent = text;
+  t.className = isError ? "error" : "";
+  t.hidden = false;
+  clearTimeout(toast.timer);
+  toast.timer = setTimeout(() => { t.hidden = true; }, 4000);
+}
+
+function formatTime(s) {
+  const t = new Date(s);
+  return t.getFullYear() > 1 ? t.toLocaleString() : "";
+}
+
+function formatUptime(p) {
+  if (p.status !== "Running") return "";
+  const start = new Date(p.start_at);
+  if (start.getFullYear() <= 1) return "";
+  let sec = Math.max(0, Math.floor((Date.now() - start) / 1000));
+  const parts = [];
+  for (const [unit, n] of [["d", 86400], ["h", 3600], ["m", 60]]) {
+    if (sec >= n) {
+      parts.push(Math.floor(sec / n) + unit);
+      sec %= n;
+    }
+  }
+  parts.push(sec + "s");
+  return parts.slice(0, 2).join(" ");
+}
+
+// readToken 读取 daemon 的令牌，打开 /ui/#token=<令牌> 时保存到 sessionStorage，
+// 并从地址栏中去掉令牌。令牌在 ~/.spm/spm.http.token 中，只有 daemon 的用户能读取
+function readToken() {
+  const m = location.hash.match(/token=([0-9a-f]+)/);
+  if (m) {
+    sessionStorage.setItem(TOKEN_KEY, m[1]);
+    history.replaceState(null, "", location.pathname + location.search);
+  }
+  return sessionStorage.getItem(TOKEN_KEY) || "";
+}
+
+function askToken() {
+  const t = prompt("Token of the spm daemon (in ~/.spm/spm.http.token):", "");
+  if (t !== null) sessionStorage.setItem(TOKEN_KEY, t.trim());
+  return sessionStorage.getItem(TOKEN_KEY) || "";
+}
+
+// withToken 在 EventSource 的地址中加上令牌，EventSource 无法设置请求头
+function withToken(url) {
+  const sep = url.includes("?") ? "&" : "?";
+  return `${url}${sep}token=${encodeURIComponent(readToken())}`;
+}
+
+// apiFetch 调用 HTTP API，请求头中带上令牌，令牌失效时清除保存的令牌
+async function apiFetch(url, options) {
+  const opts = Object.assign({}, options);
+  opts.headers = Object.assign({ "X-Spm-Token": readToken() }, opts.headers);
+  const res = await fetch(url, opts);
+  if (res.status === 401) sessionStorage.removeItem(TOKEN_KEY);
+  return res;
+}
+
+function processPath(project, name) {
+  let path = `${API}/projects/${encodeURIComponent(project)}`;
+  if (name) path += `/processes/${encodeURIComponent(name)}`;
+  return path;
+}
+
+// post 发送 start、stop、restart 请求，完成后刷新进程列表
+async function post(project, name, action, button) {
+  button.disabled = true;
+  try {
+    const res = await apiFetch(`${processPath(project, name)}/${action}`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: "{}",
+    });
+    const body = await res.json();
+    toast(`${name || project}: ${body.message}`, !res.ok);
+  } catch (err) {
+    toast(`${action} failed: ${err}`, true);
+  } finally {
+    button.disabled = false;
+    refresh();
+  }
+}
+
+function historyRow(p, key) {
+  const rows = (p.history || []).slice().reverse().map((h) => el("tr", null,
+    el("td", null, String(h.pid)),
+    el("td", null, formatTime(h.start_at)),
+    el("td", null, formatTime(h.stop_at)),
+    el("td", null, h.signal ? `${h.exit_code} (${h.signal})` : String(h.exit_code)),
+    el("td", null, el("pre", null, (h.tail || []).join("\n"))),
+  ));
+
+  const body = rows.length
+    ? el("table", null,
+        el("thead", null, el("tr", null,
+          el("th", null, "PID"), el("th", null, "Started"), el("th", null, "Exited"),
+          el("th", null, "Exit code"), el("th", null, "Last output"))),
+        el("tbody", null, ...rows))
+    : el("p", { class: "empty" }, "No exits recorded.");
+
+  const row = el("tr", { class: "history" }, el("td", { colspan: "7" }, body));
+  row.hidden = !openHistory.has(key);
+  return row;
+}
+
+function processRows(project, p) {
+  const key = `${project}::${p.name}`;
+  const history = historyRow(p, key);
+
+  const button = (label, action) => el("button", {
+    type: "button",
+    onclick: (ev) => post(project, p.name, action, ev.target),
+  }, label);
+
+  const row = el("tr", null,
+    el("td", null, p.name),
+    el("td", null, el("span", { class: `state state-${p.status}` }, p.status)),
+    el("td", null, p.pid ? String(p.pid) : ""),
+    el("td", null, p.port ? String(p.port) : ""),
+    el("td", null, formatUptime(p)),
+    el("td", null, p.ready ? "yes" : "no"),
+    el("td", { class: "buttons" },
+      button("Start", "start"),
+      button("Stop", "stop"),
+      button("Restart", "restart"),
+      el("button", { type: "button", onclick: () => openLogs(project, p.name) }, "Logs"),
+      el("button", {
+        type: "button",
+        onclick: () => {
+          history.hidden = !history.hidden;
+          if (history.hidden) openHistory.delete(key);
+          else openHistory.add(key);
+        },
+      }, `History (${(p.history || []).length})`),
+    ),
+  );
+
+  return [row, history];
+}
+
+function render(projects) {
+  const root = $("#projects");
+  root.replaceChildren();
+
+  if (!projects.length) {
+    root.append(el("p", { class: "empty" }, "No projects. Run spm start in a directory with a Procfile."));
+    return;
+  }
+
+  const tpl = $("#project-tpl");
+  for (const proj of projects) {
+    const node = tpl.content.firstElementChild.cloneNode(true);
+    node.querySelector(".project-name").textContent = proj.name;
+    node.querySelector(".project-dir").textContent = proj.work_dir;
+
+    for (const b of node.querySelectorAll(".actions button")) {
+      const action = b.dataset.action;
+      b.addEventListener("click", () => {
+        if (action === "logs") openLogs(proj.name, "");
+        else post(proj.name, "", action, b);
+      });
+    }
+
+    const tbody = node.querySelector("tbody");
+    for (const p of proj.processes) {
+      tbody.append(...processRows(proj.name, p));
+    }
+
+    root.append(node);
+  }
+}
+
+async function load() {
+  try {
+    const res = await apiFetch(`${API}/projects`);
+    const body = await res.json();
+    if (!res.ok) throw new Error(body.message);
+    render(body);
+  } catch (err) {
+    toast(`Cannot load projects: ${err.message || err}`, true);
+  }
+}
+
+// refresh 合并短时间内的多次刷新请求
+function refresh() {
+  clearTimeout(refreshTimer);
+  refreshTimer = setTimeout(load, 200);
+}
+
+// watchEvents 订阅生命周期事件，有事件时刷新进程列表，断开后 EventSource 会自动重连
+function watchEvents() {
+  const conn = $("#conn");
+  const source = new EventSource(withToken(`${API}/events`));
+
+  source.onopen = () => {
+    conn.textContent = "live";
+    conn.className = "conn on";
+    refresh();
+  };
+  source.onerror = () => {
+    conn.textContent = "reconnecting";
+    conn.className = "conn off";
+  };
+  source.addEventListener("event", refresh);
+  return source;
+}
+
+function appendLog(line) {
+  const body = $("#logs-body");
+  const time = new Date(line.time);
+  const prefix = time.getFullYear() > 1 ? `${time.toLocaleTimeString()} ` : "";
+
+  body.append(el("div", { class: line.stream },
+    el("span", { class: "meta" }, `${prefix}${line.process} | `), line.line));
+
+  while (body.childElementCount > MAX_LOG_LINES) {
+    body.firstElementChild.remove();
+  }
+  if ($("#logs-follow").checked) {
+    body.scrollTop = body.scrollHeight;
+  }
+}
+
+function closeLogs() {
+  if (logSource) {
+    logSource.close();
+    logSource = null;
+  }
+  $("#logs").hidden = true;
+}
+
+function openLogs(project, name) {
+  closeLogs();
+
+  $("#logs-title").textContent = name ? `${project}::${name}` : project;
+  $("#logs-body").replaceChildren();
+  $("#logs").hidden = false;
+  $("#logs").scrollIntoView({ behavior: "smooth" });
+
+  const source = new EventSource(withToken(`${processPath(project, name)}/logs?follow&lines=200`));
+  source.addEventListener("log", (ev) => appendLog(JSON.parse(ev.data)));
+  source.addEventListener("end", (ev) => {
+    // 服务端结束了日志流，不让 EventSource 自动重连
+    const end = JSON.parse(ev.data);
+    if (end.code !== 200) toast(end.message, true);
+    source.close();
+  });
+  source.onerror = () => {
+    if (source.readyState === EventSource.CLOSED) toast("Cannot read logs", true);
+  };
+
+  logSource = source;
+}
+
+$("#logs-close").addEventListener("click", closeLogs);
+$("#logs-clear").addEventListener("click", () => $("#logs-body").replaceChildren());
+
+// 令牌变化之后重新订阅事件
+let events = null;
+function start() {
+  if (events) events.close();
+  events = watchEvents();
+  load();
+}
+
+$("#token").addEventListener("click", () => {
+  askToken();
+  start();
+});
+
+// TCP 地址上的所有 API 请求都需要 daemon 的令牌，unix socket 上不检查令牌
+readToken() || askToken();
+start();
+// 定时刷新运行时间
+setInterval(refresh, 10000);
@@ -0,0 +1,33 @@
+// 内嵌在 daemon 中的 web 界面
+//
+// web 界面的静态文件在 web 目录中，编译时通过 embed.FS 打包进 spm，由 HTTP API 的
+// 监听地址在 /ui/ 下提供。界面只调用 /api/v1 下的接口，进程状态通过 /api/v1/events
+// 的 SSE 流实时刷新。
+//
+// 界面对 /api/v1 的请求在 X-Spm-Token 请求头中带上 daemon 的令牌，EventSource 无法设置请求头，
+// 令牌放在 token 查询参数中，由 checkRequest 检查。令牌不写入页面，否则本机的任何用户都能从页面中
+// 读到令牌；打开 /ui/#token=<令牌> 时界面从地址中读取令牌并保存在 sessionStorage 中，也可以点击 Token 按钮输入。
+
+package supervisor
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+const webUIPrefix = "/ui/"
+
+//go:embed web
+var webFiles embed.FS
+
+// handleWebUI 在 HTTP API 的路由中注册 web 界面，访问根路径时跳转到 /ui/
+func (api *httpAPI) handleWebUI() {
+	files, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+
+	api.mux.Handle("GET "+webUIPrefix, http.StripPrefix(webUIPrefix, http.FileServerFS(files)))
+	api.mux.Handle("GET /{$}", http.RedirectHandler(webUIPrefix, http.StatusFound))
+}
@@ -17,6 +17,9 @@ var DaemonLogFilePath = getDaemonPath("log")
 var DaemonPidFilePath = getDaemonPath("pid")
 var DaemonSockFilePath = getDaemonPath("sock")
 var DaemonDumpFilePath = getDaemonPath("dump")
+var DaemonHTTPSockFilePath = getDaemonPath("http.sock")
+var DaemonHTTPTokenFilePath = getDaemonPath("http.token")
+var DaemonAuditFilePath = getDaemonPath("audit.log")
 
 func getHome() string {
 	return fmt.Sprintf("%s/.spm", os.Getenv("HOME"))
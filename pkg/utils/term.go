@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"hash/fnv"
+	"os"
+)
+
+// colorPalette 是区分不同进程输出时使用的 ANSI 颜色
+var colorPalette = []string{"36", "33", "32", "35", "34", "96", "93", "92", "95", "94"}
+
+// ColorEnabled 判断输出到 f 时是否使用 ANSI 颜色
+//
+// 设置了 NO_COLOR 环境变量，或者 f 不是终端时不使用颜色
+func ColorEnabled(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// ColorFor 根据名称返回一个固定的颜色，同一个名称每次得到的颜色相同
+func ColorFor(name string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+
+	return colorPalette[h.Sum32()%uint32(len(colorPalette))]
+}
+
+// Colorize 用 ANSI 颜色包裹文本
+func Colorize(color string, text string) string {
+	return "\033[" + color + "m" + text + "\033[0m"
+}